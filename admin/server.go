@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"TetrisSvr/config"
+	"TetrisSvr/game"
+	"TetrisSvr/history"
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/logging"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var log = logging.For(logging.Admin)
+
+// IRoomManager 管理接口依赖的房间管理器功能
+// 实现需要保证这些方法可以在HTTP处理协程中并发调用
+type IRoomManager interface {
+	Rooms() ([]game.RoomInfo, error)
+	Room(roomID string) (game.RoomInfo, error)
+	Players() ([]game.PlayerInfo, error)
+	Games() ([]game.GameInfo, error)
+	EndGame(gameID string) error
+	KickPlayer(playerID string) error
+	CloseRoom(roomID string) error
+}
+
+// IReloader 重新加载配置文件
+type IReloader interface {
+	Reload() (config.ReloadResult, error)
+}
+
+// Server 管理用的HTTP服务器
+type Server struct {
+	mgr      IRoomManager
+	reloader IReloader
+	services game.Services
+	srv      *http.Server
+}
+
+// NewServer 创建管理接口
+// reloader为空时不提供重新加载配置的接口，services中为空的排行榜和比赛记录不提供对应的接口
+func NewServer(addr string, mgr IRoomManager, reloader IReloader, services game.Services) *Server {
+	s := &Server{mgr: mgr, reloader: reloader, services: services}
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Handler 返回管理接口的路由
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms", s.handleRooms)
+	mux.HandleFunc("GET /rooms/{id}", s.handleRoom)
+	mux.HandleFunc("POST /rooms/{id}/close", s.handleCloseRoom)
+	mux.HandleFunc("GET /players", s.handlePlayers)
+	mux.HandleFunc("POST /players/{id}/kick", s.handleKickPlayer)
+	mux.HandleFunc("GET /games", s.handleGames)
+	mux.HandleFunc("POST /games/{id}/end", s.handleEndGame)
+	if s.reloader != nil {
+		mux.HandleFunc("POST /config/reload", s.handleReload)
+	}
+	if s.services.Leaderboard != nil {
+		mux.HandleFunc("GET /leaderboard/seasons", s.handleSeasons)
+		mux.HandleFunc("POST /leaderboard/seasons", s.handleStartSeason)
+		mux.HandleFunc("GET /leaderboard/{mode}", s.handleLeaderboard)
+		mux.HandleFunc("GET /leaderboard/{mode}/players/{id}", s.handleLeaderboardPlayer)
+	}
+	if s.services.History != nil {
+		mux.HandleFunc("GET /players/{id}/stats", s.handlePlayerStats)
+		mux.HandleFunc("GET /players/{id}/matches", s.handleMatches)
+		mux.HandleFunc("GET /matches", s.handleMatches)
+		mux.HandleFunc("GET /matches/{id}", s.handleMatch)
+	}
+	return mux
+}
+
+// Start 监听地址并在后台处理请求
+func (s *Server) Start() error {
+	lis, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Error("管理接口异常退出", logging.KeyError, err)
+		}
+	}()
+	log.Info("管理接口启动成功", "addr", lis.Addr().String())
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := s.mgr.Rooms()
+	writeResult(w, rooms, err)
+}
+
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	room, err := s.mgr.Room(r.PathValue("id"))
+	writeResult(w, room, err)
+}
+
+func (s *Server) handleCloseRoom(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, nil, s.mgr.CloseRoom(r.PathValue("id")))
+}
+
+func (s *Server) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	players, err := s.mgr.Players()
+	writeResult(w, players, err)
+}
+
+func (s *Server) handleKickPlayer(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, nil, s.mgr.KickPlayer(r.PathValue("id")))
+}
+
+func (s *Server) handleGames(w http.ResponseWriter, r *http.Request) {
+	games, err := s.mgr.Games()
+	writeResult(w, games, err)
+}
+
+func (s *Server) handleEndGame(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, nil, s.mgr.EndGame(r.PathValue("id")))
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	result, err := s.reloader.Reload()
+	if err != nil {
+		log.Warn("重新加载配置失败", logging.KeyError, err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	log.Info("重新加载配置", "applied", result.Applied, "restart_required", result.RestartRequired)
+	writeResult(w, result, nil)
+}
+
+func (s *Server) handleSeasons(w http.ResponseWriter, r *http.Request) {
+	seasons, err := s.services.Leaderboard.Seasons()
+	writeResult(w, seasons, err)
+}
+
+// handleStartSeason 结束当前赛季并开始新的赛季，请求体可以用{"end": "RFC3339时间"}指定结束时间
+func (s *Server) handleStartSeason(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		End time.Time `json:"end"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	season, err := s.services.Leaderboard.StartSeason(body.End)
+	if err == nil {
+		log.Info("开始新的排行榜赛季", "season", season.ID, "end", season.End)
+	}
+	writeResult(w, season, err)
+}
+
+// handleLeaderboard 分页查询排行榜，参数为season（默认当前赛季）、offset和limit（默认50，最多100）
+func (s *Server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	q, err := leaderboardQuery(r, 50)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	page, err := s.services.Leaderboard.Top(q)
+	writeResult(w, page, err)
+}
+
+// handleLeaderboardPlayer 查询玩家及其前后各limit名（默认10）
+func (s *Server) handleLeaderboardPlayer(w http.ResponseWriter, r *http.Request) {
+	q, err := leaderboardQuery(r, 10)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	page, err := s.services.Leaderboard.Around(q, r.PathValue("id"))
+	writeResult(w, page, err)
+}
+
+// maxPageSize 管理接口一次最多返回的成绩数
+const maxPageSize = 100
+
+// leaderboardQuery 解析排行榜查询的参数，limit未指定时为defaultLimit
+func leaderboardQuery(r *http.Request, defaultLimit int) (leaderboard.Query, error) {
+	q := leaderboard.Query{Mode: r.PathValue("mode"), Limit: defaultLimit}
+	err := intParams(r, map[string]*int{"season": &q.Season, "offset": &q.Offset, "limit": &q.Limit})
+	q.Limit = min(q.Limit, maxPageSize)
+	return q, err
+}
+
+// intParams 解析非负整数的查询参数，没有的参数保持原值
+func intParams(r *http.Request, params map[string]*int) error {
+	query := r.URL.Query()
+	for name, value := range params {
+		if !query.Has(name) {
+			continue
+		}
+		n, err := strconv.Atoi(query.Get(name))
+		if err != nil || n < 0 {
+			return fmt.Errorf("%s: must be a non-negative integer", name)
+		}
+		*value = n
+	}
+	return nil
+}
+
+// playerStats 玩家的累计统计以及由此计算的APM和PPS
+type playerStats struct {
+	history.Stats
+	APM float64 `json:"apm"`
+	PPS float64 `json:"pps"`
+}
+
+func (s *Server) handlePlayerStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.services.History.Stats(r.PathValue("id"))
+	writeResult(w, playerStats{Stats: stats, APM: stats.APM(), PPS: stats.PPS()}, err)
+}
+
+// handleMatches 从新到旧分页查询比赛，路径中有玩家ID时只查询该玩家的比赛，参数为offset和limit（默认20，最多100）
+func (s *Server) handleMatches(w http.ResponseWriter, r *http.Request) {
+	offset, limit := 0, 20
+	if err := intParams(r, map[string]*int{"offset": &offset, "limit": &limit}); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	matches, total, err := s.services.History.Matches(r.PathValue("id"), offset, min(limit, maxPageSize))
+	writeResult(w, map[string]any{"total": total, "matches": matches}, err)
+}
+
+func (s *Server) handleMatch(w http.ResponseWriter, r *http.Request) {
+	match, err := s.services.History.Match(r.PathValue("id"))
+	writeResult(w, match, err)
+}
+
+// writeResult 将结果或错误编码为JSON
+// 找不到对象时返回404，管理器已停止时返回503
+func writeResult(w http.ResponseWriter, result any, err error) {
+	status := http.StatusOK
+	switch {
+	case err == nil:
+	case errors.Is(err, game.ErrRoomNotFound),
+		errors.Is(err, game.ErrPlayerNotFound),
+		errors.Is(err, game.ErrGameNotFound),
+		errors.Is(err, leaderboard.ErrUnknownMode),
+		errors.Is(err, leaderboard.ErrSeasonNotFound),
+		errors.Is(err, history.ErrMatchNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, leaderboard.ErrSeasonEnd):
+		status = http.StatusBadRequest
+	case errors.Is(err, game.ErrRoomManagerStopped):
+		status = http.StatusServiceUnavailable
+	default:
+		status = http.StatusInternalServerError
+	}
+	if err != nil {
+		result = map[string]string{"error": err.Error()}
+	} else if result == nil {
+		result = map[string]string{"status": "ok"}
+	}
+	writeJSON(w, status, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("写入管理接口响应失败", logging.KeyError, err)
+	}
+}
@@ -0,0 +1,225 @@
+package admin
+
+import (
+	"TetrisSvr/config"
+	"TetrisSvr/game"
+	"TetrisSvr/history"
+	"TetrisSvr/leaderboard"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeManager 记录管理接口的调用
+type fakeManager struct {
+	rooms  []game.RoomInfo
+	kicked []string
+	closed []string
+	ended  []string
+}
+
+func (m *fakeManager) Rooms() ([]game.RoomInfo, error) { return m.rooms, nil }
+
+func (m *fakeManager) Room(roomID string) (game.RoomInfo, error) {
+	for _, r := range m.rooms {
+		if r.ID == roomID {
+			return r, nil
+		}
+	}
+	return game.RoomInfo{}, game.ErrRoomNotFound
+}
+
+func (m *fakeManager) Players() ([]game.PlayerInfo, error) { return nil, game.ErrRoomManagerStopped }
+
+func (m *fakeManager) Games() ([]game.GameInfo, error) {
+	return []game.GameInfo{{ID: "1", Status: game.PlayingGame, FrameNumber: 42}}, nil
+}
+
+func (m *fakeManager) EndGame(gameID string) error {
+	m.ended = append(m.ended, gameID)
+	return nil
+}
+
+func (m *fakeManager) KickPlayer(playerID string) error {
+	m.kicked = append(m.kicked, playerID)
+	return nil
+}
+
+func (m *fakeManager) CloseRoom(roomID string) error {
+	if _, err := m.Room(roomID); err != nil {
+		return err
+	}
+	m.closed = append(m.closed, roomID)
+	return nil
+}
+
+// fakeReloader 返回预设的重新加载结果
+type fakeReloader struct {
+	result config.ReloadResult
+	err    error
+}
+
+func (r *fakeReloader) Reload() (config.ReloadResult, error) { return r.result, r.err }
+
+func serve(t *testing.T, h http.Handler, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func TestQueries(t *testing.T) {
+	mgr := &fakeManager{rooms: []game.RoomInfo{{ID: "1", Status: game.WaitingRoom, Players: []string{"p1"}}}}
+	h := NewServer("127.0.0.1:0", mgr, nil, game.Services{}).Handler()
+
+	rec := serve(t, h, "GET", "/rooms/1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /rooms/1 = %d", rec.Code)
+	}
+	var room game.RoomInfo
+	if err := json.NewDecoder(rec.Body).Decode(&room); err != nil || room.ID != "1" {
+		t.Fatalf("GET /rooms/1 body = %+v, %v", room, err)
+	}
+
+	var games []game.GameInfo
+	rec = serve(t, h, "GET", "/games")
+	if err := json.NewDecoder(rec.Body).Decode(&games); err != nil || len(games) != 1 || games[0].FrameNumber != 42 {
+		t.Fatalf("GET /games body = %+v, %v", games, err)
+	}
+
+	if rec := serve(t, h, "GET", "/rooms/2"); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /rooms/2 = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serve(t, h, "GET", "/players"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("GET /players = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMutations(t *testing.T) {
+	mgr := &fakeManager{rooms: []game.RoomInfo{{ID: "1"}}}
+	h := NewServer("127.0.0.1:0", mgr, nil, game.Services{}).Handler()
+
+	for _, path := range []string{"/rooms/1/close", "/players/p1/kick", "/games/1/end"} {
+		if rec := serve(t, h, "POST", path); rec.Code != http.StatusOK {
+			t.Fatalf("POST %s = %d", path, rec.Code)
+		}
+	}
+	if len(mgr.closed) != 1 || len(mgr.kicked) != 1 || len(mgr.ended) != 1 {
+		t.Fatalf("manager calls: closed %v, kicked %v, ended %v", mgr.closed, mgr.kicked, mgr.ended)
+	}
+
+	// 修改操作只接受POST
+	if rec := serve(t, h, "GET", "/games/1/end"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /games/1/end = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestReload(t *testing.T) {
+	if rec := serve(t, NewServer("127.0.0.1:0", &fakeManager{}, nil, game.Services{}).Handler(), "POST", "/config/reload"); rec.Code != http.StatusNotFound {
+		t.Fatalf("reload without reloader = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	reloader := &fakeReloader{result: config.ReloadResult{
+		Applied:         []string{"room.max_players"},
+		RestartRequired: []string{"server.port"},
+	}}
+	h := NewServer("127.0.0.1:0", &fakeManager{}, reloader, game.Services{}).Handler()
+	rec := serve(t, h, "POST", "/config/reload")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /config/reload = %d", rec.Code)
+	}
+	var result config.ReloadResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 1 || len(result.RestartRequired) != 1 || result.RestartRequired[0] != "server.port" {
+		t.Fatalf("got %+v", result)
+	}
+
+	reloader.err = errors.New("game.tick_rate: must be between 1 and 1000")
+	if rec := serve(t, h, "POST", "/config/reload"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("failed reload = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	board := leaderboard.NewMemoryStore(leaderboard.Options{Orders: map[string]leaderboard.Order{"sprint": leaderboard.Ascending}})
+	for i, id := range []string{"a", "b", "c"} {
+		board.Submit(leaderboard.Entry{Mode: "sprint", PlayerID: id, Value: int64(40000 + i)})
+	}
+	h := NewServer("127.0.0.1:0", &fakeManager{}, nil, game.Services{Leaderboard: board}).Handler()
+
+	rec := serve(t, h, "GET", "/leaderboard/sprint?offset=1&limit=1")
+	var page leaderboard.Page
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /leaderboard/sprint = %d %s", rec.Code, rec.Body)
+	}
+	if page.Total != 3 || len(page.Entries) != 1 || page.Entries[0].PlayerID != "b" || page.Entries[0].Rank != 2 {
+		t.Fatalf("got %+v, want b on the second page", page)
+	}
+	if rec := serve(t, h, "GET", "/leaderboard/sprint/players/c?limit=1"); rec.Code != http.StatusOK {
+		t.Fatalf("GET around = %d", rec.Code)
+	}
+
+	if rec := serve(t, h, "POST", "/leaderboard/seasons"); rec.Code != http.StatusOK {
+		t.Fatalf("POST /leaderboard/seasons = %d %s", rec.Code, rec.Body)
+	}
+	var seasons []leaderboard.Season
+	rec = serve(t, h, "GET", "/leaderboard/seasons")
+	if err := json.Unmarshal(rec.Body.Bytes(), &seasons); err != nil || len(seasons) != 2 || !seasons[0].Archived {
+		t.Fatalf("seasons = %s", rec.Body)
+	}
+
+	for path, code := range map[string]int{
+		"/leaderboard/sprint?season=9":   http.StatusNotFound,
+		"/leaderboard/marathon":          http.StatusNotFound,
+		"/leaderboard/sprint?offset=abc": http.StatusBadRequest,
+	} {
+		if rec := serve(t, h, "GET", path); rec.Code != code {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, code)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	matches := history.NewMemoryStore()
+	for _, id := range []string{"g1", "g2", "g3"} {
+		matches.Record(history.Match{
+			GameID:     id,
+			Frames:     1800,
+			DurationMs: 60000,
+			Players:    []history.MatchPlayer{{ID: "p1", Place: 1, Frames: 1800, Pieces: 90}, {ID: "p2", Place: 2, Frames: 1800}},
+		})
+	}
+	h := NewServer("127.0.0.1:0", &fakeManager{}, nil, game.Services{History: matches}).Handler()
+
+	rec := serve(t, h, "GET", "/players/p1/stats")
+	var stats struct {
+		Games int     `json:"games"`
+		Wins  int     `json:"wins"`
+		PPS   float64 `json:"pps"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats.Games != 3 || stats.Wins != 3 || stats.PPS != 1.5 {
+		t.Fatalf("GET /players/p1/stats = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, h, "GET", "/players/p2/matches?offset=1&limit=1")
+	var page struct {
+		Total   int             `json:"total"`
+		Matches []history.Match `json:"matches"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || page.Total != 3 || len(page.Matches) != 1 || page.Matches[0].GameID != "g2" {
+		t.Fatalf("GET /players/p2/matches = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, h, "GET", "/matches/g1"); rec.Code != http.StatusOK {
+		t.Fatalf("GET /matches/g1 = %d", rec.Code)
+	}
+	if rec := serve(t, h, "GET", "/matches/g9"); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /matches/g9 = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := serve(t, h, "GET", "/matches?limit=-1"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("GET /matches?limit=-1 = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
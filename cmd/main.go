@@ -1,46 +1,319 @@
 package main
 
 import (
+	"TetrisSvr/admin"
+	"TetrisSvr/config"
 	"TetrisSvr/game"
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/history"
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
 	"TetrisSvr/network"
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
-
-	log "github.com/jeanphorn/log4go"
 )
 
+var log = logging.For(logging.Server)
+
 func main() {
-	ip := flag.String("ip", "0.0.0.0", "server listening IP")
-	port := flag.Int("port", 8080, "server listening port")
-	flag.Parse()
+	load, printConfig := parseFlags(os.Args[1:])
+	cfg, err := load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "配置错误:", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		if err := cfg.Encode(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err := logging.Setup(cfg.LoggingConfig(), os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "日志配置错误:", err)
+		os.Exit(2)
+	}
 
-	kcpAddr := fmt.Sprintf("%s:%d", *ip, *port)
+	kcpAddr := cfg.Addr()
+	netConfig := cfg.NetworkConfig()
 
-	config := &network.Config{
-		ReceiveChanSize: 1024,
-		ReceiveTimeout:  30 * time.Second,
-		SendChanSize:    1024,
-		SendTimeout:     30 * time.Second,
+	board, err := openLeaderboard(cfg)
+	if err != nil {
+		log.Error("加载排行榜失败", logging.KeyError, err)
+		os.Exit(1)
+	}
+	matches, err := openHistory(cfg)
+	if err != nil {
+		log.Error("加载比赛记录失败", logging.KeyError, err)
+		os.Exit(1)
 	}
-
 	ctx, cancel := context.WithCancel(context.Background())
-	handler := game.NewRoomManager(ctx, config, &game.UniqueIDRoomCreator{})
+	handler := game.NewRoomManager(ctx, netConfig, &game.UniqueIDRoomCreator{})
+	handler.SetLeaderboard(board)
+	if err := handler.SetHistory(matches); err != nil {
+		log.Error("加载积分失败", logging.KeyError, err)
+		os.Exit(1)
+	}
 	handler.Start()
-	server := network.NewServer(ctx, config, handler)
-	server.Server(kcpAddr)
+	if err := handler.ApplySettings(settings(cfg)); err != nil {
+		fmt.Fprintln(os.Stderr, "配置错误:", err)
+		os.Exit(2)
+	}
+	reloader := config.NewReloader(cfg, load, func(cfg *config.Config) error {
+		if err := logging.Setup(cfg.LoggingConfig(), os.Stderr); err != nil {
+			return err
+		}
+		return handler.ApplySettings(settings(cfg))
+	})
+	server := network.NewServer(ctx, netConfig, handler)
+	if err := server.Server(kcpAddr); err != nil {
+		log.Error("服务器启动失败", logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	var adminServer *admin.Server
+	if cfg.Admin.Port != 0 {
+		adminServer = admin.NewServer(cfg.Admin.Addr(), handler, reloader, handler.Services())
+		if err := adminServer.Start(); err != nil {
+			log.Error("管理接口启动失败", logging.KeyError, err)
+			os.Exit(1)
+		}
+	}
+
+	var metricsServer *http.Server
+	if cfg.Metrics.Port != 0 {
+		metricsServer, err = metrics.Serve(cfg.Metrics.Addr())
+		if err != nil {
+			log.Error("指标接口启动失败", logging.KeyError, err)
+			os.Exit(1)
+		}
+	}
 
 	// 设置信号处理
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			reload(reloader)
+		}
+	}()
 
-	log.Info("服务器启动成功，监听地址: %s", kcpAddr)
+	log.Info("服务器启动成功", "addr", kcpAddr)
 
 	// 等待终止信号
 	<-sigCh
+	status := shutdown(server, handler, cancel, sigCh, time.Duration(cfg.Server.ShutdownTimeout))
+	if adminServer != nil {
+		adminCtx, adminCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := adminServer.Shutdown(adminCtx); err != nil {
+			log.Warn("关闭管理接口失败", logging.KeyError, err)
+		}
+		adminCancel()
+	}
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if err := board.Close(); err != nil {
+		log.Warn("关闭排行榜失败", logging.KeyError, err)
+	}
+	if err := matches.Close(); err != nil {
+		log.Warn("关闭比赛记录失败", logging.KeyError, err)
+	}
+	os.Exit(status)
+}
+
+// openLeaderboard 打开配置的排行榜文件，没有配置时使用内存中的排行榜
+func openLeaderboard(cfg *config.Config) (leaderboard.Store, error) {
+	opts := leaderboard.Options{
+		Orders:       game.LeaderboardOrders,
+		SeasonLength: time.Duration(cfg.Leaderboard.SeasonLength),
+	}
+	if cfg.Leaderboard.Path == "" {
+		return leaderboard.NewMemoryStore(opts), nil
+	}
+	return leaderboard.NewBoltStore(cfg.Leaderboard.Path, opts)
+}
+
+// settings 返回配置中可以在运行时修改的房间和游戏参数
+func settings(cfg *config.Config) game.Settings {
+	return game.Settings{
+		MaxPlayers:        cfg.Room.MaxPlayers,
+		RoyaleMaxPlayers:  cfg.Room.RoyaleMaxPlayers,
+		RoyaleFullBoards:  cfg.Game.RoyaleFullBoards,
+		FrameInterval:     cfg.FrameInterval(),
+		MinInputDelay:     int32(cfg.Game.MinInputDelay),
+		MaxInputDelay:     int32(cfg.Game.MaxInputDelay),
+		HashInterval:      int32(cfg.Game.HashInterval),
+		StatsInterval:     int32(cfg.Game.StatsInterval),
+		MaxPause:          time.Duration(cfg.Game.MaxPause),
+		LoadTimeout:       time.Duration(cfg.Game.LoadTimeout),
+		LoadTimeoutPolicy: cfg.Game.LoadTimeoutPolicy,
+		DisconnectGrace:   time.Duration(cfg.Game.DisconnectGrace),
+		ReplayDir:         cfg.Game.ReplayDir,
+		CheatPolicy:       cfg.Game.CheatPolicy,
+		ValidateInput:     cfg.Game.ValidateInput,
+		MaxOpsPerFrame:    cfg.Game.MaxOpsPerFrame,
+		MaxPPS:            cfg.Game.MaxPPS,
+		Rules:             rules(cfg),
+	}
+}
+
+// rules 将配置中的规则按帧率换算为帧数
+func rules(cfg *config.Config) game.MatchRules {
+	interval := cfg.FrameInterval()
+	r := game.MatchRules{
+		LockDelay:     int32(cfg.Game.Rules.LockDelay),
+		GarbageCancel: cfg.Game.Rules.GarbageCancel,
+		GarbageDelay:  int32(cfg.Game.Rules.GarbageDelay),
+		Attack: &tetris.AttackTable{
+			Lines:        cfg.Game.Rules.Attack.Lines,
+			TSpin:        cfg.Game.Rules.Attack.TSpin,
+			TSpinMini:    cfg.Game.Rules.Attack.TSpinMini,
+			Combo:        cfg.Game.Rules.Attack.Combo,
+			BackToBack:   cfg.Game.Rules.Attack.BackToBack,
+			PerfectClear: cfg.Game.Rules.Attack.PerfectClear,
+		},
+	}
+	for _, step := range cfg.Game.Rules.Gravity {
+		r.Gravity = append(r.Gravity, game.GravityStep{
+			Frame:        int32(time.Duration(step.At) / interval),
+			FramesPerRow: int32(step.FramesPerRow),
+		})
+	}
+	return r
+}
+
+// openHistory 打开配置的比赛记录文件，没有配置时使用内存中的比赛记录
+func openHistory(cfg *config.Config) (history.Store, error) {
+	if cfg.History.Path == "" {
+		return history.NewMemoryStore(), nil
+	}
+	return history.NewBoltStore(cfg.History.Path)
+}
+
+// reload 收到SIGHUP时重新加载配置
+func reload(reloader *config.Reloader) {
+	result, err := reloader.Reload()
+	if err != nil {
+		log.Error("重新加载配置失败", logging.KeyError, err)
+		return
+	}
+	log.Info("重新加载配置", "applied", result.Applied)
+	if len(result.RestartRequired) > 0 {
+		log.Warn("部分配置需要重启才能生效", "restart_required", result.RestartRequired)
+	}
+}
+
+// parseFlags 解析命令行参数，返回加载配置的函数，重新加载时使用相同的参数
+// 优先级从低到高为：默认值、-config指定的配置文件、TETRIS_开头的环境变量、命令行参数
+func parseFlags(args []string) (load func() (*config.Config, error), printConfig bool) {
+	def := config.Default()
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	path := fs.String("config", "", "path to a YAML config file")
+	printCfg := fs.Bool("print-config", false, "print the effective configuration as YAML and exit")
+	ip := fs.String("ip", def.Server.IP, "server listening IP")
+	port := fs.Int("port", def.Server.Port, "server listening port")
+	adminIP := fs.String("admin-ip", def.Admin.IP, "admin HTTP API listening IP")
+	adminPort := fs.Int("admin-port", def.Admin.Port, "admin HTTP API listening port, 0 disables the admin API")
+	metricsIP := fs.String("metrics-ip", def.Metrics.IP, "Prometheus metrics listening IP")
+	metricsPort := fs.Int("metrics-port", def.Metrics.Port, "Prometheus metrics listening port, 0 disables /metrics")
+	shutdownTimeout := fs.Duration("shutdown-timeout", time.Duration(def.Server.ShutdownTimeout), "how long to wait for running games to end on shutdown")
+	logFormat := fs.String("log-format", def.Log.Format, "log output format: text or json")
+	logLevel := fs.String("log-level", def.Log.Level, "default log level: debug, info, warn or error")
+	logLevels := fs.String("log-levels", "", "per-subsystem log levels, e.g. network=debug,game=warn")
+	logSample := fs.Uint64("log-sample", def.Log.Sample, "log one in N high-frequency messages (frames, inputs, heartbeats) at debug level, 0 disables")
+	fs.Parse(args)
+
+	return func() (*config.Config, error) {
+		cfg, err := config.Load(*path, os.LookupEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		// 只有显式指定的参数才覆盖配置文件和环境变量
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "ip":
+				cfg.Server.IP = *ip
+			case "port":
+				cfg.Server.Port = *port
+			case "admin-ip":
+				cfg.Admin.IP = *adminIP
+			case "admin-port":
+				cfg.Admin.Port = *adminPort
+			case "metrics-ip":
+				cfg.Metrics.IP = *metricsIP
+			case "metrics-port":
+				cfg.Metrics.Port = *metricsPort
+			case "shutdown-timeout":
+				cfg.Server.ShutdownTimeout = config.Duration(*shutdownTimeout)
+			case "log-format":
+				cfg.Log.Format = *logFormat
+			case "log-level":
+				cfg.Log.Level = *logLevel
+			case "log-levels":
+				var levels map[string]string
+				if levels, err = config.ParseLevels(*logLevels); err == nil {
+					cfg.Log.Levels = levels
+				}
+			case "log-sample":
+				cfg.Log.Sample = *logSample
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}, *printCfg
+}
+
+// shutdown 优雅关闭服务器
+// 先拒绝新连接和新房间，等待进行中的游戏结束，超时或再次收到信号时强制结束游戏，
+// 最后关闭所有连接。所有游戏正常结束时返回0
+func shutdown(server *network.Server, handler *game.RoomManager, cancel context.CancelFunc, sigCh <-chan os.Signal, timeout time.Duration) int {
+	log.Info("开始关闭服务器", "timeout", timeout)
+	status := 0
+	server.StopAccepting()
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), timeout)
+	defer drainCancel()
+	go func() {
+		select {
+		case <-sigCh:
+			log.Warn("再次收到终止信号，立即关闭")
+			drainCancel()
+		case <-drainCtx.Done():
+		}
+	}()
+	if err := handler.Drain(drainCtx, "server shutting down"); err != nil {
+		log.Warn("等待游戏结束失败", logging.KeyError, err)
+		status = 1
+	}
+
+	// 取消根上下文，连接发出排队的消息后关闭
 	cancel()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := server.Wait(flushCtx); err != nil {
+		log.Warn("等待连接关闭失败", logging.KeyError, err)
+		status = 1
+	}
+	if err := server.Close(); err != nil {
+		log.Error("关闭监听失败", logging.KeyError, err)
+		status = 1
+	}
+	log.Info("服务器已关闭")
+	return status
 }
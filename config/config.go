@@ -0,0 +1,397 @@
+package config
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/network"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 服务器的完整配置
+// 加载顺序为：默认值、配置文件、环境变量，之后由命令行参数覆盖
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Network     NetworkConfig     `yaml:"network"`
+	KCP         KCPConfig         `yaml:"kcp"`
+	Room        RoomConfig        `yaml:"room"`
+	Game        GameConfig        `yaml:"game"`
+	Leaderboard LeaderboardConfig `yaml:"leaderboard"`
+	History     HistoryConfig     `yaml:"history"`
+	Log         LogConfig         `yaml:"log"`
+	Admin       EndpointConfig    `yaml:"admin"`
+	Metrics     EndpointConfig    `yaml:"metrics"`
+}
+
+type ServerConfig struct {
+	IP   string `yaml:"ip"`
+	Port int    `yaml:"port"`
+	// ShutdownTimeout 关闭时等待进行中游戏结束的时间
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+}
+
+type NetworkConfig struct {
+	ReceiveChanSize int32    `yaml:"receive_chan_size"`
+	ReceiveTimeout  Duration `yaml:"receive_timeout"`
+	SendChanSize    int32    `yaml:"send_chan_size"`
+	SendTimeout     Duration `yaml:"send_timeout"`
+	// PingInterval 服务器心跳间隔，0表示不发送心跳
+	PingInterval Duration `yaml:"ping_interval"`
+	// PingTimeout 心跳超时，超过该时间没有收到任何消息的连接会被关闭
+	PingTimeout Duration `yaml:"ping_timeout"`
+}
+
+// KCPConfig 见network.KCPConfig，值为0的字段使用kcp-go的默认值
+type KCPConfig struct {
+	NoDelay      int `yaml:"nodelay"`
+	Interval     int `yaml:"interval"`
+	Resend       int `yaml:"resend"`
+	NoCongestion int `yaml:"no_congestion"`
+	SendWindow   int `yaml:"send_window"`
+	RecvWindow   int `yaml:"recv_window"`
+	MTU          int `yaml:"mtu"`
+}
+
+type RoomConfig struct {
+	// MaxPlayers 每个房间的最大人数，0表示不限制
+	MaxPlayers int `yaml:"max_players"`
+	// RoyaleMaxPlayers 大逃杀房间的最大人数，0表示不限制
+	RoyaleMaxPlayers int `yaml:"royale_max_players"`
+}
+
+type GameConfig struct {
+	// TickRate 每秒的帧数
+	TickRate int `yaml:"tick_rate"`
+	// MinInputDelay和MaxInputDelay 根据玩家延迟计算的输入延迟帧数的范围
+	MinInputDelay int `yaml:"min_input_delay"`
+	MaxInputDelay int `yaml:"max_input_delay"`
+	// HashInterval 客户端上报状态哈希的帧间隔，0表示不检查是否同步
+	HashInterval int `yaml:"hash_interval"`
+	// ReplayDir 保存回放的目录，为空时不保存
+	ReplayDir string `yaml:"replay_dir"`
+	// CheatPolicy 玩家被标记后的处理：warn、kick或void
+	CheatPolicy string `yaml:"cheat_policy"`
+	// ValidateInput 按tetris.Op的编码校验输入并使用服务器模拟的结果，只有所有客户端都实现了tetris.RulesVersion的规则时才能开启
+	ValidateInput bool `yaml:"validate_input"`
+	// MaxOpsPerFrame 一帧中最多的操作数，MaxPPS 持续的每秒方块数上限，0表示不检查，都只在开启validate_input时检查
+	MaxOpsPerFrame int     `yaml:"max_ops_per_frame"`
+	MaxPPS         float64 `yaml:"max_pps"`
+	// Rules 开始游戏时随种子发给客户端的规则
+	Rules RulesConfig `yaml:"rules"`
+	// RoyaleFullBoards 大逃杀中每个玩家接收完整帧的棋盘数（包括自己），其余棋盘只接收概要
+	RoyaleFullBoards int `yaml:"royale_full_boards"`
+	// StatsInterval 广播玩家统计的帧间隔，0表示只在结束消息中附带；统计来自服务器模拟，不为0时需要开启validate_input
+	StatsInterval int `yaml:"stats_interval"`
+	// MaxPause 每次暂停的最长时间，房间的暂停规则不能超过，0表示不限制
+	MaxPause Duration `yaml:"max_pause"`
+	// LoadTimeout 等待所有玩家加载完成的时长，0表示一直等待
+	LoadTimeout Duration `yaml:"load_timeout"`
+	// LoadTimeoutPolicy 加载超时后的处理：start让没有加载完成的玩家弃权并开始游戏，abort取消游戏
+	LoadTimeoutPolicy string `yaml:"load_timeout_policy"`
+	// DisconnectGrace 玩家断线后等待重连的最长时间，房间的断线规则不能超过，0表示立即处理
+	DisconnectGrace Duration `yaml:"disconnect_grace"`
+}
+
+// RulesConfig 比赛规则，帧数都按game.tick_rate计算
+type RulesConfig struct {
+	// Gravity 重力曲线，每项从At开始方块每FramesPerRow帧下落一格
+	Gravity []GravityStepConfig `yaml:"gravity"`
+	// LockDelay 方块落地后锁定的帧数
+	LockDelay int `yaml:"lock_delay"`
+	// GarbageCancel 攻击是否先抵消自己待收的垃圾
+	GarbageCancel bool `yaml:"garbage_cancel"`
+	// GarbageDelay 攻击经过多少帧后进入对手的待收垃圾
+	GarbageDelay int `yaml:"garbage_delay"`
+	// Attack 消行发出的攻击行数
+	Attack AttackConfig `yaml:"attack"`
+}
+
+type GravityStepConfig struct {
+	At           Duration `yaml:"at"`
+	FramesPerRow int      `yaml:"frames_per_row"`
+}
+
+// AttackConfig 攻击表，列表按消行数或连击次数取值，超出长度的取最后一项
+type AttackConfig struct {
+	Lines        []int `yaml:"lines"`
+	TSpin        []int `yaml:"t_spin"`
+	TSpinMini    []int `yaml:"t_spin_mini"`
+	Combo        []int `yaml:"combo"`
+	BackToBack   int   `yaml:"back_to_back"`
+	PerfectClear int   `yaml:"perfect_clear"`
+}
+
+type LeaderboardConfig struct {
+	// Path 单人模式排行榜的bbolt文件，为空时只保存在内存中
+	Path string `yaml:"path"`
+	// SeasonLength 赛季长度，结束后自动开始下一个赛季，0表示赛季只能通过管理接口结束
+	SeasonLength Duration `yaml:"season_length"`
+}
+
+type HistoryConfig struct {
+	// Path 比赛记录、玩家统计和团队积分的bbolt文件，为空时只在内存中保存最近的比赛和积分
+	Path string `yaml:"path"`
+}
+
+type LogConfig struct {
+	Format string            `yaml:"format"`
+	Level  string            `yaml:"level"`
+	Levels map[string]string `yaml:"levels"`
+	Sample uint64            `yaml:"sample"`
+}
+
+// EndpointConfig HTTP接口的监听地址，Port为0表示不启用
+type EndpointConfig struct {
+	IP   string `yaml:"ip"`
+	Port int    `yaml:"port"`
+}
+
+// Addr 返回监听地址
+func (e EndpointConfig) Addr() string {
+	return fmt.Sprintf("%s:%d", e.IP, e.Port)
+}
+
+// Default 返回默认配置
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			IP:              "0.0.0.0",
+			Port:            8080,
+			ShutdownTimeout: Duration(5 * time.Minute),
+		},
+		Network: NetworkConfig{
+			ReceiveChanSize: 1024,
+			ReceiveTimeout:  Duration(30 * time.Second),
+			SendChanSize:    1024,
+			SendTimeout:     Duration(30 * time.Second),
+			PingInterval:    Duration(time.Second),
+			PingTimeout:     Duration(5 * time.Second),
+		},
+		Room: RoomConfig{
+			RoyaleMaxPlayers: 99,
+		},
+		Game: GameConfig{
+			TickRate:          30,
+			RoyaleFullBoards:  8,
+			MinInputDelay:     2,
+			MaxInputDelay:     10,
+			HashInterval:      60,
+			StatsInterval:     0,
+			MaxPause:          Duration(5 * time.Minute),
+			LoadTimeout:       Duration(30 * time.Second),
+			LoadTimeoutPolicy: "start",
+			DisconnectGrace:   Duration(30 * time.Second),
+			CheatPolicy:       "warn",
+			MaxOpsPerFrame:    16,
+			MaxPPS:            8,
+			Rules: RulesConfig{
+				Gravity: []GravityStepConfig{
+					{At: 0, FramesPerRow: 30},
+					{At: Duration(time.Minute), FramesPerRow: 15},
+					{At: Duration(2 * time.Minute), FramesPerRow: 5},
+				},
+				LockDelay:     15,
+				GarbageCancel: true,
+				Attack: AttackConfig{
+					Lines:        []int{0, 0, 1, 2, 4},
+					TSpin:        []int{0, 2, 4, 6},
+					TSpinMini:    []int{0, 0, 1},
+					Combo:        []int{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 4, 5},
+					BackToBack:   1,
+					PerfectClear: 10,
+				},
+			},
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+			Levels: map[string]string{},
+		},
+		Admin:   EndpointConfig{IP: "127.0.0.1"},
+		Metrics: EndpointConfig{IP: "127.0.0.1"},
+	}
+}
+
+// Load 返回默认配置依次应用配置文件和环境变量后的结果
+// path为空时不读取配置文件，lookup通常为os.LookupEnv
+func Load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Decode(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := ApplyEnv(cfg, lookup); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Decode 从YAML覆盖配置，未知字段视为错误
+func (c *Config) Decode(r io.Reader) error {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Encode 以YAML格式输出配置
+func (c *Config) Encode(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// Validate 检查配置是否合法，返回所有错误
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(validPort(c.Server.Port) && c.Server.Port != 0, "server.port: %d is not a valid port", c.Server.Port)
+	check(c.Server.ShutdownTimeout >= 0, "server.shutdown_timeout: must not be negative")
+	check(c.Network.ReceiveChanSize > 0, "network.receive_chan_size: must be positive")
+	check(c.Network.SendChanSize > 0, "network.send_chan_size: must be positive")
+	check(c.Network.ReceiveTimeout > 0, "network.receive_timeout: must be positive")
+	check(c.Network.SendTimeout > 0, "network.send_timeout: must be positive")
+	check(c.Network.PingInterval >= 0, "network.ping_interval: must not be negative")
+	check(c.Network.PingInterval == 0 || c.Network.PingTimeout > c.Network.PingInterval,
+		"network.ping_timeout: must be longer than network.ping_interval")
+	check(c.KCP.NoDelay == 0 || c.KCP.NoDelay == 1, "kcp.nodelay: must be 0 or 1")
+	check(c.KCP.Interval == 0 || (c.KCP.Interval >= 10 && c.KCP.Interval <= 5000), "kcp.interval: must be between 10 and 5000 ms")
+	check(c.KCP.Resend >= 0, "kcp.resend: must not be negative")
+	check(c.KCP.NoCongestion == 0 || c.KCP.NoCongestion == 1, "kcp.no_congestion: must be 0 or 1")
+	check(c.KCP.SendWindow >= 0, "kcp.send_window: must not be negative")
+	check(c.KCP.RecvWindow >= 0, "kcp.recv_window: must not be negative")
+	check(c.KCP.MTU == 0 || (c.KCP.MTU >= 50 && c.KCP.MTU <= 1500), "kcp.mtu: must be between 50 and 1500")
+	check(c.Room.MaxPlayers >= 0, "room.max_players: must not be negative")
+	check(c.Room.RoyaleMaxPlayers >= 0, "room.royale_max_players: must not be negative")
+	check(c.Game.TickRate > 0 && c.Game.TickRate <= 1000, "game.tick_rate: must be between 1 and 1000")
+	check(c.Game.MinInputDelay >= 0, "game.min_input_delay: must not be negative")
+	check(c.Game.MaxInputDelay >= c.Game.MinInputDelay, "game.max_input_delay: must not be less than game.min_input_delay")
+	check(c.Game.HashInterval >= 0, "game.hash_interval: must not be negative")
+	check(c.Game.CheatPolicy == "warn" || c.Game.CheatPolicy == "kick" || c.Game.CheatPolicy == "void",
+		"game.cheat_policy: %q must be warn, kick or void", c.Game.CheatPolicy)
+	check(c.Game.MaxOpsPerFrame >= 0, "game.max_ops_per_frame: must not be negative")
+	check(c.Game.MaxPPS >= 0, "game.max_pps: must not be negative")
+	check(c.Game.RoyaleFullBoards > 0, "game.royale_full_boards: must be positive")
+	check(c.Game.StatsInterval >= 0, "game.stats_interval: must not be negative")
+	check(c.Game.StatsInterval == 0 || c.Game.ValidateInput, "game.stats_interval: requires game.validate_input")
+	check(c.Game.MaxPause >= 0, "game.max_pause: must not be negative")
+	check(c.Game.LoadTimeout >= 0, "game.load_timeout: must not be negative")
+	check(c.Game.LoadTimeoutPolicy == "start" || c.Game.LoadTimeoutPolicy == "abort",
+		"game.load_timeout_policy: %q must be start or abort", c.Game.LoadTimeoutPolicy)
+	check(c.Game.DisconnectGrace >= 0, "game.disconnect_grace: must not be negative")
+	for i, step := range c.Game.Rules.Gravity {
+		check(step.FramesPerRow > 0, "game.rules.gravity[%d].frames_per_row: must be positive", i)
+		check(i == 0 || step.At > c.Game.Rules.Gravity[i-1].At, "game.rules.gravity[%d].at: must be after the previous step", i)
+	}
+	check(c.Game.Rules.LockDelay > 0, "game.rules.lock_delay: must be positive")
+	check(c.Game.Rules.GarbageDelay >= 0, "game.rules.garbage_delay: must not be negative")
+	attack := c.Game.Rules.Attack
+	for _, table := range []struct {
+		name   string
+		values []int
+	}{{"lines", attack.Lines}, {"t_spin", attack.TSpin}, {"t_spin_mini", attack.TSpinMini}, {"combo", attack.Combo}} {
+		for i, n := range table.values {
+			check(n >= 0, "game.rules.attack.%s[%d]: must not be negative", table.name, i)
+		}
+	}
+	check(attack.BackToBack >= 0, "game.rules.attack.back_to_back: must not be negative")
+	check(attack.PerfectClear >= 0, "game.rules.attack.perfect_clear: must not be negative")
+	check(c.Leaderboard.SeasonLength >= 0, "leaderboard.season_length: must not be negative")
+	check(validPort(c.Admin.Port), "admin.port: %d is not a valid port", c.Admin.Port)
+	check(validPort(c.Metrics.Port), "metrics.port: %d is not a valid port", c.Metrics.Port)
+	if err := logging.Validate(c.LoggingConfig()); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func validPort(port int) bool {
+	return port >= 0 && port <= 65535
+}
+
+// Addr 返回KCP监听地址
+func (c *Config) Addr() string {
+	return fmt.Sprintf("%s:%d", c.Server.IP, c.Server.Port)
+}
+
+// NetworkConfig 转换为network.Config
+func (c *Config) NetworkConfig() *network.Config {
+	return &network.Config{
+		ReceiveChanSize: c.Network.ReceiveChanSize,
+		ReceiveTimeout:  time.Duration(c.Network.ReceiveTimeout),
+		SendChanSize:    c.Network.SendChanSize,
+		SendTimeout:     time.Duration(c.Network.SendTimeout),
+		PingInterval:    time.Duration(c.Network.PingInterval),
+		PingTimeout:     time.Duration(c.Network.PingTimeout),
+		KCP:             network.KCPConfig(c.KCP),
+	}
+}
+
+// FrameInterval 返回game.tick_rate对应的帧同步间隔
+func (c *Config) FrameInterval() time.Duration {
+	return time.Second / time.Duration(c.Game.TickRate)
+}
+
+// LoggingConfig 转换为logging.Config
+func (c *Config) LoggingConfig() logging.Config {
+	return logging.Config{
+		Format:        c.Log.Format,
+		Level:         c.Log.Level,
+		Levels:        c.Log.Levels,
+		MessageSample: c.Log.Sample,
+	}
+}
+
+// ParseLevels 解析"network=debug,game=warn"格式的子系统日志级别
+func ParseLevels(s string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		sub, level, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid log level %q, want subsystem=level", item)
+		}
+		levels[strings.TrimSpace(sub)] = strings.TrimSpace(level)
+	}
+	return levels, nil
+}
+
+// Duration 在配置文件中以"30s"、"5m"的形式书写
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
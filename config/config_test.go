@@ -0,0 +1,162 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	data := `
+server:
+  port: 9000
+network:
+  receive_timeout: 10s
+kcp:
+  nodelay: 1
+  interval: 20
+room:
+  max_players: 4
+game:
+  rules:
+    gravity:
+      - at: 0s
+        frames_per_row: 20
+      - at: 30s
+        frames_per_row: 10
+    attack:
+      lines: [0, 1, 2, 3, 4]
+log:
+  levels:
+    network: debug
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, env(map[string]string{
+		"TETRIS_SERVER_PORT":    "9001",
+		"TETRIS_GAME_TICK_RATE": "60",
+		"TETRIS_LOG_LEVELS":     "game=warn",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Server.Port != 9001 {
+		t.Errorf("port = %d, want 9001 from env", cfg.Server.Port)
+	}
+	if cfg.Server.IP != "0.0.0.0" {
+		t.Errorf("ip = %q, want default", cfg.Server.IP)
+	}
+	if cfg.Room.MaxPlayers != 4 {
+		t.Errorf("max_players = %d, want 4", cfg.Room.MaxPlayers)
+	}
+	if got := cfg.Log.Levels; len(got) != 1 || got["game"] != "warn" {
+		t.Errorf("log levels = %v, want only game=warn from env", got)
+	}
+
+	nc := cfg.NetworkConfig()
+	if nc.ReceiveTimeout != 10*time.Second || nc.SendTimeout != 30*time.Second {
+		t.Errorf("timeouts = %v/%v, want 10s/30s", nc.ReceiveTimeout, nc.SendTimeout)
+	}
+	if cfg.FrameInterval() != time.Second/60 {
+		t.Errorf("frame interval = %v, want 1/60s", cfg.FrameInterval())
+	}
+	if nc.KCP.NoDelay != 1 || nc.KCP.Interval != 20 {
+		t.Errorf("kcp = %+v", nc.KCP)
+	}
+	if g := cfg.Game.Rules.Gravity; len(g) != 2 || g[1].At != Duration(30*time.Second) || g[1].FramesPerRow != 10 {
+		t.Errorf("gravity = %+v, want the two steps from the file", g)
+	}
+	if cfg.Game.Rules.LockDelay != 15 {
+		t.Errorf("lock_delay = %d, want default", cfg.Game.Rules.LockDelay)
+	}
+	if a := cfg.Game.Rules.Attack; len(a.Lines) != 5 || a.Lines[1] != 1 || a.PerfectClear != 10 {
+		t.Errorf("attack = %+v, want lines from the file and the default perfect clear", a)
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  prot: 9000\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, env(nil)); err == nil {
+		t.Fatal("unknown field accepted, want error")
+	}
+}
+
+func TestLoadRejectsBadEnv(t *testing.T) {
+	_, err := Load("", env(map[string]string{"TETRIS_NETWORK_SEND_TIMEOUT": "soon"}))
+	if err == nil || !strings.Contains(err.Error(), "TETRIS_NETWORK_SEND_TIMEOUT") {
+		t.Fatalf("got %v, want error naming the variable", err)
+	}
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = 70000
+	cfg.Network.SendChanSize = 0
+	cfg.Game.TickRate = 0
+	cfg.Log.Format = "xml"
+	cfg.Game.CheatPolicy = "ban"
+	cfg.Game.Rules.Gravity[1].At = 0
+	cfg.Game.Rules.Attack.Combo = []int{0, -1}
+	cfg.Game.RoyaleFullBoards = 0
+	cfg.Game.LoadTimeoutPolicy = "wait"
+	cfg.Game.DisconnectGrace = -1
+	cfg.Game.StatsInterval = 30
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, field := range []string{"server.port", "network.send_chan_size", "game.tick_rate", "game.cheat_policy", "game.rules.gravity[1].at", "game.rules.attack.combo[1]", "game.royale_full_boards", "game.load_timeout_policy", "game.disconnect_grace", "game.stats_interval", "log:"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q does not mention %s", err, field)
+		}
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	cfg := Default()
+	cfg.Server.ShutdownTimeout = Duration(90 * time.Second)
+
+	var buf bytes.Buffer
+	if err := cfg.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "shutdown_timeout: 1m30s") {
+		t.Fatalf("durations not encoded as strings:\n%s", buf.String())
+	}
+
+	decoded := Default()
+	decoded.Server.ShutdownTimeout = 0
+	if err := decoded.Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Server.ShutdownTimeout != cfg.Server.ShutdownTimeout {
+		t.Fatalf("shutdown timeout = %v, want %v", decoded.Server.ShutdownTimeout, cfg.Server.ShutdownTimeout)
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix 环境变量前缀
+// 变量名由前缀和yaml字段路径组成，例如TETRIS_SERVER_PORT、TETRIS_NETWORK_RECEIVE_TIMEOUT
+const EnvPrefix = "TETRIS"
+
+var textUnmarshaler = reflect.TypeFor[interface{ UnmarshalText([]byte) error }]()
+
+// ApplyEnv 用环境变量覆盖配置
+// map类型的字段使用"key=value,key=value"格式，例如TETRIS_LOG_LEVELS=network=debug,game=warn
+func ApplyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup)
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := envName(prefix, field)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(v.Field(i), name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func envName(prefix string, field reflect.StructField) string {
+	tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if tag == "" {
+		tag = field.Name
+	}
+	return prefix + "_" + strings.ToUpper(tag)
+}
+
+func setValue(v reflect.Value, s string) error {
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshaler) {
+		return v.Addr().Interface().(interface{ UnmarshalText([]byte) error }).UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Map:
+		levels, err := ParseLevels(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(levels))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
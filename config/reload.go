@@ -0,0 +1,121 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// liveSections 可以在运行时重新加载的配置段，其余字段修改后需要重启服务器
+var liveSections = map[string]bool{
+	"log":  true,
+	"room": true,
+	"game": true,
+}
+
+// ReloadResult 一次重新加载的结果，字段名使用yaml路径，例如room.max_players
+type ReloadResult struct {
+	// Applied 已经生效的修改
+	Applied []string `json:"applied"`
+	// RestartRequired 需要重启才能生效的修改，这些字段保持原值
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Changes 比较两份配置，返回可以立即生效和需要重启的字段
+func Changes(old, new *Config) (live, restart []string) {
+	diff(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", func(path string) {
+		section, _, _ := strings.Cut(path, ".")
+		if liveSections[section] {
+			live = append(live, path)
+		} else {
+			restart = append(restart, path)
+		}
+	})
+	return live, restart
+}
+
+func diff(a, b reflect.Value, prefix string, changed func(string)) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			diff(a.Field(i), b.Field(i), name, changed)
+			continue
+		}
+		if !equal(a.Field(i), b.Field(i)) {
+			changed(name)
+		}
+	}
+}
+
+// equal 比较字段值，空map和nil视为相同
+func equal(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Map && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// Reloader 重新加载配置并应用可以立即生效的字段
+// SIGHUP和管理接口可以并发调用Reload
+type Reloader struct {
+	mu      sync.Mutex
+	current *Config
+	load    func() (*Config, error)
+	apply   func(*Config) error
+}
+
+// NewReloader 创建Reloader，current为当前生效的配置，
+// load返回新的配置，apply在配置校验通过后应用可以立即生效的字段
+func NewReloader(current *Config, load func() (*Config, error), apply func(*Config) error) *Reloader {
+	return &Reloader{
+		current: current,
+		load:    load,
+		apply:   apply,
+	}
+}
+
+// Current 返回当前生效的配置
+func (r *Reloader) Current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload 加载并校验新配置，应用可以立即生效的字段
+// 加载、校验或应用失败时保持当前配置不变
+func (r *Reloader) Reload() (ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	if err := next.Validate(); err != nil {
+		return ReloadResult{}, err
+	}
+
+	live, restart := Changes(r.current, next)
+	result := ReloadResult{
+		Applied:         append([]string{}, live...),
+		RestartRequired: append([]string{}, restart...),
+	}
+
+	// 需要重启的字段保持原值，之后再次加载时仍会报告
+	effective := *r.current
+	effective.Log = next.Log
+	effective.Room = next.Room
+	effective.Game = next.Game
+	if len(result.Applied) > 0 {
+		if err := r.apply(&effective); err != nil {
+			return ReloadResult{}, err
+		}
+	}
+	r.current = &effective
+	return result, nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	old := Default()
+	next := Default()
+	next.Server.Port = 9000
+	next.Room.MaxPlayers = 4
+	next.Log.Levels = map[string]string{"network": "debug"}
+
+	live, restart := Changes(old, next)
+	if !slices.Equal(live, []string{"room.max_players", "log.levels"}) {
+		t.Errorf("live = %v", live)
+	}
+	if !slices.Equal(restart, []string{"server.port"}) {
+		t.Errorf("restart = %v", restart)
+	}
+
+	next = Default()
+	next.Log.Levels = nil
+	if live, restart := Changes(old, next); len(live)+len(restart) != 0 {
+		t.Errorf("nil and empty maps reported as changed: %v %v", live, restart)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	next := Default()
+	var applied []*Config
+	r := NewReloader(Default(), func() (*Config, error) {
+		return next, nil
+	}, func(cfg *Config) error {
+		applied = append(applied, cfg)
+		return nil
+	})
+
+	next.Game.TickRate = 60
+	next.Server.Port = 9000
+	result, err := r.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Applied, []string{"game.tick_rate"}) || !slices.Equal(result.RestartRequired, []string{"server.port"}) {
+		t.Fatalf("got %+v", result)
+	}
+	if len(applied) != 1 || applied[0].Game.TickRate != 60 {
+		t.Fatalf("applied %v", applied)
+	}
+	// 需要重启的字段保持原值
+	if cur := r.Current(); cur.Server.Port != 8080 || cur.Game.TickRate != 60 {
+		t.Fatalf("current port %d, tick rate %d", cur.Server.Port, cur.Game.TickRate)
+	}
+
+	// 没有可以立即生效的修改时不调用apply，仍然报告需要重启的字段
+	result, err = r.Reload()
+	if err != nil || len(result.Applied) != 0 || len(result.RestartRequired) != 1 || len(applied) != 1 {
+		t.Fatalf("second reload: %+v, %v, applied %d times", result, err, len(applied))
+	}
+}
+
+func TestReloaderKeepsConfigOnError(t *testing.T) {
+	next := Default()
+	next.Game.TickRate = 0
+	r := NewReloader(Default(), func() (*Config, error) { return next, nil }, func(*Config) error {
+		t.Fatal("invalid config applied")
+		return nil
+	})
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+
+	next = Default()
+	next.Room.MaxPlayers = 2
+	r = NewReloader(Default(), func() (*Config, error) { return next, nil }, func(*Config) error {
+		return errors.New("manager stopped")
+	})
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("apply error ignored")
+	}
+	if r.Current().Room.MaxPlayers != 0 {
+		t.Fatal("config changed after failed apply")
+	}
+}
@@ -0,0 +1,280 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
+	"sort"
+	"time"
+)
+
+// RoomInfo 房间的只读快照，供管理接口使用
+type RoomInfo struct {
+	ID      string   `json:"id"`
+	Status  string   `json:"status"`
+	Mode    string   `json:"mode"`
+	Host    string   `json:"host"`
+	Players []string `json:"players"`
+	// Teams 团队模式中已经分配的队伍
+	Teams   map[string]int32 `json:"teams,omitempty"`
+	Playing bool             `json:"playing"`
+}
+
+// PlayerInfo 玩家所在的房间、团队积分和网络状况
+type PlayerInfo struct {
+	ID     string `json:"id"`
+	RoomID string `json:"room_id"`
+	Rating int32  `json:"rating"`
+	NetInfo
+}
+
+// NetInfo 连接心跳测得的网络状况
+type NetInfo struct {
+	RTTMs    float64 `json:"rtt_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	Loss     float64 `json:"loss"`
+}
+
+func newNetInfo(conn network.IConn) NetInfo {
+	stats := conn.Stats()
+	return NetInfo{
+		RTTMs:    float64(stats.RTT) / float64(time.Millisecond),
+		JitterMs: float64(stats.Jitter) / float64(time.Millisecond),
+		Loss:     stats.Loss,
+	}
+}
+
+// GameInfo 游戏的只读快照
+type GameInfo struct {
+	ID          string           `json:"id"`
+	RoomID      string           `json:"room_id"`
+	Mode        string           `json:"mode"`
+	Status      string           `json:"status"`
+	FrameNumber int32            `json:"frame_number"`
+	InputDelay  int32            `json:"input_delay"`
+	Players     []GamePlayerInfo `json:"players"`
+}
+
+// GamePlayerInfo 玩家的帧同步情况
+// Lag为当前帧与最后成功发给该玩家的帧之差，SendQueue为连接中排队未发出的消息数
+type GamePlayerInfo struct {
+	ID            string `json:"id"`
+	Ready         bool   `json:"ready"`
+	Ended         bool   `json:"ended"`
+	LastSentFrame int32  `json:"last_sent_frame"`
+	Lag           int32  `json:"lag"`
+	SendQueue     int    `json:"send_queue"`
+	// Flags 玩家被标记的原因
+	Flags []string `json:"flags,omitempty"`
+	// Place和Badges 大逃杀中的名次（0表示仍然存活）和徽章点数
+	Place  int32 `json:"place,omitempty"`
+	Badges int32 `json:"badges,omitempty"`
+	// Team 团队模式中的队伍
+	Team int32 `json:"team,omitempty"`
+	// Offline 连接已断开，Bot 由服务器的机器人代为操作
+	Offline bool `json:"offline,omitempty"`
+	Bot     bool `json:"bot,omitempty"`
+	NetInfo
+}
+
+// 以下方法可以在任意协程中调用，实际的读写都在管理器协程中完成
+
+func (m *RoomManager) roomInfo(room IRoom) RoomInfo {
+	players := room.Players()
+	info := RoomInfo{
+		ID:      room.ID(),
+		Status:  room.Status(),
+		Mode:    room.Mode(),
+		Host:    room.Host(),
+		Players: make([]string, len(players)),
+	}
+	for i, p := range players {
+		info.Players[i] = p.ID()
+	}
+	sort.Strings(info.Players)
+	if teams := room.Teams(); len(teams) > 0 {
+		info.Teams = teams
+	}
+	_, info.Playing = m.games[room.ID()]
+	return info
+}
+
+// Rooms 返回所有房间，按房间ID排序
+func (m *RoomManager) Rooms() ([]RoomInfo, error) {
+	var rooms []RoomInfo
+	ok := m.run(func() {
+		rooms = make([]RoomInfo, 0, len(m.rooms))
+		for _, room := range m.rooms {
+			rooms = append(rooms, m.roomInfo(room))
+		}
+	})
+	if !ok {
+		return nil, ErrRoomManagerStopped
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+	return rooms, nil
+}
+
+func (m *RoomManager) Room(roomID string) (RoomInfo, error) {
+	var info RoomInfo
+	err := ErrRoomNotFound
+	ok := m.run(func() {
+		if room, exists := m.rooms[roomID]; exists {
+			info = m.roomInfo(room)
+			err = nil
+		}
+	})
+	if !ok {
+		return info, ErrRoomManagerStopped
+	}
+	return info, err
+}
+
+// Players 返回所有在房间中的玩家，按玩家ID排序
+func (m *RoomManager) Players() ([]PlayerInfo, error) {
+	var players []PlayerInfo
+	ok := m.run(func() {
+		players = make([]PlayerInfo, 0, len(m.player2room))
+		for _, room := range m.rooms {
+			for _, p := range room.Players() {
+				players = append(players, PlayerInfo{ID: p.ID(), RoomID: room.ID(), Rating: m.services.Ratings.Get(p.ID()), NetInfo: newNetInfo(p.Conn())})
+			}
+		}
+	})
+	if !ok {
+		return nil, ErrRoomManagerStopped
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].ID < players[j].ID })
+	return players, nil
+}
+
+// Games 返回所有进行中的游戏
+// 游戏状态由各自的协程维护，因此在管理器协程之外逐个查询
+func (m *RoomManager) Games() ([]GameInfo, error) {
+	var games []IGame
+	ok := m.run(func() {
+		for _, g := range m.games {
+			games = append(games, g)
+		}
+	})
+	if !ok {
+		return nil, ErrRoomManagerStopped
+	}
+	infos := make([]GameInfo, 0, len(games))
+	for _, g := range games {
+		infos = append(infos, g.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos, nil
+}
+
+// EndGame 强制结束进行中的游戏
+func (m *RoomManager) EndGame(gameID string) error {
+	var game IGame
+	ok := m.run(func() {
+		for _, g := range m.games {
+			if g.ID() == gameID {
+				game = g
+			}
+		}
+	})
+	if !ok {
+		return ErrRoomManagerStopped
+	}
+	if game == nil {
+		return ErrGameNotFound
+	}
+	m.log.Info("Admin ended game", logging.KeyGame, gameID)
+	game.Stop()
+	return nil
+}
+
+// KickPlayer 将玩家移出房间和正在进行的游戏
+// 玩家收到退出房间的回复，连接重新交给管理器处理
+func (m *RoomManager) KickPlayer(playerID string) error {
+	var game IGame
+	err := ErrPlayerNotFound
+	ok := m.run(func() {
+		room, exists := m.player2room[playerID]
+		if !exists {
+			return
+		}
+		err = nil
+		game = m.games[room.ID()]
+		m.removeFromRoom(room, playerID, "Kicked by server")
+		m.broadcastRoomInfoChanged(room.ID(), playerID)
+	})
+	if !ok {
+		return ErrRoomManagerStopped
+	}
+	if err == nil && game != nil {
+		if kickErr := game.Kick(playerID); kickErr != nil {
+			m.log.Warn("Failed to kick player from game", logging.KeyPlayer, playerID,
+				logging.KeyGame, game.ID(), logging.KeyError, kickErr)
+		}
+	}
+	if err == nil {
+		m.log.Info("Admin kicked player", logging.KeyPlayer, playerID)
+	}
+	return err
+}
+
+// CloseRoom 结束房间中的游戏，移出所有玩家并删除房间
+func (m *RoomManager) CloseRoom(roomID string) error {
+	var game IGame
+	err := ErrRoomNotFound
+	ok := m.run(func() {
+		room, exists := m.rooms[roomID]
+		if !exists {
+			return
+		}
+		err = nil
+		game = m.games[roomID]
+		for _, p := range room.Players() {
+			m.removeFromRoom(room, p.ID(), "Room closed by server")
+		}
+		delete(m.rooms, roomID)
+		metrics.RoomsActive.Set(float64(len(m.rooms)))
+	})
+	if !ok {
+		return ErrRoomManagerStopped
+	}
+	if game != nil {
+		game.Stop()
+	}
+	if err == nil {
+		m.log.Info("Admin closed room", logging.KeyRoom, roomID)
+	}
+	return err
+}
+
+// removeFromRoom 移出玩家并通知其已退出房间，调用方需在管理器协程中
+func (m *RoomManager) removeFromRoom(room IRoom, playerID string, reason string) {
+	var conn network.IConn
+	for _, p := range room.Players() {
+		if p.ID() == playerID {
+			conn = p.Conn()
+			p.Conn().SetHandler(m)
+		}
+	}
+	if err := room.RemovePlayer(playerID); err != nil {
+		m.log.Error("Failed to remove player from room", logging.KeyRoom, room.ID(),
+			logging.KeyPlayer, playerID, logging.KeyError, err)
+		return
+	}
+	delete(m.player2room, playerID)
+	if conn == nil {
+		return
+	}
+	select {
+	case conn.SendChan() <- &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CExitRoom{
+			S2CExitRoom: &pb.S2C_ExitRoom{Error: false, ErrorMsg: reason},
+		},
+	}:
+	default:
+		metrics.SendDrops.WithLabelValues("exit_room").Inc()
+		m.log.Warn("Failed to send exit room message", logging.KeyPlayer, playerID)
+	}
+}
@@ -0,0 +1,125 @@
+package game
+
+import (
+	"TetrisSvr/network/nettest"
+	"errors"
+	"testing"
+)
+
+func TestRoomsAndPlayers(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+
+	rooms, err := m.Rooms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != roomID || len(rooms[0].Players) != 2 || rooms[0].Playing {
+		t.Fatalf("Rooms = %+v", rooms)
+	}
+	players, err := m.Players()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 2 || players[0].ID != "p1" || players[1].RoomID != roomID {
+		t.Fatalf("Players = %+v", players)
+	}
+	if _, err := m.Room("missing"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("Room(missing) = %v, want %v", err, ErrRoomNotFound)
+	}
+}
+
+func TestGamesInfo(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+	mustWait(t, clients[0], isSyncFrames)
+
+	games, err := m.Games()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(games) != 1 || games[0].Status != PlayingGame || len(games[0].Players) != 2 {
+		t.Fatalf("Games = %+v", games)
+	}
+	for _, p := range games[0].Players {
+		if p.Lag < 0 || p.Lag > games[0].FrameNumber {
+			t.Fatalf("player %s lag = %d at frame %d", p.ID, p.Lag, games[0].FrameNumber)
+		}
+	}
+	room, err := m.Room(games[0].RoomID)
+	if err != nil || !room.Playing {
+		t.Fatalf("Room(%s) = %+v, %v", games[0].RoomID, room, err)
+	}
+}
+
+func TestEndGame(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+
+	if err := m.EndGame(game.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if end := mustWait(t, c, isGameEnd).GetS2CGameEnd(); !end.GetEndGame() {
+			t.Fatalf("got game end %v", end)
+		}
+	}
+	<-game.Done()
+	if err := m.EndGame("missing"); !errors.Is(err, ErrGameNotFound) {
+		t.Fatalf("EndGame(missing) = %v, want %v", err, ErrGameNotFound)
+	}
+}
+
+func TestKickPlayerFromGame(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+
+	if err := m.KickPlayer("p2"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := mustWait(t, clients[1], isExitRoom).GetS2CExitRoom(); reply.GetError() {
+		t.Fatalf("kicked player got %v", reply)
+	}
+	if clients[1].Conn.Handler() != m {
+		t.Fatal("kicked player was not returned to the room manager")
+	}
+	if end := mustWait(t, clients[0], isGameEnd).GetS2CGameEnd(); end.GetEndPlayer() != "p2" {
+		t.Fatalf("remaining player got game end %v", end)
+	}
+
+	// 剩下的玩家结束后整局游戏结束
+	clients[0].GameEnd(false, nil)
+	<-game.Done()
+
+	if err := m.KickPlayer("p2"); !errors.Is(err, ErrPlayerNotFound) {
+		t.Fatalf("second kick = %v, want %v", err, ErrPlayerNotFound)
+	}
+}
+
+func TestCloseRoom(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+
+	if err := m.CloseRoom(roomID); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*nettest.Client{host, guest} {
+		mustWait(t, c, isExitRoom)
+	}
+	if rooms, _ := m.Rooms(); len(rooms) != 0 {
+		t.Fatalf("Rooms after close = %+v", rooms)
+	}
+	// 房间关闭后玩家可以重新建房
+	createRoom(t, host)
+}
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"sort"
+)
+
+// hashWindows 上报不全的哈希最多保留hashWindows个上报间隔，之后用已有的上报比较
+const hashWindows = 4
+
+// handleStateHash 记录玩家上报的状态哈希，所有未结束的玩家都上报后比较
+func (g *Game) handleStateHash(message *pb.C2S_StateHash) {
+	playerID := message.GetPlayerId()
+	frame := message.GetFrameNumber()
+	player, ok := g.players[playerID]
+	if !ok || player.ended || g.settings.HashInterval <= 0 {
+		return
+	}
+	// 只能对已经发出的帧计算哈希，已经比较过的帧不再处理
+	if frame >= g.frameNumber || frame <= g.lastHashedFrame {
+		g.log.Debug("Ignored state hash", logging.KeyPlayer, playerID, "frame", frame, "current", g.frameNumber)
+		return
+	}
+
+	reports, ok := g.stateHashes[frame]
+	if !ok {
+		reports = make(map[string]uint64)
+		g.stateHashes[frame] = reports
+	}
+	reports[playerID] = message.GetHash()
+	for id, p := range g.players {
+		if _, reported := reports[id]; !reported && !p.ended {
+			return
+		}
+	}
+	g.compareHashes(frame)
+}
+
+// pruneStateHashes 处理长时间没有上报齐的帧，在tick中调用
+func (g *Game) pruneStateHashes() {
+	if g.settings.HashInterval <= 0 {
+		return
+	}
+	oldest := g.frameNumber - hashWindows*g.settings.HashInterval
+	var stale []int32
+	for frame := range g.stateHashes {
+		if frame < oldest {
+			stale = append(stale, frame)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i] < stale[j] })
+	for _, frame := range stale {
+		if len(g.stateHashes[frame]) >= 2 {
+			g.compareHashes(frame)
+		} else {
+			delete(g.stateHashes, frame)
+		}
+	}
+}
+
+// compareHashes 比较某一帧的哈希，与多数不一致的玩家视为不同步
+// 没有多数时所有玩家都视为不同步
+func (g *Game) compareHashes(frame int32) {
+	reports := g.stateHashes[frame]
+	delete(g.stateHashes, frame)
+	if frame <= g.lastHashedFrame {
+		return
+	}
+	from := g.lastAgreedFrame + 1
+	g.lastHashedFrame = frame
+
+	counts := make(map[uint64]int)
+	for _, hash := range reports {
+		counts[hash]++
+	}
+	if len(counts) == 1 {
+		g.lastAgreedFrame = frame
+		return
+	}
+
+	var majority uint64
+	best, tie := 0, false
+	for hash, n := range counts {
+		switch {
+		case n > best:
+			majority, best, tie = hash, n, false
+		case n == best:
+			tie = true
+		}
+	}
+	var desynced []string
+	for id, hash := range reports {
+		if tie || hash != majority {
+			desynced = append(desynced, id)
+		}
+	}
+	sort.Strings(desynced)
+
+	metrics.Desyncs.Inc()
+	g.log.Warn("Players desynced", "from", from, "to", frame, "players", desynced)
+	if g.replay != nil {
+		g.replay.recordEvent(ReplayEvent{
+			Frame:     g.frameNumber,
+			Type:      ReplayEventDesync,
+			FromFrame: from,
+			ToFrame:   frame,
+			Players:   desynced,
+		})
+	}
+	notice := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CDesync{
+			S2CDesync: &pb.S2C_Desync{
+				FromFrame: from,
+				ToFrame:   frame,
+				PlayerIds: desynced,
+			},
+		},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- notice:
+		default:
+			metrics.SendDrops.WithLabelValues("desync").Inc()
+		}
+	}
+}
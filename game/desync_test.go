@@ -0,0 +1,140 @@
+package game
+
+import (
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func isDesync(m *pb.MessageWrapper) bool { return m.GetS2CDesync() != nil }
+
+func TestGameDetectsDesync(t *testing.T) {
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	cfg := testConfig()
+	cfg.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m := NewRoomManager(ctx, cfg, &UniqueIDRoomCreator{})
+	m.Start()
+	dir := t.TempDir()
+	if err := m.ApplySettings(Settings{HashInterval: 5, ReplayDir: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := startTestGameOn(t, m, "p1", "p2", "p3")
+	for _, c := range clients {
+		c.LoadComplete([]byte(c.PlayerID))
+	}
+	for _, c := range clients {
+		if interval := mustWait(t, c, isGameLoadComplete).GetS2CGameLoadComplete().GetHashInterval(); interval != 5 {
+			t.Fatalf("%s: hash interval %d, want 5", c.PlayerID, interval)
+		}
+	}
+	clock.BlockUntil(1)
+	game := clients[0].Conn.Handler().(*Game)
+	for i := 0; i < 11; i++ {
+		clock.Advance(time.Second / 30)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+
+	// 第5帧一致，第10帧p3与其他玩家不同
+	for _, c := range clients {
+		c.StateHash(5, 100)
+	}
+	clients[0].StateHash(10, 200)
+	clients[1].StateHash(10, 200)
+	clients[2].StateHash(10, 201)
+	for _, c := range clients {
+		desync := mustWait(t, c, isDesync).GetS2CDesync()
+		if desync.GetFromFrame() != 6 || desync.GetToFrame() != 10 || !slices.Equal(desync.GetPlayerIds(), []string{"p3"}) {
+			t.Fatalf("%s: got desync %v, want p3 in frames 6-10", c.PlayerID, desync)
+		}
+	}
+
+	for _, c := range clients {
+		c.GameEnd(false, nil)
+	}
+	path := filepath.Join(dir, game.gameID+".json")
+	deadline := time.Now().Add(time.Second)
+	var data []byte
+	for {
+		var err error
+		if data, err = os.ReadFile(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replay not saved: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var replay Replay
+	if err := json.Unmarshal(data, &replay); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(replay.Players, []string{"p1", "p2", "p3"}) || replay.Seed != game.Setup().GetSeed() {
+		t.Fatalf("replay players %v, seed %d", replay.Players, replay.Seed)
+	}
+	if len(replay.Events) != 1 {
+		t.Fatalf("replay has %d events, want 1", len(replay.Events))
+	}
+	if e := replay.Events[0]; e.Type != ReplayEventDesync || e.FromFrame != 6 || e.ToFrame != 10 || !slices.Equal(e.Players, []string{"p3"}) {
+		t.Fatalf("got event %+v", e)
+	}
+}
+
+func TestReplayRecordsFramesWithInput(t *testing.T) {
+	players := map[string]*GamePlayer{
+		"p1": {playerID: "p1", frames: map[int32]*FrameData{
+			1: {Operations: [][]byte{[]byte("left")}},
+			2: {},
+		}},
+		"p2": {playerID: "p2", frames: map[int32]*FrameData{}},
+	}
+	r := &Replay{GameID: "g1"}
+	for frame := int32(0); frame < 3; frame++ {
+		r.recordFrame(frame, players)
+	}
+	if len(r.Frames) != 1 || r.Frames[0].Frame != 1 || string(r.Frames[0].Inputs["p1"][0]) != "left" {
+		t.Fatalf("got frames %+v, want only frame 1", r.Frames)
+	}
+
+	dir := filepath.Join(t.TempDir(), "replays")
+	path, err := r.save(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "g1.json" {
+		t.Fatalf("saved to %s", path)
+	}
+	// 不覆盖相同游戏ID的回放
+	if _, err := r.save(dir); err == nil {
+		t.Fatal("saved over an existing replay")
+	}
+
+	// 同时保存相同游戏ID的回放时只有一个成功，不留下临时文件
+	dir = filepath.Join(t.TempDir(), "replays")
+	var saved atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.save(dir); err == nil {
+				saved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	entries, _ := os.ReadDir(dir)
+	if saved.Load() != 1 || len(entries) != 1 || entries[0].Name() != "g1.json" {
+		t.Fatalf("%d concurrent saves succeeded, dir has %v", saved.Load(), entries)
+	}
+}
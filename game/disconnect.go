@@ -0,0 +1,259 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// botPace 机器人放置方块的间隔，低于默认的每秒方块数上限
+const botPace = 500 * time.Millisecond
+
+// disconnectState 房间的断线规则和等待重连的计时器，只在游戏协程中使用
+type disconnectState struct {
+	policy *pb.DisconnectPolicy
+	// timer 在最早的重连截止时间触发，没有等待重连的玩家时为nil
+	timer network.Timer
+}
+
+// setDisconnectPolicy 在游戏开始前设置房间的断线规则
+func (g *Game) setDisconnectPolicy(policy *pb.DisconnectPolicy) {
+	g.disconnect = disconnectState{policy: policy}
+}
+
+// disconnectGrace 断线后等待重连的时间，取房间规则和服务器上限中较小的一个
+func (g *Game) disconnectGrace() time.Duration {
+	limit := g.settings.DisconnectGrace
+	if d := time.Duration(g.disconnect.policy.GetGraceMs()) * time.Millisecond; d > 0 && d < limit {
+		return d
+	}
+	return limit
+}
+
+// disconnectTimer 等待重连的计时器，没有等待时为nil
+func (g *Game) disconnectTimer() <-chan time.Time {
+	if g.disconnect.timer == nil {
+		return nil
+	}
+	return g.disconnect.timer.C()
+}
+
+// resetDisconnectTimer 重新按最早的重连截止时间设置计时器
+func (g *Game) resetDisconnectTimer() {
+	g.stopDisconnectTimer()
+	if g.status == GameOver {
+		return
+	}
+	var next time.Time
+	for _, p := range g.players {
+		if !p.reconnectBy.IsZero() && (next.IsZero() || p.reconnectBy.Before(next)) {
+			next = p.reconnectBy
+		}
+	}
+	if !next.IsZero() {
+		g.disconnect.timer = g.clock.NewTimer(next.Sub(g.clock.Now()))
+	}
+}
+
+func (g *Game) stopDisconnectTimer() {
+	if g.disconnect.timer != nil {
+		g.disconnect.timer.Stop()
+		g.disconnect.timer = nil
+	}
+}
+
+// watchConn 连接关闭后在游戏协程中处理玩家断线
+func (g *Game) watchConn(playerID string, conn network.IConn) {
+	go func() {
+		select {
+		case <-conn.Done():
+			g.run(func() { g.handleDisconnect(playerID, conn) })
+		case <-g.done:
+		case <-g.context.Done():
+		}
+	}()
+}
+
+// handleDisconnect 玩家的连接断开，通知其他玩家并开始等待重连
+// 玩家已经用新的连接重连时忽略旧连接的关闭
+func (g *Game) handleDisconnect(playerID string, conn network.IConn) {
+	p, ok := g.players[playerID]
+	if !ok || p.conn != conn || p.ended || g.status == GameOver {
+		return
+	}
+	grace := g.disconnectGrace()
+	p.offline = true
+	p.offlineFrame = g.frameNumber
+	p.reconnectBy = g.clock.Now().Add(grace)
+	g.log.Info("Player disconnected", logging.KeyPlayer, playerID, "frame", g.frameNumber,
+		"grace", grace, "action", g.disconnect.policy.GetAction().String())
+	if grace <= 0 {
+		g.giveUpReconnect(p)
+		return
+	}
+	g.broadcastConnection(p)
+	g.resetDisconnectTimer()
+}
+
+// onDisconnectTimer 不再等待到达截止时间的玩家，同时到达的按ID顺序处理
+func (g *Game) onDisconnectTimer() {
+	g.disconnect.timer = nil
+	now := g.clock.Now()
+	var expired []*GamePlayer
+	for _, p := range g.players {
+		if !p.reconnectBy.IsZero() && !now.Before(p.reconnectBy) {
+			expired = append(expired, p)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].playerID < expired[j].playerID })
+	for _, p := range expired {
+		if g.status == GameOver {
+			return
+		}
+		g.giveUpReconnect(p)
+	}
+	g.resetDisconnectTimer()
+}
+
+// giveUpReconnect 按房间的断线规则让玩家弃权或由机器人接管
+func (g *Game) giveUpReconnect(p *GamePlayer) {
+	p.reconnectBy = time.Time{}
+	if g.disconnect.policy.GetAction() == pb.DisconnectAction_DISCONNECT_BOT {
+		g.startBot(p)
+		return
+	}
+	g.log.Info("Disconnected player forfeited", logging.KeyPlayer, p.playerID)
+	g.recordConnection(p, "forfeited")
+	g.removePlayer(p.playerID)
+}
+
+// startBot 机器人接管断线的玩家，加载阶段视为已加载完成
+func (g *Game) startBot(p *GamePlayer) {
+	p.bot = true
+	g.log.Info("Bot took over disconnected player", logging.KeyPlayer, p.playerID)
+	g.recordConnection(p, "taken over by bot")
+	g.broadcastConnection(p)
+	if g.status == WaitingGame {
+		p.ready = true
+		if !g.allPlayersReady() {
+			return
+		}
+		g.startPlaying()
+	}
+	if g.allPlayersEnded() {
+		g.terminate()
+	}
+}
+
+// playBots 机器人代打的玩家每隔botPace在当前帧放置一个方块，在发出当前帧之前调用
+// 模拟已经执行到上一帧，与客户端看到的棋盘相同
+func (g *Game) playBots() {
+	every := max(1, int32(botPace/g.interval))
+	if g.frameNumber%every != 0 {
+		return
+	}
+	for _, p := range g.players {
+		if !p.bot || p.ended || p.check.sim.Over() || p.opsAt(g.frameNumber) > 0 {
+			continue
+		}
+		p.AddInput(g.frameNumber, tetris.EncodeOps(p.check.sim.Plan()))
+	}
+}
+
+// Rejoin 断线的玩家用新的连接回到游戏，机器人代打时交还给玩家
+// 之后从第0帧开始每帧最多补发maxSyncFrames帧，客户端重放后即可继续
+func (g *Game) Rejoin(playerID string, conn network.IConn) error {
+	err := ErrGameNotFound
+	g.run(func() {
+		if g.status == GameOver {
+			return
+		}
+		p, ok := g.players[playerID]
+		switch {
+		case !ok:
+			err = ErrPlayerNotFound
+		case p.ended:
+			err = errors.New("player has ended the game")
+		default:
+			err = nil
+			g.reconnect(p, conn)
+		}
+	})
+	return err
+}
+
+// reconnect 用新的连接替换玩家的连接，回复S2C_Rejoin后通知其他玩家
+func (g *Game) reconnect(p *GamePlayer, conn network.IConn) {
+	wasOffline, wasBot := p.offline, p.bot
+	p.conn = conn
+	g.conns[conn] = p.playerID
+	p.offline = false
+	p.bot = false
+	p.reconnectBy = time.Time{}
+	p.lastSentFrame = 0
+	conn.SetHandler(g)
+	g.watchConn(p.playerID, conn)
+
+	reply := &pb.S2C_Rejoin{
+		Setup:        g.setup,
+		InputDelay:   g.inputDelay,
+		HashInterval: g.settings.HashInterval,
+		FrameNumber:  g.frameNumber,
+	}
+	for id := range g.players {
+		reply.Players = append(reply.Players, id)
+	}
+	sort.Strings(reply.Players)
+	conn.SendChan() <- &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CRejoin{S2CRejoin: reply},
+	}
+	g.log.Info("Player rejoined", logging.KeyPlayer, p.playerID, "frame", g.frameNumber, "bot", wasBot)
+	if wasOffline {
+		g.recordConnection(p, "reconnected")
+	}
+	g.broadcastConnection(p)
+	g.resetDisconnectTimer()
+}
+
+// broadcastConnection 向在线的玩家广播p的连接状态
+func (g *Game) broadcastConnection(p *GamePlayer) {
+	state := &pb.S2C_PlayerConnection{PlayerId: p.playerID, Connected: !p.offline, Bot: p.bot}
+	if !p.reconnectBy.IsZero() {
+		state.Deadline = p.reconnectBy.UnixMilli()
+	}
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CPlayerConnection{S2CPlayerConnection: state},
+	}
+	for _, receiver := range g.players {
+		if receiver.offline {
+			continue
+		}
+		select {
+		case receiver.conn.SendChan() <- msg:
+		default:
+			metrics.SendDrops.WithLabelValues("player_connection").Inc()
+			g.log.Warn("Failed to send player connection", logging.KeyPlayer, receiver.playerID)
+		}
+	}
+}
+
+// recordConnection 在回放中记录从断线到result的帧范围
+func (g *Game) recordConnection(p *GamePlayer, result string) {
+	if g.replay == nil {
+		return
+	}
+	g.replay.recordEvent(ReplayEvent{
+		Frame:     g.frameNumber,
+		Type:      ReplayEventDisconnect,
+		FromFrame: p.offlineFrame,
+		ToFrame:   g.frameNumber,
+		Players:   []string{p.playerID},
+		Detail:    fmt.Sprintf("disconnected, %s", result),
+	})
+}
@@ -0,0 +1,233 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"testing"
+	"time"
+)
+
+func isRejoin(m *pb.MessageWrapper) bool { return m.GetS2CRejoin() != nil }
+
+// isConnection 匹配玩家连接状态的广播
+func isConnection(connected, bot bool) func(*pb.MessageWrapper) bool {
+	return func(m *pb.MessageWrapper) bool {
+		state := m.GetS2CPlayerConnection()
+		return state != nil && state.GetConnected() == connected && state.GetBot() == bot
+	}
+}
+
+func TestDisconnectForfeit(t *testing.T) {
+	clock, m := newClockedRoomManager(t, Settings{DisconnectGrace: 5 * time.Second})
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+
+	clients[1].Disconnect()
+	state := mustWait(t, clients[0], isConnection(false, false)).GetS2CPlayerConnection()
+	if state.GetPlayerId() != "p2" || state.GetDeadline() != (5*time.Second).Milliseconds() {
+		t.Fatalf("got %v, want p2 disconnected with the server grace period", state)
+	}
+	clock.BlockUntil(2)
+	clock.Advance(5 * time.Second)
+	if end := mustWait(t, clients[0], isGameEnd).GetS2CGameEnd(); end.GetEndPlayer() != "p2" {
+		t.Fatalf("got %v, want p2 forfeited", end)
+	}
+
+	// 剩下的玩家结束后游戏正常结束
+	clients[0].GameEnd(false, nil)
+	mustWait(t, clients[0], isGameEnd)
+	<-game.Done()
+}
+
+func TestDisconnectRejoin(t *testing.T) {
+	clock, m := newClockedRoomManager(t, Settings{DisconnectGrace: 5 * time.Second})
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+
+	clients[1].Input(ops(tetris.OpHardDrop))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[1], isSyncFrames)
+
+	clients[1].Disconnect()
+	mustWait(t, clients[0], isConnection(false, false))
+	clock.BlockUntil(2)
+	clock.Advance(FrameInterval)
+
+	// 不在房间中的玩家不能加入
+	stranger := nettest.NewClient("p3", m)
+	stranger.Rejoin(m, game.roomID)
+	if reply := mustWait(t, stranger, isRejoin).GetS2CRejoin(); !reply.GetError() {
+		t.Fatal("player outside the room rejoined")
+	}
+
+	// 游戏进行中房主从新的连接不能再开始一局或修改断线规则，重连总是回到进行中的游戏
+	host := nettest.NewClient("p1", m)
+	host.StartGame(game.roomID)
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); !reply.GetError() {
+		t.Fatal("started a second game while p2 was disconnected")
+	}
+	host.SetDisconnectPolicy(game.roomID, &pb.DisconnectPolicy{GraceMs: 1000})
+	isSetPolicy := func(m *pb.MessageWrapper) bool { return m.GetS2CSetDisconnectPolicy() != nil }
+	if reply := mustWait(t, host, isSetPolicy).GetS2CSetDisconnectPolicy(); reply.GetErrorMsg() != "Room is already in game" {
+		t.Fatalf("set disconnect policy during the game got %v, want already in game", reply)
+	}
+
+	clients[1].Rejoin(m, game.roomID)
+	reply := mustWait(t, clients[1], isRejoin).GetS2CRejoin()
+	if reply.GetError() || reply.GetSetup().GetSeed() != game.Setup().GetSeed() || len(reply.GetPlayers()) != 2 {
+		t.Fatalf("got %v", reply)
+	}
+	mustWait(t, clients[0], isConnection(true, false))
+
+	// 重连后从第0帧开始重新发送
+	clock.Advance(FrameInterval)
+	sync := mustWait(t, clients[1], isSyncFrames).GetS2CSyncFrames()
+	if frame, ok := findOperation(sync, "p2", ops(tetris.OpHardDrop)); !ok || frame != 0 {
+		t.Fatalf("resent frames %v do not start with the input at frame 0", sync)
+	}
+	clock.Advance(5 * time.Second)
+	if info := game.Info(); info.Status != PlayingGame || len(info.Players) != 2 {
+		t.Fatalf("game is %s with %d players after the grace period", info.Status, len(info.Players))
+	}
+}
+
+func TestRejoinCatchUpInChunks(t *testing.T) {
+	clock, m := newClockedRoomManager(t, Settings{DisconnectGrace: time.Minute})
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+	for i := 0; i < 2*maxSyncFrames; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+
+	clients[1].Disconnect()
+	mustWait(t, clients[0], isConnection(false, false))
+	clients[1].Rejoin(m, game.roomID)
+	if reply := mustWait(t, clients[1], isRejoin).GetS2CRejoin(); reply.GetError() {
+		t.Fatalf("rejoin failed: %s", reply.GetErrorMsg())
+	}
+
+	// 落后的帧分批补发，每批不超过maxSyncFrames帧，前后相接直到追上当前帧
+	next := int32(0)
+	for batches := 1; ; batches++ {
+		if batches > 10 {
+			t.Fatal("rejoined player did not catch up")
+		}
+		clock.Advance(FrameInterval)
+		sync := mustWait(t, clients[1], isSyncFrames).GetS2CSyncFrames()
+		var last int32
+		for _, p := range sync.GetPlayers() {
+			frames := p.GetFrames()
+			if len(frames) == 0 || len(frames) > maxSyncFrames || frames[0].GetFrameNumber() > next {
+				t.Fatalf("batch %d for %s has frames %v, want at most %d from %d",
+					batches, p.GetPlayerId(), frames, maxSyncFrames, next)
+			}
+			last = frames[len(frames)-1].GetFrameNumber()
+		}
+		if last == game.Info().FrameNumber-1 {
+			if batches < 3 {
+				t.Fatalf("caught up in %d batches, want the backlog split", batches)
+			}
+			return
+		}
+		next = last + 1
+	}
+}
+
+func TestDisconnectBot(t *testing.T) {
+	// 每帧都是机器人放置方块的帧
+	clock, m := newClockedRoomManager(t, Settings{DisconnectGrace: time.Minute, FrameInterval: botPace, ValidateInput: true})
+	clients := []*nettest.Client{nettest.NewClient("p1", m), nettest.NewClient("p2", m)}
+	roomID := createModeRoom(t, clients[0], ModeVersus)
+	enterRoom(t, clients[1], roomID)
+	policy := &pb.DisconnectPolicy{Action: pb.DisconnectAction_DISCONNECT_BOT, GraceMs: 1000}
+	isSetPolicy := func(m *pb.MessageWrapper) bool { return m.GetS2CSetDisconnectPolicy() != nil }
+	clients[1].SetDisconnectPolicy(roomID, policy)
+	if reply := mustWait(t, clients[1], isSetPolicy).GetS2CSetDisconnectPolicy(); !reply.GetError() {
+		t.Fatal("non-host set the disconnect policy")
+	}
+	clients[0].SetDisconnectPolicy(roomID, policy)
+	if reply := mustWait(t, clients[0], isSetPolicy).GetS2CSetDisconnectPolicy(); reply.GetError() {
+		t.Fatalf("set disconnect policy failed: %s", reply.GetErrorMsg())
+	}
+	if info := mustWait(t, clients[1], isRoomInfoChanged).GetS2CRoomInfoChanged(); info.GetDisconnectPolicy().GetAction() != policy.GetAction() {
+		t.Fatalf("room info has disconnect policy %v", info.GetDisconnectPolicy())
+	}
+	clients[0].StartGame(roomID)
+	for _, c := range clients {
+		mustWait(t, c, isStartGame)
+	}
+	game := clients[0].Conn.Handler().(*Game)
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+
+	clients[1].Disconnect()
+	if state := mustWait(t, clients[0], isConnection(false, false)).GetS2CPlayerConnection(); state.GetDeadline() != 1000 {
+		t.Fatalf("got %v, want the room grace period", state)
+	}
+	clock.BlockUntil(2)
+	clock.Advance(time.Second)
+	mustWait(t, clients[0], isConnection(false, true))
+
+	// 机器人替p2放置方块
+	placed := false
+	for i := 0; i < 3 && !placed; i++ {
+		clock.Advance(botPace)
+		sync := mustWait(t, clients[0], isSyncFrames).GetS2CSyncFrames()
+		for _, p := range sync.GetPlayers() {
+			for _, f := range p.GetFrames() {
+				for _, op := range f.GetOperations() {
+					placed = placed || p.GetPlayerId() == "p2" && op[len(op)-1] == byte(tetris.OpHardDrop)
+				}
+			}
+		}
+	}
+	if !placed {
+		t.Fatal("bot did not place a piece")
+	}
+
+	// 只剩机器人时游戏结束
+	clients[0].GameEnd(false, nil)
+	mustWait(t, clients[0], func(m *pb.MessageWrapper) bool { return m.GetS2CGameEnd().GetEndGame() })
+	<-game.Done()
+}
+
+func TestBotsRequireValidation(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+	isSetPolicy := func(m *pb.MessageWrapper) bool { return m.GetS2CSetDisconnectPolicy() != nil }
+	policy := &pb.DisconnectPolicy{Action: pb.DisconnectAction_DISCONNECT_BOT}
+
+	// 机器人按服务器的模拟放置方块，需要开启输入校验
+	host.SetDisconnectPolicy(roomID, policy)
+	if reply := mustWait(t, host, isSetPolicy).GetS2CSetDisconnectPolicy(); reply.GetErrorMsg() != "Bots require input validation" {
+		t.Fatalf("got %v, want bots rejected without input validation", reply)
+	}
+	if err := m.ApplySettings(Settings{ValidateInput: true}); err != nil {
+		t.Fatal(err)
+	}
+	host.SetDisconnectPolicy(roomID, policy)
+	if reply := mustWait(t, host, isSetPolicy).GetS2CSetDisconnectPolicy(); reply.GetError() {
+		t.Fatalf("set disconnect policy failed: %s", reply.GetErrorMsg())
+	}
+
+	// 设置规则之后关闭了输入校验，不能开始
+	if err := m.ApplySettings(Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	host.StartGame(roomID)
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); reply.GetErrorMsg() != "Bots require input validation" {
+		t.Fatalf("got %v, want start rejected without input validation", reply)
+	}
+}
@@ -3,3 +3,6 @@ package game
 import "errors"
 
 var ErrRoomNotFound = errors.New("room not found")
+var ErrPlayerNotFound = errors.New("player not found")
+var ErrGameNotFound = errors.New("game not found")
+var ErrRoomManagerStopped = errors.New("room manager stopped")
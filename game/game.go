@@ -1,32 +1,55 @@
 package game
 
 import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/history"
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
 	"TetrisSvr/network"
 	pb "TetrisSvr/proto"
 	"context"
+	"log/slog"
 	"time"
-
-	log "github.com/jeanphorn/log4go"
 )
 
 const WaitingGame = "waiting"
 const PlayingGame = "playing"
+
+// PausedGame 暂停中的游戏，帧计时停止
+const PausedGame = "paused"
 const GameOver = "gameover"
 
+// FrameInterval 默认的帧同步间隔，每秒30帧
+const FrameInterval = time.Second / 30
+
+// maxSyncFrames 一条S2C_SyncFrames最多包含的帧数，重连后落后的帧分多次补发，避免单条消息超过KCP的分片上限
+const maxSyncFrames = 120
+
 type FrameData struct {
 	Operations [][]byte
+	// Garbage 服务器在这一帧开始时放入玩家待收垃圾的攻击
+	Garbage []*pb.Garbage
 }
 
 type GamePlayer struct {
 	playerID string
 	conn     network.IConn
 	frames   map[int32]*FrameData
-	complete *pb.C2S_GameLoadComplete
 
 	ready           bool
 	lastFrameNumber int32 // 玩家最后确认的帧号
 	lastSentFrame   int32 // 最后成功发送的帧号
 	ended           bool  // 玩家是否已结束游戏
+	check           validation
+	// boards 大逃杀中接收完整帧的玩家，为nil时接收所有玩家的帧
+	boards map[string]bool
+	// offline 连接已断开，offlineFrame 断线时的帧号，reconnectBy 等待重连的截止时间，不再等待时为零值
+	offline      bool
+	offlineFrame int32
+	reconnectBy  time.Time
+	// bot 由服务器的机器人代为操作
+	bot bool
 }
 
 func (p *GamePlayer) AddInput(frame int32, op []byte) {
@@ -45,19 +68,73 @@ func (p *GamePlayer) AddInput(frame int32, op []byte) {
 // Game 实现IRoom接口的俄罗斯方块游戏房间
 type Game struct {
 	gameID      string
+	roomID      string
+	log         *slog.Logger
 	context     context.Context
 	status      string
 	players     map[string]*GamePlayer
 	messageChan chan *network.ConnMessage
+	controlChan chan func()
+	done        chan struct{}
 
-	ticker      *time.Ticker
+	clock       network.Clock
+	ticker      network.Ticker
+	interval    time.Duration
+	lastTick    time.Time
 	frameNumber int32
+
+	settings Settings
+	// inputDelay 开始游戏时根据玩家的RTT计算的输入延迟帧数
+	inputDelay int32
+
+	// stateHashes 按帧记录玩家上报的状态哈希
+	stateHashes map[int32]map[string]uint64
+	// lastHashedFrame 最后比较过哈希的帧，lastAgreedFrame 最后所有玩家哈希一致的帧
+	lastHashedFrame int32
+	lastAgreedFrame int32
+	replay          *Replay
+
+	// setup 创建游戏时生成的种子和规则，创建后不再修改
+	setup *pb.MatchSetup
+	// flags 本局中被标记的玩家，voided 本局是否因作弊作废
+	flags  []CheatFlag
+	voided bool
+	// garbage 开始游戏时创建的攻击路由
+	garbage *garbageRouter
+	// royale 大逃杀模式开始游戏时创建，其他模式为nil
+	royale *royale
+	// team 团队模式创建游戏时设置，其他模式为nil
+	team *teamMatch
+	// finished 按结束顺序排列的玩家，包括之后被移出游戏的玩家
+	finished []*GamePlayer
+	// replayPath 保存的回放文件，没有保存时为空
+	replayPath string
+	// services 游戏结束时更新的积分、排行榜和比赛记录
+	services Services
+	// pause 房间的暂停规则和当前的暂停状态
+	pause pauseState
+	// loadTimer 等待玩家加载完成的计时器
+	loadTimer network.Timer
+	// disconnect 房间的断线规则和等待重连的计时器
+	disconnect disconnectState
+	// lobby 游戏结束后接管玩家连接的handler，conns 交给过本局游戏的连接及其玩家，包括重连前的连接
+	lobby network.IConnHandler
+	conns map[network.IConn]string
+}
+
+// Services 多个游戏共用的积分、排行榜和比赛记录，都可以在任意协程中使用
+type Services struct {
+	Ratings     *Ratings
+	Leaderboard leaderboard.Store
+	History     history.Store
 }
 
 // NewGame 创建新的游戏实例
-func NewGame(ctx context.Context, gameID string, config *network.Config, players map[string]IPlayer) *Game {
+func NewGame(ctx context.Context, gameID string, roomID string, mode string, config *network.Config, settings Settings, players map[string]IPlayer) *Game {
 	gamePlayers := make(map[string]*GamePlayer)
+	conns := make(map[network.IConn]string)
 	for _, player := range players {
+		conns[player.Conn()] = player.ID()
 		gamePlayers[player.ID()] = &GamePlayer{
 			playerID:        player.ID(),
 			conn:            player.Conn(),
@@ -66,13 +143,30 @@ func NewGame(ctx context.Context, gameID string, config *network.Config, players
 			lastFrameNumber: -1,
 		}
 	}
+	interval := settings.FrameInterval
+	if interval <= 0 {
+		interval = FrameInterval
+	}
 	return &Game{
 		gameID:      gameID,
+		roomID:      roomID,
+		log:         logging.For(logging.Game).With(logging.KeyRoom, roomID, logging.KeyGame, gameID),
 		context:     ctx,
 		status:      WaitingGame,
 		players:     gamePlayers,
+		conns:       conns,
 		messageChan: make(chan *network.ConnMessage, config.ReceiveChanSize),
+		controlChan: make(chan func()),
+		done:        make(chan struct{}),
+		clock:       config.GetClock(),
+		interval:    interval,
 		frameNumber: 0,
+		settings:    settings,
+		setup:       newMatchSetup(mode, settings),
+
+		stateHashes:     make(map[int32]map[string]uint64),
+		lastHashedFrame: -1,
+		lastAgreedFrame: -1,
 	}
 }
 
@@ -81,6 +175,11 @@ func (g *Game) ID() string {
 	return g.gameID
 }
 
+// Setup 本局的种子和规则，由RoomManager放入S2C_StartGame
+func (g *Game) Setup() *pb.MatchSetup {
+	return g.setup
+}
+
 func (g *Game) Start() {
 	go g.gameLoop()
 }
@@ -89,23 +188,182 @@ func (g *Game) HandleChan() chan<- *network.ConnMessage {
 	return g.messageChan
 }
 
+func (g *Game) Done() <-chan struct{} {
+	return g.done
+}
+
+// Stop 在游戏协程中强制结束游戏
+func (g *Game) Stop() {
+	select {
+	case g.controlChan <- g.forceEnd:
+	case <-g.done:
+	case <-g.context.Done():
+	}
+}
+
+// run 在游戏协程中执行f，游戏协程已退出时返回false
+func (g *Game) run(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case g.controlChan <- func() { f(); close(done) }:
+	case <-g.done:
+		return false
+	case <-g.context.Done():
+		return false
+	}
+	<-done
+	return true
+}
+
+// Info 返回游戏当前的帧号和各玩家的同步情况
+func (g *Game) Info() GameInfo {
+	info := GameInfo{ID: g.gameID, RoomID: g.roomID, Mode: g.setup.GetMode(), Status: GameOver}
+	g.run(func() {
+		info.Status = g.status
+		info.FrameNumber = g.frameNumber
+		info.InputDelay = g.inputDelay
+		for _, p := range g.players {
+			player := GamePlayerInfo{
+				ID:            p.playerID,
+				Ready:         p.ready,
+				Ended:         p.ended,
+				LastSentFrame: p.lastSentFrame,
+				Lag:           g.frameNumber - p.lastSentFrame,
+				SendQueue:     len(p.conn.SendChan()),
+				Flags:         p.flagReasons(),
+				Offline:       p.offline,
+				Bot:           p.bot,
+				NetInfo:       newNetInfo(p.conn),
+			}
+			if g.royale != nil {
+				player.Place = g.royale.places[p.playerID]
+				player.Badges = g.royale.badges[p.playerID]
+			}
+			if g.team != nil {
+				player.Team = g.team.members[p.playerID]
+			}
+			info.Players = append(info.Players, player)
+		}
+	})
+	return info
+}
+
+// Kick 将玩家移出游戏，其他玩家收到该玩家结束的广播
+func (g *Game) Kick(playerID string) error {
+	err := ErrPlayerNotFound
+	g.run(func() {
+		if _, ok := g.players[playerID]; !ok || g.status == GameOver {
+			return
+		}
+		err = nil
+		// 被踢出的玩家的连接已经交还给管理器，结束时不再接管
+		for conn, id := range g.conns {
+			if id == playerID {
+				delete(g.conns, conn)
+			}
+		}
+		g.removePlayer(playerID)
+	})
+	return err
+}
+
+// removePlayer 广播玩家结束并将其移出游戏
+func (g *Game) removePlayer(playerID string) {
+	g.handleGameEnd(nil, &pb.C2S_GameEnd{PlayerId: playerID})
+	delete(g.players, playerID)
+	if g.status == GameOver {
+		return
+	}
+	if len(g.players) == 0 {
+		g.endGame()
+	} else if g.status == WaitingGame && g.allPlayersReady() {
+		g.startPlaying()
+	}
+}
+
+// broadcastGameEnd 向所有玩家广播结束消息，附带被标记的玩家和所有玩家到此时的统计
+func (g *Game) broadcastGameEnd(end *pb.S2C_GameEnd) {
+	end.FlaggedPlayers = g.flaggedPlayers()
+	end.Stats = g.gameStats()
+	if g.royale != nil {
+		end.Ranking = g.royale.ranking(g.players)
+	}
+	broadcastMsg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CGameEnd{S2CGameEnd: end},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- broadcastMsg:
+		default:
+			metrics.SendDrops.WithLabelValues("game_end").Inc()
+			g.log.Warn("Failed to send game end message", logging.KeyPlayer, p.playerID)
+		}
+	}
+}
+
+// forceEnd 由服务器结束游戏，广播的结束玩家为空
+func (g *Game) forceEnd() {
+	if g.status == GameOver {
+		return
+	}
+	g.broadcastGameEnd(&pb.S2C_GameEnd{EndGame: true})
+	g.log.Info("Game stopped by server")
+	g.endGame()
+}
+
 func (g *Game) handleGameLoadComplete(message *pb.C2S_GameLoadComplete) {
 	playerID := message.GetPlayerId()
-	g.players[playerID].ready = true
-	g.players[playerID].complete = message
+	player, ok := g.players[playerID]
+	if !ok {
+		// 加载超时后弃权的玩家
+		g.log.Warn("Player not found when loading", logging.KeyPlayer, playerID)
+		return
+	}
+	player.ready = true
+
+	g.log.Info("Player is ready", logging.KeyPlayer, playerID)
+	if g.allPlayersReady() {
+		g.startPlaying()
+	}
+}
 
-	log.Info("Player %s is ready", playerID)
+func (g *Game) allPlayersReady() bool {
 	for _, p := range g.players {
 		if !p.ready {
-			return
+			return false
 		}
 	}
+	return true
+}
 
+// startPlaying 通知所有玩家已经加载完成并开始帧计时
+// 种子和规则已经在S2C_StartGame中发出，加载完成只用于确认准备就绪，不再转发客户端的payload
+func (g *Game) startPlaying() {
 	reply := &pb.S2C_GameLoadComplete{
 		Msg: make([]*pb.C2S_GameLoadComplete, 0, len(g.players)),
 	}
 	for _, p := range g.players {
-		reply.Msg = append(reply.Msg, p.complete)
+		reply.Msg = append(reply.Msg, &pb.C2S_GameLoadComplete{PlayerId: p.playerID})
+	}
+	// 先启动计时器再通知客户端，保证客户端收到通知时帧计时已经开始
+	g.stopLoadTimer()
+	g.status = PlayingGame
+	g.ticker = g.clock.NewTicker(g.interval)
+	g.frameNumber = 0
+	g.inputDelay = inputDelay(g.players, g.interval, g.settings.MinInputDelay, g.settings.MaxInputDelay)
+	reply.InputDelay = g.inputDelay
+	reply.HashInterval = g.settings.HashInterval
+	g.replay = g.newReplay()
+	for _, p := range g.players {
+		p.check.sim = tetris.NewGame(g.setup.GetSeed(), simConfig(g.setup))
+	}
+	// 攻击由模拟产生，不使用模拟时服务器不路由垃圾
+	if g.simulated() {
+		g.garbage = newGarbageRouter(g.setup)
+	}
+	if g.setup.GetMode() == ModeRoyale {
+		g.royale = newRoyale(g.setup)
+		g.assignBoards()
 	}
 	for _, p := range g.players {
 		p.conn.SendChan() <- &pb.MessageWrapper{
@@ -114,10 +372,7 @@ func (g *Game) handleGameLoadComplete(message *pb.C2S_GameLoadComplete) {
 			},
 		}
 	}
-	g.status = PlayingGame
-	g.ticker = time.NewTicker(time.Second / 30)
-	g.frameNumber = 0
-	log.Info("All players are ready, game started")
+	g.log.Info("All players are ready, game started", "input_delay", g.inputDelay)
 }
 
 func (g *Game) handleWaitingMessage(_ network.IConn, message *pb.MessageWrapper) {
@@ -125,49 +380,75 @@ func (g *Game) handleWaitingMessage(_ network.IConn, message *pb.MessageWrapper)
 	case *pb.MessageWrapper_C2SGameLoadComplete:
 		g.handleGameLoadComplete(msg.C2SGameLoadComplete)
 	case *pb.MessageWrapper_C2SHeartbeat:
-		// log.Info("Heartbeat:%s received", msg.C2SHeartbeat.GetPlayerId())
 		return
 	default:
-		log.Error("Unknown message type: %T", msg)
+		g.log.Warn("Unknown message type", "type", metrics.MessageType(message))
+	}
+}
+
+// sendInputCorrection 通知玩家输入没有放入其标记的帧，发送队列已满时放弃
+func (g *Game) sendInputCorrection(player *GamePlayer, correction *pb.S2C_InputCorrection) {
+	select {
+	case player.conn.SendChan() <- &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CInputCorrection{S2CInputCorrection: correction},
+	}:
+	default:
+		metrics.SendDrops.WithLabelValues("input_correction").Inc()
 	}
 }
 
 func (g *Game) handleInput(_ network.IConn, message *pb.C2S_Input) {
 	playerID := message.GetPlayerId()
-	currentFrame := g.frameNumber
+	player, ok := g.players[playerID]
+	if !ok {
+		g.log.Warn("Player not found when handling input", logging.KeyPlayer, playerID)
+		return
+	}
 
-	if player, ok := g.players[playerID]; ok {
-		player.AddInput(currentFrame, message.GetOperations())
-		log.Debug("Added %d operations to frame %d for player %s",
-			len(message.GetOperations()), currentFrame, playerID)
-	} else {
-		log.Error("Player %s not found when handling input", playerID)
+	// 没有标记帧号的输入放入当前帧
+	frame, result := g.frameNumber, inputAccepted
+	if message.FrameNumber != nil {
+		frame, result = placeInput(g.frameNumber, message.GetFrameNumber(), g.inputDelay)
+	}
+	if result == inputRejected {
+		metrics.InputAdjustments.WithLabelValues(result).Inc()
+		g.log.Warn("Rejected input for a future frame", logging.KeyPlayer, playerID,
+			"frame", message.GetFrameNumber(), "current", g.frameNumber, "input_delay", g.inputDelay)
+		g.sendInputCorrection(player, &pb.S2C_InputCorrection{
+			FrameNumber: message.GetFrameNumber(),
+			Rejected:    true,
+			Reason:      "frame too far in the future",
+		})
+		return
+	}
+	if !g.validateInput(player, message, frame) {
+		return
+	}
+	metrics.InputAdjustments.WithLabelValues(result).Inc()
+	if result == inputCorrected {
+		g.log.Debug("Late input moved to next unsent frame", logging.KeyPlayer, playerID,
+			"frame", message.GetFrameNumber(), "current", g.frameNumber)
+		g.sendInputCorrection(player, &pb.S2C_InputCorrection{
+			FrameNumber:  message.GetFrameNumber(),
+			AppliedFrame: frame,
+			Reason:       "frame already sent",
+		})
 	}
+	player.AddInput(frame, message.GetOperations())
+	g.log.Debug("Added operations", logging.KeyPlayer, playerID,
+		"bytes", len(message.GetOperations()), "frame", frame)
 }
 
 func (g *Game) handleGameEnd(_ network.IConn, message *pb.C2S_GameEnd) {
 	playerID := message.GetPlayerId()
 	endRequest := message.GetEndGame()
 
-	// 构造广播消息
-	broadcastMsg := &pb.MessageWrapper{
-		Msg: &pb.MessageWrapper_S2CGameEnd{
-			S2CGameEnd: &pb.S2C_GameEnd{
-				EndPlayer: playerID,
-				EndGame:   endRequest,
-				Payload:   message.GetPayload(),
-			},
-		},
-	}
-
 	// 广播给所有玩家
-	for _, p := range g.players {
-		select {
-		case p.conn.SendChan() <- broadcastMsg:
-		default:
-			log.Warn("Failed to send game end message to %s", p.playerID)
-		}
-	}
+	g.broadcastGameEnd(&pb.S2C_GameEnd{
+		EndPlayer: playerID,
+		EndGame:   endRequest,
+		Payload:   message.GetPayload(),
+	})
 
 	if endRequest {
 		// 强制结束游戏
@@ -175,39 +456,99 @@ func (g *Game) handleGameEnd(_ network.IConn, message *pb.C2S_GameEnd) {
 		return
 	}
 
-	// 标记当前玩家已结束
-	if player, exists := g.players[playerID]; exists {
-		player.ended = true
-		log.Info("Player %s has ended the game", playerID)
+	// 标记当前玩家已结束，大逃杀和团队模式由服务器判定名次和胜负
+	if player, exists := g.players[playerID]; exists && !player.ended {
+		g.log.Info("Player has ended the game", logging.KeyPlayer, playerID)
+		switch {
+		case g.royale != nil:
+			g.eliminate(player, g.royale.lastHit[playerID])
+		case g.team != nil:
+			g.finish(player)
+			g.checkTeams()
+		default:
+			g.finish(player)
+		}
 	}
 
 	// 检查是否所有玩家都已结束
-	if g.allPlayersEnded() {
-		log.Info("All players have ended, terminating game")
-		g.endGame()
+	if g.status != GameOver && g.allPlayersEnded() {
+		g.terminate()
+		return
+	}
+	// 结束的玩家不再需要同意暂停
+	if g.status == PlayingGame {
+		g.checkPauseVote()
 	}
 }
 
+// allPlayersEnded 除机器人代打的玩家之外都已结束
 func (g *Game) allPlayersEnded() bool {
 	for _, p := range g.players {
-		if !p.ended {
+		if !p.ended && !p.bot {
 			return false
 		}
 	}
 	return true
 }
 
+// terminate 所有玩家都已结束时结束游戏
+// 仍有机器人代打的玩家时由服务器宣布结束，这些玩家并列，团队模式中其队伍并列
+func (g *Game) terminate() {
+	var bots []string
+	for id, p := range g.players {
+		if !p.ended {
+			bots = append(bots, id)
+		}
+	}
+	if len(bots) == 0 {
+		g.log.Info("All players have ended, terminating game")
+		g.endGame()
+		return
+	}
+	g.log.Info("Only bots are left, terminating game", "bots", bots)
+	if g.team != nil {
+		for _, id := range bots {
+			g.team.places[g.team.members[id]] = 1
+		}
+		g.finishTeams(0)
+		return
+	}
+	g.broadcastGameEnd(&pb.S2C_GameEnd{EndGame: true})
+	g.endGame()
+}
+
 func (g *Game) endGame() {
 	g.status = GameOver
 	if g.ticker != nil {
 		g.ticker.Stop()
 	}
-	// 安全关闭消息通道
-	if g.messageChan != nil {
-		close(g.messageChan)
-		g.messageChan = nil
+	g.setPauseStatus(pb.PauseStatus_PAUSE_NONE, 0)
+	g.stopLoadTimer()
+	g.stopDisconnectTimer()
+	g.saveReplay()
+	g.recordMatch()
+	g.releaseConns()
+	close(g.done)
+	metrics.FrameBacklog.DeleteLabelValues(g.gameID)
+	g.log.Info("Game ended", "frames", g.frameNumber)
+}
+
+// releaseConns 在结束前把所有连接交还给lobby，丢弃已经进入消息通道的消息
+// 消息通道不关闭也不置空，仍持有游戏的连接发送时不会panic或阻塞
+func (g *Game) releaseConns() {
+	if g.lobby != nil {
+		for conn := range g.conns {
+			conn.SetHandler(g.lobby)
+		}
+	}
+	for {
+		select {
+		case msg := <-g.messageChan:
+			g.log.Debug("Dropped message after game over", logging.KeyConn, msg.Conn().ID())
+		default:
+			return
+		}
 	}
-	log.Info("Game %s ended", g.gameID)
 }
 
 func (g *Game) handlePlayingMessage(conn network.IConn, message *pb.MessageWrapper) {
@@ -216,21 +557,49 @@ func (g *Game) handlePlayingMessage(conn network.IConn, message *pb.MessageWrapp
 		g.handleInput(conn, msg.C2SInput)
 		return
 	case *pb.MessageWrapper_C2SHeartbeat:
-		// log.Info("Heartbeat:%s received", msg.C2SHeartbeat.GetPlayerId())
 		return
 	case *pb.MessageWrapper_C2SGameEnd:
 		g.handleGameEnd(conn, msg.C2SGameEnd)
 		return
+	case *pb.MessageWrapper_C2SStateHash:
+		g.handleStateHash(msg.C2SStateHash)
+		return
+	case *pb.MessageWrapper_C2SSetTarget:
+		g.handleSetTarget(msg.C2SSetTarget)
+		return
+	case *pb.MessageWrapper_C2SPause:
+		g.handlePause(msg.C2SPause)
+		return
 	default:
-		log.Error("Unknown message type: %T", msg)
+		g.log.Warn("Unknown message type", "type", metrics.MessageType(message))
 	}
 }
 
 func (g *Game) tick() {
+	tickStart := g.clock.Now()
+	if !g.lastTick.IsZero() {
+		jitter := tickStart.Sub(g.lastTick) - g.interval
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		metrics.TickJitter.Observe(jitter.Seconds())
+	}
+	g.lastTick = tickStart
+	g.deliverGarbage(g.frameNumber)
+	g.playBots()
+	var summaries []*pb.BoardSummary
+	if g.royale != nil && g.frameNumber%summaryInterval == 0 {
+		summaries = g.boardSummaries()
+	}
+
 	// 给每个接收玩家处理
 	for _, receiver := range g.players {
+		// 断线的玩家重连后从第0帧开始重新发送
+		if receiver.offline {
+			continue
+		}
 		start := receiver.lastSentFrame
-		end := g.frameNumber
+		end := min(g.frameNumber, start+maxSyncFrames-1)
 		if start > end {
 			continue
 		}
@@ -239,6 +608,9 @@ func (g *Game) tick() {
 		playerFrames := make([]*pb.S2C_PlayerFrames, 0, len(g.players))
 		// 收集所有玩家的帧数据（包括自己）
 		for _, player := range g.players {
+			if receiver.boards != nil && !receiver.boards[player.playerID] {
+				continue
+			}
 			var frames []*pb.S2C_Frame
 			for frameNum := start; frameNum <= end; frameNum++ {
 				frame := &pb.S2C_Frame{
@@ -246,6 +618,7 @@ func (g *Game) tick() {
 				}
 				if data, exists := player.frames[frameNum]; exists {
 					frame.Operations = data.Operations
+					frame.Garbage = data.Garbage
 				}
 				frames = append(frames, frame)
 			}
@@ -255,28 +628,48 @@ func (g *Game) tick() {
 			})
 		}
 
+		sync := &pb.S2C_SyncFrames{Players: playerFrames}
+		for _, summary := range summaries {
+			if !receiver.boards[summary.GetPlayerId()] {
+				sync.Summaries = append(sync.Summaries, summary)
+			}
+		}
 		syncMsg := &pb.MessageWrapper{
-			Msg: &pb.MessageWrapper_S2CSyncFrames{
-				S2CSyncFrames: &pb.S2C_SyncFrames{
-					Players: playerFrames,
-				},
-			},
+			Msg: &pb.MessageWrapper_S2CSyncFrames{S2CSyncFrames: sync},
 		}
 
 		select {
 		case receiver.conn.SendChan() <- syncMsg:
 			receiver.lastSentFrame = end
-			// log.Info("Sent %d player frames to %s", len(playerFrames), receiver.playerID)
 		default:
-			log.Warn("Failed to send %d player frames to %s", len(playerFrames), receiver.playerID)
+			metrics.SendDrops.WithLabelValues("sync_frames").Inc()
+			g.log.Warn("Failed to send player frames", logging.KeyPlayer, receiver.playerID,
+				"from", start, "to", end)
 		}
 	}
+
+	var backlog int32
+	for _, p := range g.players {
+		backlog = max(backlog, g.frameNumber-p.lastSentFrame)
+	}
+	metrics.FrameBacklog.WithLabelValues(g.gameID).Set(float64(backlog))
+	g.replay.recordFrame(g.frameNumber, g.players)
+	g.checkFrame(g.frameNumber)
+	if interval := g.settings.StatsInterval; interval > 0 && g.simulated() && g.status != GameOver && (g.frameNumber+1)%interval == 0 {
+		g.broadcastStats(g.frameNumber)
+	}
+	g.pruneStateHashes()
 	g.frameNumber++
+	metrics.TickDuration.Observe(g.clock.Now().Sub(tickStart).Seconds())
 }
 
 // 游戏主循环
 func (g *Game) gameLoop() {
-	log.Info("Game %s started", g.gameID)
+	g.log.Info("Game started")
+	g.startLoadTimer()
+	for id, p := range g.players {
+		g.watchConn(id, p.conn)
+	}
 	for {
 		switch g.status {
 		case WaitingGame:
@@ -285,6 +678,12 @@ func (g *Game) gameLoop() {
 				return
 			case msg := <-g.messageChan:
 				g.handleWaitingMessage(msg.Conn(), msg.Msg())
+			case f := <-g.controlChan:
+				f()
+			case <-g.loadTimeout():
+				g.handleLoadTimeout()
+			case <-g.disconnectTimer():
+				g.onDisconnectTimer()
 			}
 		case PlayingGame:
 			select {
@@ -292,16 +691,31 @@ func (g *Game) gameLoop() {
 				return
 			case msg := <-g.messageChan:
 				g.handlePlayingMessage(msg.Conn(), msg.Msg())
-			case <-g.ticker.C:
+			case f := <-g.controlChan:
+				f()
+			case <-g.ticker.C():
 				g.tick()
+			case <-g.pauseTimer():
+				g.onPauseTimer()
+			case <-g.disconnectTimer():
+				g.onDisconnectTimer()
 			}
-		case GameOver:
+		case PausedGame:
 			select {
 			case <-g.context.Done():
 				return
-			case <-g.messageChan:
-				log.Error("Game over, no more messages will be processed")
+			case msg := <-g.messageChan:
+				g.handlePlayingMessage(msg.Conn(), msg.Msg())
+			case f := <-g.controlChan:
+				f()
+			case <-g.pauseTimer():
+				g.onPauseTimer()
+			case <-g.disconnectTimer():
+				g.onDisconnectTimer()
 			}
+		case GameOver:
+			// endGame已经停止计时器并交还了连接，协程随之退出
+			return
 		}
 	}
 }
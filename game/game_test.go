@@ -0,0 +1,341 @@
+package game
+
+import (
+	"TetrisSvr/network"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func isGameLoadComplete(m *pb.MessageWrapper) bool { return m.GetS2CGameLoadComplete() != nil }
+func isSyncFrames(m *pb.MessageWrapper) bool       { return m.GetS2CSyncFrames() != nil }
+func isGameEnd(m *pb.MessageWrapper) bool          { return m.GetS2CGameEnd() != nil }
+
+// startTestGame 创建房间并开始游戏，返回已切换到Game的客户端
+func startTestGame(t *testing.T, playerIDs ...string) []*nettest.Client {
+	t.Helper()
+	return startTestGameWithConfig(t, testConfig(), playerIDs...)
+}
+
+func startTestGameWithConfig(t *testing.T, cfg *network.Config, playerIDs ...string) []*nettest.Client {
+	t.Helper()
+	return startTestGameOn(t, newTestRoomManagerWithConfig(t, cfg), playerIDs...)
+}
+
+func startTestGameOn(t *testing.T, m *RoomManager, playerIDs ...string) []*nettest.Client {
+	t.Helper()
+	return startModeGameOn(t, m, ModeVersus, playerIDs...)
+}
+
+// startModeGameOn 创建指定模式的房间并开始游戏
+func startModeGameOn(t *testing.T, m *RoomManager, mode string, playerIDs ...string) []*nettest.Client {
+	t.Helper()
+	clients := make([]*nettest.Client, len(playerIDs))
+	for i, id := range playerIDs {
+		clients[i] = nettest.NewClient(id, m)
+	}
+	roomID := createModeRoom(t, clients[0], mode)
+	for _, c := range clients[1:] {
+		enterRoom(t, c, roomID)
+	}
+	clients[0].StartGame(roomID)
+	for _, c := range clients {
+		mustWait(t, c, isStartGame)
+	}
+	return clients
+}
+
+// loadGame 所有客户端加载完成，进入游戏中状态
+func loadGame(t *testing.T, clients []*nettest.Client) {
+	t.Helper()
+	for _, c := range clients {
+		c.LoadComplete([]byte(c.PlayerID))
+	}
+	for _, c := range clients {
+		reply := mustWait(t, c, isGameLoadComplete).GetS2CGameLoadComplete()
+		if len(reply.GetMsg()) != len(clients) {
+			t.Fatalf("load complete has %d players, want %d", len(reply.GetMsg()), len(clients))
+		}
+	}
+}
+
+// findOperation 在同步帧中查找某个玩家的操作
+func findOperation(sync *pb.S2C_SyncFrames, playerID string, op []byte) (int32, bool) {
+	for _, p := range sync.GetPlayers() {
+		if p.GetPlayerId() != playerID {
+			continue
+		}
+		for _, f := range p.GetFrames() {
+			for _, o := range f.GetOperations() {
+				if bytes.Equal(o, op) {
+					return f.GetFrameNumber(), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestGameWaitsForAllPlayersToLoad(t *testing.T) {
+	clients := startTestGame(t, "p1", "p2")
+
+	clients[0].LoadComplete(nil)
+	clients[0].Heartbeat()
+	clients[0].Timeout = 100 * time.Millisecond
+	if _, err := clients[0].Next(); err == nil {
+		t.Fatal("game started before every player loaded")
+	}
+
+	clients[1].LoadComplete(nil)
+	for _, c := range clients {
+		mustWait(t, c, isGameLoadComplete)
+	}
+}
+
+func TestGameLifecycle(t *testing.T) {
+	clients := startTestGame(t, "p1", "p2")
+	loadGame(t, clients)
+
+	// 游戏开始后持续收到帧同步
+	for _, c := range clients {
+		sync := mustWait(t, c, isSyncFrames).GetS2CSyncFrames()
+		if len(sync.GetPlayers()) != len(clients) {
+			t.Fatalf("sync frames has %d players, want %d", len(sync.GetPlayers()), len(clients))
+		}
+	}
+
+	// 一个玩家的输入会同步给所有玩家
+	op := []byte("rotate")
+	clients[0].Input(op)
+	var frames []int32
+	for _, c := range clients {
+		msg := mustWait(t, c, func(m *pb.MessageWrapper) bool {
+			_, ok := findOperation(m.GetS2CSyncFrames(), "p1", op)
+			return ok
+		})
+		frame, _ := findOperation(msg.GetS2CSyncFrames(), "p1", op)
+		frames = append(frames, frame)
+	}
+	if frames[0] != frames[1] {
+		t.Fatalf("players saw the input in frames %v, want the same frame", frames)
+	}
+
+	// 玩家逐个结束，全部结束后游戏结束
+	clients[0].GameEnd(false, []byte("p1 score"))
+	for _, c := range clients {
+		end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		if end.GetEndPlayer() != "p1" || end.GetEndGame() {
+			t.Fatalf("got game end %v", end)
+		}
+	}
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[1].GameEnd(false, nil)
+	for _, c := range clients {
+		if end := mustWait(t, c, isGameEnd).GetS2CGameEnd(); end.GetEndPlayer() != "p2" {
+			t.Fatalf("got game end %v", end)
+		}
+	}
+	assertGameOver(t, game)
+}
+
+func TestGameManyFramesWithManualClock(t *testing.T) {
+	const frames = 3000
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	cfg := testConfig()
+	cfg.Clock = clock
+	clients := startTestGameWithConfig(t, cfg, "p1", "p2")
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+
+	for i := int32(0); i < frames; i++ {
+		if i == frames/2 {
+			clients[1].Input([]byte("drop"))
+			// Input与计时器走不同的通道，等待其被处理后再推进时间
+			waitForMessages(t, clients[1].Conn.Handler().(*Game))
+		}
+		clock.Advance(time.Second / 30)
+		for _, c := range clients {
+			sync := mustWait(t, c, isSyncFrames).GetS2CSyncFrames()
+			got := sync.GetPlayers()[0].GetFrames()
+			if last := got[len(got)-1].GetFrameNumber(); last != i {
+				t.Fatalf("tick %d: %s got last frame %d", i, c.PlayerID, last)
+			}
+			if i == frames/2 {
+				if frame, ok := findOperation(sync, "p2", []byte("drop")); !ok || frame != i {
+					t.Fatalf("tick %d: %s got drop in frame %d (found %v)", i, c.PlayerID, frame, ok)
+				}
+			}
+		}
+	}
+	if got := clock.Now().Sub(time.Unix(0, 0)); got != frames*(time.Second/30) {
+		t.Fatalf("clock advanced %v", got)
+	}
+}
+
+// waitForMessages 等待游戏协程处理完已投递的消息
+func waitForMessages(t *testing.T, g *Game) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for len(g.messageChan) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("game did not process pending messages")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGameForceEnd(t *testing.T) {
+	clients := startTestGame(t, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[1].GameEnd(true, nil)
+	for _, c := range clients {
+		end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		if end.GetEndPlayer() != "p2" || !end.GetEndGame() {
+			t.Fatalf("got game end %v", end)
+		}
+	}
+	assertGameOver(t, game)
+}
+
+func TestGameLoopExitsAfterGameOver(t *testing.T) {
+	clients := startTestGame(t, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[1].GameEnd(true, nil)
+	<-game.Done()
+	// 其他测试的游戏在各自的Cleanup中已经取消，此时不应再有游戏协程
+	deadline := time.Now().Add(time.Second)
+	for gameLoops() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("game loop still running after game over")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info := game.Info(); info.Status != GameOver {
+		t.Fatalf("Info().Status = %v after game over", info.Status)
+	}
+	if err := game.Kick("p1"); err == nil {
+		t.Fatal("Kick succeeded after game over")
+	}
+}
+
+// gameLoops 返回正在运行的游戏主循环协程数
+func gameLoops() int {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	return strings.Count(string(buf), "(*Game).gameLoop(")
+}
+
+// assertGameOver 等待结束后的游戏停止发送帧同步，玩家只会收到回到房间后的房间信息
+func assertGameOver(t *testing.T, g *Game) {
+	t.Helper()
+	for _, p := range g.players {
+		c := p.conn.(*nettest.FakeConn)
+		// 丢弃结束前已经发出的帧
+		for c.Pending() > 0 {
+			c.Recv(0)
+		}
+	}
+	for _, p := range g.players {
+		for {
+			msg, err := p.conn.(*nettest.FakeConn).Recv(100 * time.Millisecond)
+			if err != nil {
+				break
+			}
+			if !isRoomInfoChanged(msg) {
+				t.Fatalf("player %s got %v after game over", p.playerID, msg)
+			}
+		}
+	}
+}
+
+func TestDrainWaitsForRunningGames(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+
+	drained := make(chan error, 1)
+	go func() { drained <- m.Drain(context.Background(), "maintenance") }()
+	for _, c := range clients {
+		mustWait(t, c, isServerShutdown)
+	}
+
+	// 玩家正常结束游戏后Drain返回
+	clients[0].GameEnd(true, nil)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not return after the game ended")
+	}
+}
+
+func TestDrainStopsGamesAtDeadline(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := m.Drain(ctx, "maintenance"); err != context.DeadlineExceeded {
+		t.Fatalf("Drain = %v, want %v", err, context.DeadlineExceeded)
+	}
+	for _, c := range clients {
+		end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		if !end.GetEndGame() || end.GetEndPlayer() != "" {
+			t.Fatalf("got game end %v, want server end", end)
+		}
+	}
+	select {
+	case <-game.Done():
+	default:
+		t.Fatal("game still running after forced drain")
+	}
+}
+
+func TestInputAfterStop(t *testing.T) {
+	// 游戏停止前连接已经交还给管理器，之后的输入不会阻塞接收协程
+	m := newTestRoomManager(t)
+	clients := startTestGameOn(t, m, "p1", "p2")
+	loadGame(t, clients)
+	game := clients[0].Conn.Handler().(*Game)
+	stale := game.HandleChan()
+
+	game.Stop()
+	<-game.Done()
+	for _, c := range clients {
+		if c.Conn.Handler() != m {
+			t.Fatalf("%s handler = %T after game over, want the room manager", c.PlayerID, c.Conn.Handler())
+		}
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		for i := int32(0); i < 2*testConfig().ReceiveChanSize; i++ {
+			clients[0].Input([]byte{1})
+		}
+		// 仍持有旧通道的发送方也不会panic
+		select {
+		case stale <- network.NewConnMessage(clients[0].Conn, &pb.MessageWrapper{}):
+		default:
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("input after Stop blocked")
+	}
+}
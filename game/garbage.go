@@ -0,0 +1,165 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"math/rand/v2"
+	"sort"
+)
+
+// incomingGarbage 已经发出、还没有到达目标的攻击
+type incomingGarbage struct {
+	from, to string
+	lines    int32
+	hole     int32
+	// arrive 到达目标的帧，在该帧开始时进入目标的待收垃圾
+	arrive int32
+}
+
+// garbageRouter 把玩家的攻击发给目标，经过延迟后作为服务器事件放入目标的帧
+// 只在游戏协程中使用
+type garbageRouter struct {
+	delay  int32
+	cancel bool
+	// rng 由种子决定的空缺列，客户端只需要照搬事件中的hole
+	rng      *rand.Rand
+	inFlight []*incomingGarbage
+	// next 对战中每个攻击者下一次攻击的目标序号
+	next map[string]int
+}
+
+func newGarbageRouter(setup *pb.MatchSetup) *garbageRouter {
+	seed := uint64(setup.GetSeed())
+	return &garbageRouter{
+		delay:  setup.GetGarbage().GetDelayFrames(),
+		cancel: setup.GetGarbage().GetCancel(),
+		rng:    rand.New(rand.NewPCG(seed, seed^0x9E3779B97F4A7C15)),
+		next:   make(map[string]int),
+	}
+}
+
+// attack 处理from在frame帧发出的攻击，返回被抵消的行数
+// 允许抵消时先抵消正在飞向from的攻击，剩余的发给target，target为空时丢弃
+func (r *garbageRouter) attack(from string, lines, frame int32, target string) int32 {
+	canceled := int32(0)
+	if r.cancel {
+		for _, in := range r.inFlight {
+			if lines == 0 {
+				break
+			}
+			if in.to != from || in.lines == 0 {
+				continue
+			}
+			n := min(lines, in.lines)
+			in.lines -= n
+			lines -= n
+			canceled += n
+		}
+	}
+	if lines == 0 || target == "" {
+		return canceled
+	}
+	r.inFlight = append(r.inFlight, &incomingGarbage{
+		from:   from,
+		to:     target,
+		lines:  lines,
+		hole:   int32(r.rng.IntN(tetris.Width)),
+		arrive: frame + 1 + r.delay,
+	})
+	return canceled
+}
+
+// roundRobin 轮流攻击存活的对手
+func (r *garbageRouter) roundRobin(from string, opponents []string) string {
+	target := opponents[r.next[from]%len(opponents)]
+	r.next[from]++
+	return target
+}
+
+// arrivals 取出frame帧及之前到达的攻击，按发出的顺序排列
+func (r *garbageRouter) arrivals(frame int32) []*incomingGarbage {
+	var due []*incomingGarbage
+	pending := r.inFlight[:0]
+	for _, in := range r.inFlight {
+		switch {
+		case in.lines == 0:
+		case in.arrive <= frame:
+			due = append(due, in)
+		default:
+			pending = append(pending, in)
+		}
+	}
+	r.inFlight = pending
+	return due
+}
+
+// AddGarbage 服务器在某一帧放入玩家待收垃圾的事件
+func (p *GamePlayer) AddGarbage(frame int32, garbage *pb.Garbage) {
+	if _, exists := p.frames[frame]; !exists {
+		p.frames[frame] = &FrameData{}
+	}
+	p.frames[frame].Garbage = append(p.frames[frame].Garbage, garbage)
+}
+
+// alive 没有结束且模拟中没有堆到顶的玩家
+func (p *GamePlayer) alive() bool {
+	return !p.ended && (p.check.sim == nil || !p.check.sim.Over())
+}
+
+// opponents 可以被from攻击的玩家，不包括队友，按ID排序
+func (g *Game) opponents(from string) []string {
+	var ids []string
+	for id, p := range g.players {
+		if id != from && p.alive() && !g.teammates(id, from) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// target 选择from这次攻击的目标，没有存活的对手时返回空
+func (g *Game) target(from string) string {
+	opponents := g.opponents(from)
+	if len(opponents) == 0 {
+		return ""
+	}
+	if g.royale != nil {
+		return g.royale.target(from, opponents, g.players)
+	}
+	return g.garbage.roundRobin(from, opponents)
+}
+
+// routeAttack 将玩家在frame帧实际发出的攻击交给路由
+func (g *Game) routeAttack(from *GamePlayer, lines, frame int32) {
+	if g.royale != nil {
+		lines = g.royale.boost(from.playerID, lines)
+	}
+	target := g.target(from.playerID)
+	canceled := g.garbage.attack(from.playerID, lines, frame, target)
+	metrics.GarbageLines.WithLabelValues("canceled").Add(float64(canceled))
+	if target != "" {
+		metrics.GarbageLines.WithLabelValues("sent").Add(float64(lines - canceled))
+	}
+	g.log.Debug("Attack routed", logging.KeyPlayer, from.playerID, "lines", lines,
+		"canceled", canceled, "target", target, "frame", frame)
+}
+
+// deliverGarbage 在发出frame帧之前把到达的攻击放入目标的帧，已经结束的目标不再接收
+func (g *Game) deliverGarbage(frame int32) {
+	if g.garbage == nil {
+		return
+	}
+	for _, in := range g.garbage.arrivals(frame) {
+		target, ok := g.players[in.to]
+		if !ok || !target.alive() {
+			continue
+		}
+		target.AddGarbage(frame, &pb.Garbage{From: in.from, Lines: in.lines, Hole: in.hole})
+		if g.royale != nil {
+			g.royale.lastHit[in.to] = in.from
+		}
+	}
+}
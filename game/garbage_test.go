@@ -0,0 +1,108 @@
+package game
+
+import (
+	pb "TetrisSvr/proto"
+	"testing"
+)
+
+func TestGarbageRouter(t *testing.T) {
+	r := newGarbageRouter(&pb.MatchSetup{Seed: 1, Garbage: &pb.GarbageRules{Cancel: true, DelayFrames: 2}})
+
+	// 轮流攻击对手
+	for _, lines := range []int32{2, 1, 1} {
+		r.attack("p1", lines, 10, r.roundRobin("p1", []string{"p2", "p3"}))
+	}
+	if due := r.arrivals(12); len(due) != 0 {
+		t.Fatalf("got %d arrivals before the delay", len(due))
+	}
+	due := r.arrivals(13)
+	if len(due) != 3 {
+		t.Fatalf("got %d arrivals at frame 13, want 3", len(due))
+	}
+	for i, want := range []string{"p2", "p3", "p2"} {
+		if due[i].to != want || due[i].arrive != 13 {
+			t.Errorf("arrival %d: got %+v, want %s at frame 13", i, due[i], want)
+		}
+		if due[i].hole < 0 || due[i].hole >= 10 {
+			t.Errorf("arrival %d: hole %d outside the board", i, due[i].hole)
+		}
+	}
+
+	// p2的攻击先抵消飞向自己的垃圾，剩余的发给p1
+	r.attack("p1", 3, 20, "p2")
+	if canceled := r.attack("p2", 4, 21, "p1"); canceled != 3 {
+		t.Fatalf("canceled %d lines, want 3", canceled)
+	}
+	due = r.arrivals(30)
+	if len(due) != 1 || due[0].to != "p1" || due[0].lines != 1 {
+		t.Fatalf("got %+v, want 1 line to p1", due)
+	}
+}
+
+func TestGarbageRouterWithoutCancel(t *testing.T) {
+	r := newGarbageRouter(&pb.MatchSetup{Seed: 1, Garbage: &pb.GarbageRules{}})
+	r.attack("p1", 3, 0, "p2")
+	if canceled := r.attack("p2", 2, 0, "p1"); canceled != 0 {
+		t.Fatalf("canceled %d lines with cancel disabled", canceled)
+	}
+	if due := r.arrivals(1); len(due) != 2 {
+		t.Fatalf("got %d arrivals, want both attacks", len(due))
+	}
+}
+
+func TestGameDeliversGarbage(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{Rules: MatchRules{GarbageDelay: 2}}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	var sent int32
+	game.run(func() {
+		sent = game.frameNumber
+		game.routeAttack(game.players["p1"], 4, sent)
+	})
+	arrive := sent + 3
+
+	// 每次推进时间后等待同步帧，避免计时器合并多个tick
+	var got *pb.Garbage
+	for i := sent; got == nil; i++ {
+		if i > arrive {
+			t.Fatalf("no garbage delivered by frame %d", arrive)
+		}
+		clock.Advance(FrameInterval)
+		sync := mustWait(t, clients[1], isSyncFrames).GetS2CSyncFrames()
+		for _, p := range sync.GetPlayers() {
+			for _, f := range p.GetFrames() {
+				for _, garbage := range f.GetGarbage() {
+					if p.GetPlayerId() != "p2" || f.GetFrameNumber() != arrive {
+						t.Fatalf("got garbage for %s at frame %d, want p2 at %d", p.GetPlayerId(), f.GetFrameNumber(), arrive)
+					}
+					got = garbage
+				}
+			}
+		}
+	}
+	if got.GetFrom() != "p1" || got.GetLines() != 4 {
+		t.Fatalf("got %v, want 4 lines from p1", got)
+	}
+
+	var pending int
+	game.run(func() { pending = game.players["p2"].check.sim.PendingGarbage() })
+	if pending != 4 {
+		t.Fatalf("simulation has %d pending lines, want 4", pending)
+	}
+}
+
+func TestNoGarbageWithoutValidation(t *testing.T) {
+	// 客户端没有实现服务器的规则时，模拟产生的攻击不可信，服务器不路由垃圾
+	clock, clients := startClockedGame(t, ModeVersus, Settings{}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	var routed bool
+	game.run(func() { routed = game.garbage != nil })
+	if routed {
+		t.Fatal("garbage router created without input validation")
+	}
+	for i := 0; i < 3; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[1], isSyncFrames)
+	}
+}
@@ -0,0 +1,47 @@
+package game
+
+import (
+	"time"
+)
+
+// 输入帧号的处理结果，同时作为metrics.InputAdjustments的标签
+const (
+	inputAccepted = "accepted"
+	// inputCorrected 标记的帧已经发出，放入下一个未发出的帧
+	inputCorrected = "corrected"
+	// inputRejected 标记的帧超出了输入延迟允许的范围
+	inputRejected = "rejected_future"
+	// inputInvalid 输入没有通过validateInput的检查
+	inputInvalid = "rejected_invalid"
+)
+
+// inputDelay 根据延迟最高的玩家计算输入延迟帧数
+// 输入需要单程时间(RTT/2)加上两倍抖动的余量才能在对应帧发出前到达服务器，
+// 结果不小于lo，hi大于0时不超过hi
+func inputDelay(players map[string]*GamePlayer, interval time.Duration, lo, hi int32) int32 {
+	var worst time.Duration
+	for _, p := range players {
+		stats := p.conn.Stats()
+		worst = max(worst, stats.RTT/2+2*stats.Jitter)
+	}
+	delay := max(int32((worst+interval-1)/interval), lo)
+	if hi > 0 {
+		delay = min(delay, hi)
+	}
+	return delay
+}
+
+// placeInput 返回输入实际所在的帧
+// current为下一个要发出的帧，早于current的帧已经发给客户端不能再修改，
+// 晚到的输入放入current；
+// 超过current+delay的输入不可能由按时运行的客户端产生，直接拒绝
+func placeInput(current, target, delay int32) (int32, string) {
+	switch {
+	case target > current+delay:
+		return 0, inputRejected
+	case target >= current:
+		return target, inputAccepted
+	default:
+		return current, inputCorrected
+	}
+}
@@ -0,0 +1,126 @@
+package game
+
+import (
+	"TetrisSvr/network"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"context"
+	"testing"
+	"time"
+)
+
+func isInputCorrection(m *pb.MessageWrapper) bool { return m.GetS2CInputCorrection() != nil }
+
+func TestPlaceInput(t *testing.T) {
+	tests := []struct {
+		target    int32
+		wantFrame int32
+		want      string
+	}{
+		{target: 10, wantFrame: 10, want: inputAccepted},
+		{target: 13, wantFrame: 13, want: inputAccepted},
+		{target: 14, want: inputRejected},
+		{target: 9, wantFrame: 10, want: inputCorrected},
+		{target: 0, wantFrame: 10, want: inputCorrected},
+	}
+	for _, tt := range tests {
+		frame, result := placeInput(10, tt.target, 3)
+		if result != tt.want || (result != inputRejected && frame != tt.wantFrame) {
+			t.Errorf("placeInput(10, %d, 3) = %d, %s; want %d, %s", tt.target, frame, result, tt.wantFrame, tt.want)
+		}
+	}
+}
+
+func TestInputDelay(t *testing.T) {
+	slow := nettest.NewFakeConn(nil, 1)
+	slow.SetStats(network.NetStats{RTT: 150 * time.Millisecond, Jitter: 10 * time.Millisecond})
+	fast := nettest.NewFakeConn(nil, 1)
+	fast.SetStats(network.NetStats{RTT: 20 * time.Millisecond})
+	players := map[string]*GamePlayer{
+		"slow": {playerID: "slow", conn: slow},
+		"fast": {playerID: "fast", conn: fast},
+	}
+
+	// 75ms + 20ms 需要3帧
+	if got := inputDelay(players, time.Second/30, 1, 10); got != 3 {
+		t.Errorf("delay = %d, want 3", got)
+	}
+	if got := inputDelay(players, time.Second/30, 5, 10); got != 5 {
+		t.Errorf("delay = %d, want min 5", got)
+	}
+	if got := inputDelay(players, time.Second/30, 1, 2); got != 2 {
+		t.Errorf("delay = %d, want max 2", got)
+	}
+}
+
+func TestGameInputDelay(t *testing.T) {
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	cfg := testConfig()
+	cfg.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m := NewRoomManager(ctx, cfg, &UniqueIDRoomCreator{})
+	m.Start()
+	if err := m.ApplySettings(Settings{MinInputDelay: 2, MaxInputDelay: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	clients := startTestGameOn(t, m, "p1", "p2")
+	clients[1].Conn.SetStats(network.NetStats{RTT: 200 * time.Millisecond})
+	for _, c := range clients {
+		c.LoadComplete(nil)
+	}
+	for _, c := range clients {
+		if delay := mustWait(t, c, isGameLoadComplete).GetS2CGameLoadComplete().GetInputDelay(); delay != 4 {
+			t.Fatalf("%s: input delay %d, want 4", c.PlayerID, delay)
+		}
+	}
+	clock.BlockUntil(1)
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 第0帧时发出的输入标记为第4帧，晚到的放入下一个未发出的帧，过早的被拒绝
+	clients[0].InputAt(4, []byte("ahead"))
+	clients[1].InputAt(9, []byte("future"))
+	waitForMessages(t, game)
+	rejected := mustWait(t, clients[1], isInputCorrection).GetS2CInputCorrection()
+	if !rejected.GetRejected() || rejected.GetFrameNumber() != 9 {
+		t.Fatalf("got correction %v, want rejection of frame 9", rejected)
+	}
+	for i := int32(0); i < 3; i++ {
+		clock.Advance(time.Second / 30)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+	clients[1].InputAt(0, []byte("late"))
+	waitForMessages(t, game)
+	corrected := mustWait(t, clients[1], isInputCorrection).GetS2CInputCorrection()
+	if corrected.GetRejected() || corrected.GetFrameNumber() != 0 || corrected.GetAppliedFrame() != 3 {
+		t.Fatalf("got correction %v, want frame 0 moved to 3", corrected)
+	}
+
+	var sawAhead, sawLate bool
+	for i := int32(3); i <= 4; i++ {
+		clock.Advance(time.Second / 30)
+		sync := mustWait(t, clients[0], isSyncFrames).GetS2CSyncFrames()
+		if frame, ok := findOperation(sync, "p1", []byte("ahead")); ok {
+			if frame != 4 {
+				t.Fatalf("ahead input in frame %d, want 4", frame)
+			}
+			sawAhead = true
+		}
+		if frame, ok := findOperation(sync, "p2", []byte("late")); ok {
+			if frame != 3 {
+				t.Fatalf("late input in frame %d, want 3", frame)
+			}
+			sawLate = true
+		}
+		if _, ok := findOperation(sync, "p2", []byte("future")); ok {
+			t.Fatal("input beyond the delay horizon was not rejected")
+		}
+	}
+	if !sawAhead || !sawLate {
+		t.Fatalf("saw ahead %v, late %v", sawAhead, sawLate)
+	}
+	if info := game.Info(); info.InputDelay != 4 {
+		t.Fatalf("info input delay %d, want 4", info.InputDelay)
+	}
+}
@@ -0,0 +1,98 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"sort"
+	"time"
+)
+
+// 加载超时后的处理策略
+const (
+	// LoadTimeoutStart 没有加载完成的玩家弃权，其余玩家开始游戏
+	LoadTimeoutStart = "start"
+	// LoadTimeoutAbort 取消游戏，所有玩家回到房间
+	LoadTimeoutAbort = "abort"
+)
+
+// startLoadTimer 开始等待玩家加载，Settings.LoadTimeout为0时一直等待
+func (g *Game) startLoadTimer() {
+	if g.settings.LoadTimeout > 0 {
+		g.loadTimer = g.clock.NewTimer(g.settings.LoadTimeout)
+	}
+}
+
+// stopLoadTimer 开始游戏或游戏结束时停止等待
+func (g *Game) stopLoadTimer() {
+	if g.loadTimer != nil {
+		g.loadTimer.Stop()
+		g.loadTimer = nil
+	}
+}
+
+// loadTimeout 加载超时的计时器，没有等待时为nil
+func (g *Game) loadTimeout() <-chan time.Time {
+	if g.loadTimer == nil {
+		return nil
+	}
+	return g.loadTimer.C()
+}
+
+// canStartWithout 去掉missing后的玩家是否还能进行比赛：
+// 多人游戏至少需要两名玩家，团队模式中每个队伍至少需要一名玩家
+func (g *Game) canStartWithout(missing []string) bool {
+	ready := len(g.players) - len(missing)
+	if ready < min(2, len(g.players)) {
+		return false
+	}
+	if g.team == nil {
+		return true
+	}
+	teams := make(map[int32]bool)
+	for id, p := range g.players {
+		if p.ready {
+			teams[g.team.members[id]] = true
+		}
+	}
+	return len(teams) == teamCount
+}
+
+// handleLoadTimeout 不再等待没有加载完成的玩家并广播结果
+// LoadTimeoutStart时这些玩家弃权，其余玩家开始游戏；LoadTimeoutAbort或剩下的玩家不足以进行比赛时取消游戏
+func (g *Game) handleLoadTimeout() {
+	g.loadTimer = nil
+	var missing []string
+	for id, p := range g.players {
+		if !p.ready {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	aborted := g.settings.LoadTimeoutPolicy == LoadTimeoutAbort || !g.canStartWithout(missing)
+	g.log.Warn("Players did not finish loading", "missing", missing, "aborted", aborted,
+		"timeout", g.settings.LoadTimeout)
+
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CLoadTimeout{
+			S2CLoadTimeout: &pb.S2C_LoadTimeout{Missing: missing, Aborted: aborted},
+		},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- msg:
+		default:
+			metrics.SendDrops.WithLabelValues("load_timeout").Inc()
+			g.log.Warn("Failed to send load timeout", logging.KeyPlayer, p.playerID)
+		}
+	}
+
+	if aborted {
+		g.endGame()
+		return
+	}
+	// 移出最后一名玩家后其余玩家都已加载完成，removePlayer会开始游戏
+	for _, id := range missing {
+		g.removePlayer(id)
+	}
+}
@@ -0,0 +1,69 @@
+package game
+
+import (
+	pb "TetrisSvr/proto"
+	"testing"
+	"time"
+)
+
+func isLoadTimeout(m *pb.MessageWrapper) bool { return m.GetS2CLoadTimeout() != nil }
+
+func TestLoadTimeoutStartsWithoutMissingPlayers(t *testing.T) {
+	clock, m := newClockedRoomManager(t, Settings{LoadTimeout: 5 * time.Second, LoadTimeoutPolicy: LoadTimeoutStart})
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2", "p3")
+	game := clients[0].Conn.Handler().(*Game)
+	clients[0].LoadComplete(nil)
+	clients[1].LoadComplete(nil)
+	waitForMessages(t, game)
+
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	for _, c := range clients {
+		timeout := mustWait(t, c, isLoadTimeout).GetS2CLoadTimeout()
+		if timeout.GetAborted() || len(timeout.GetMissing()) != 1 || timeout.GetMissing()[0] != "p3" {
+			t.Fatalf("got %v, want p3 forfeited", timeout)
+		}
+	}
+	if end := mustWait(t, clients[0], isGameEnd).GetS2CGameEnd(); end.GetEndPlayer() != "p3" {
+		t.Fatalf("got %v, want p3 ended", end)
+	}
+	if reply := mustWait(t, clients[1], isGameLoadComplete).GetS2CGameLoadComplete(); len(reply.GetMsg()) != 2 {
+		t.Fatalf("game started with %v, want p1 and p2", reply.GetMsg())
+	}
+
+	// 弃权后才加载完成的玩家被忽略
+	clients[2].LoadComplete(nil)
+	if info := game.Info(); info.Status != PlayingGame || len(info.Players) != 2 {
+		t.Fatalf("game is %s with %d players", info.Status, len(info.Players))
+	}
+}
+
+func TestLoadTimeoutAbort(t *testing.T) {
+	// 只剩一名玩家时即使策略为start也取消游戏
+	clock, m := newClockedRoomManager(t, Settings{LoadTimeout: 5 * time.Second, LoadTimeoutPolicy: LoadTimeoutStart})
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	clients[0].LoadComplete(nil)
+	waitForMessages(t, game)
+
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	for _, c := range clients {
+		if timeout := mustWait(t, c, isLoadTimeout).GetS2CLoadTimeout(); !timeout.GetAborted() {
+			t.Fatalf("got %v, want the game aborted", timeout)
+		}
+	}
+	<-game.Done()
+
+	// 回到房间后可以重新开始
+	for _, c := range clients {
+		mustWait(t, c, isRoomInfoChanged)
+	}
+	roomID := game.roomID
+	clients[0].StartGame(roomID)
+	for _, c := range clients {
+		if reply := mustWait(t, c, isStartGame).GetS2CStartGame(); reply.GetError() {
+			t.Fatalf("restart failed: %s", reply.GetErrorMsg())
+		}
+	}
+}
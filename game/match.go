@@ -0,0 +1,90 @@
+package game
+
+import (
+	"TetrisSvr/history"
+	"TetrisSvr/logging"
+	"slices"
+	"sort"
+	"time"
+)
+
+// finish 标记玩家已结束并记录结束顺序
+func (g *Game) finish(p *GamePlayer) {
+	p.ended = true
+	g.finished = append(g.finished, p)
+}
+
+// participants 参加比赛的玩家：按结束顺序排列的已结束玩家，包括被移出游戏的玩家，和按ID排序的未结束玩家
+func (g *Game) participants() (finished, alive []*GamePlayer) {
+	for _, p := range g.players {
+		if !p.ended {
+			alive = append(alive, p)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool { return alive[i].playerID < alive[j].playerID })
+	// 加载阶段就离开的玩家没有参加比赛
+	finished = slices.DeleteFunc(slices.Clone(g.finished), func(p *GamePlayer) bool { return p.check.sim == nil })
+	return finished, alive
+}
+
+// matchRecord 生成比赛记录
+// 名次按结束顺序从后往前排列，游戏结束时仍未结束的玩家并列第一；团队模式中队员取队伍的名次
+// 玩家的帧数、方块数、行数和攻击来自模拟，不使用模拟时为0
+func (g *Game) matchRecord() history.Match {
+	finished, alive := g.participants()
+	places := make(map[string]int32)
+	for _, p := range alive {
+		places[p.playerID] = 1
+	}
+	for i, p := range finished {
+		places[p.playerID] = int32(len(finished) - i + len(alive))
+	}
+
+	flagged := g.flaggedPlayers()
+	m := history.Match{
+		GameID:     g.gameID,
+		RoomID:     g.roomID,
+		Mode:       g.setup.GetMode(),
+		StartedAt:  g.replay.StartedAt,
+		EndedAt:    g.replay.EndedAt,
+		Frames:     g.frameNumber,
+		DurationMs: (g.interval * time.Duration(g.frameNumber)).Milliseconds(),
+		Voided:     g.voided,
+		Replay:     g.replayPath,
+	}
+	for _, p := range slices.Concat(finished, alive) {
+		player := history.MatchPlayer{
+			ID:      p.playerID,
+			Place:   places[p.playerID],
+			Flagged: slices.Contains(flagged, p.playerID),
+		}
+		if g.simulated() {
+			stats := p.check.sim.Stats()
+			player.Frames, player.Pieces, player.Lines, player.LinesSent = stats.Frames, stats.Pieces, stats.Lines, stats.Attack
+		}
+		if g.team != nil {
+			player.Team = g.team.members[p.playerID]
+			if place := g.team.places[player.Team]; place > 0 {
+				player.Place = place
+			}
+		}
+		m.Players = append(m.Players, player)
+	}
+	sort.SliceStable(m.Players, func(i, j int) bool {
+		if m.Players[i].Place != m.Players[j].Place {
+			return m.Players[i].Place < m.Players[j].Place
+		}
+		return m.Players[i].ID < m.Players[j].ID
+	})
+	return m
+}
+
+// recordMatch 游戏结束时保存比赛记录，没有开始的游戏不记录
+func (g *Game) recordMatch() {
+	if g.replay == nil || g.services.History == nil {
+		return
+	}
+	if err := g.services.History.Record(g.matchRecord()); err != nil {
+		g.log.Error("Failed to record match", logging.KeyError, err)
+	}
+}
@@ -0,0 +1,134 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/history"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func isPlayerProfile(m *pb.MessageWrapper) bool { return m.GetS2CPlayerProfile() != nil }
+
+func TestGameIDsAreUnique(t *testing.T) {
+	// 重启后房间号从头开始，游戏ID仍然不能与之前的记录重复
+	now := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	seen := make(map[string]bool)
+	for range 100 {
+		id := newGameID(now)
+		if seen[id] {
+			t.Fatalf("duplicate game ID %s", id)
+		}
+		seen[id] = true
+		if !strings.HasPrefix(id, "20261019T083000-") {
+			t.Fatalf("game ID %s does not start with the start time", id)
+		}
+	}
+}
+
+func TestRecordMatch(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{}, "p1", "p2", "p3")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input(ops(tetris.OpHardDrop, tetris.OpHardDrop))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+
+	// 先结束的名次靠后，被移出游戏的玩家仍然计入比赛
+	clients[1].GameEnd(false, nil)
+	mustWait(t, clients[1], isGameEnd)
+	if err := game.Kick("p1"); err != nil {
+		t.Fatal(err)
+	}
+	clients[2].GameEnd(false, nil)
+	for _, c := range clients[1:] {
+		mustWait(t, c, func(m *pb.MessageWrapper) bool { return m.GetS2CGameEnd().GetEndPlayer() == "p3" })
+	}
+	assertGameOver(t, game)
+
+	matches, total, _ := game.services.History.Matches("p1", 0, 10)
+	if total != 1 {
+		t.Fatalf("p1 has %d matches, want 1", total)
+	}
+	m := matches[0]
+	if m.GameID != game.ID() || m.Mode != ModeVersus || m.Frames != 1 || m.DurationMs != FrameInterval.Milliseconds() {
+		t.Fatalf("match %+v", m)
+	}
+	want := []struct {
+		id    string
+		place int32
+	}{{"p3", 1}, {"p1", 2}, {"p2", 3}}
+	for i, w := range want {
+		if p := m.Players[i]; p.ID != w.id || p.Place != w.place {
+			t.Fatalf("players %+v, want %s in place %d", m.Players, w.id, w.place)
+		}
+	}
+	if p1 := m.Players[1]; p1.Pieces != 2 || p1.Frames != 1 {
+		t.Fatalf("p1 %+v, want 2 pieces in 1 frame", p1)
+	}
+	if stats, _ := game.services.History.Stats("p3"); stats.Games != 1 || stats.Wins != 1 {
+		t.Fatalf("p3 stats %+v", stats)
+	}
+}
+
+func TestRecordMatchWithoutValidation(t *testing.T) {
+	// 不使用模拟时只记录名次，不记录模拟出的方块数
+	clock, clients := startClockedGame(t, ModeVersus, Settings{}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input(ops(tetris.OpHardDrop, tetris.OpHardDrop))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+	clients[1].GameEnd(true, nil)
+	mustWait(t, clients[0], isGameEnd)
+	<-game.Done()
+
+	matches, _, _ := game.services.History.Matches("p1", 0, 10)
+	if len(matches) != 1 {
+		t.Fatalf("p1 has %d matches, want 1", len(matches))
+	}
+	for _, p := range matches[0].Players {
+		if p.Pieces != 0 || p.Frames != 0 || p.Lines != 0 || p.LinesSent != 0 {
+			t.Fatalf("players %+v, want no simulated stats", matches[0].Players)
+		}
+	}
+}
+
+func TestQueryPlayerProfile(t *testing.T) {
+	m := newTestRoomManager(t)
+	for _, id := range []string{"g1", "g2"} {
+		m.services.History.Record(history.Match{
+			GameID:     id,
+			Mode:       ModeVersus,
+			EndedAt:    time.Unix(100, 0),
+			Frames:     1800,
+			DurationMs: 60000,
+			Players: []history.MatchPlayer{
+				{ID: "p1", Place: 1, Frames: 1800, Pieces: 120, LinesSent: 20},
+				{ID: "p2", Place: 2, Frames: 1800},
+			},
+		})
+	}
+
+	c := nettest.NewClient("p2", m)
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SPlayerProfile{
+			C2SPlayerProfile: &pb.C2S_PlayerProfile{PlayerId: "p2", TargetId: "p1", Limit: 1},
+		},
+	})
+	reply := mustWait(t, c, isPlayerProfile).GetS2CPlayerProfile()
+	stats := reply.GetStats()
+	if reply.GetError() || stats.GetPlayerId() != "p1" || stats.GetGames() != 2 || stats.GetWins() != 2 {
+		t.Fatalf("got %v, want p1 with 2 wins", reply)
+	}
+	if stats.GetApm() != 20 || stats.GetPps() != 2 || stats.GetRating() != DefaultRating || stats.GetLastPlayed() != 100000 {
+		t.Fatalf("stats %v", stats)
+	}
+	if reply.GetTotal() != 2 || len(reply.GetMatches()) != 1 || reply.GetMatches()[0].GetGameId() != "g2" {
+		t.Fatalf("got %d matches %v, want the newest of 2", reply.GetTotal(), reply.GetMatches())
+	}
+}
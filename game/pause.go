@@ -0,0 +1,268 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// pauseVoteTimeout 全体同意的暂停投票等待的时长，超时后取消
+	pauseVoteTimeout = 15 * time.Second
+	// resumeCountdown 恢复前的倒计时，所有客户端在同一时间继续
+	resumeCountdown = 3 * time.Second
+)
+
+// pauseState 房间的暂停规则和当前的投票或暂停，只在游戏协程中使用
+type pauseState struct {
+	policy *pb.PausePolicy
+	// host 开始游戏时的房主，HOST_ONLY时只有房主可以暂停和恢复
+	host        string
+	status      pb.PauseStatus
+	requestedBy string
+	votes       map[string]bool
+	// pausedAt 本次暂停开始的时间，deadline 当前状态的截止时间，零值表示没有截止时间
+	pausedAt time.Time
+	deadline time.Time
+	timer    network.Timer
+	// used 每个玩家已经暂停的次数
+	used map[string]int32
+}
+
+// setPausePolicy 在游戏开始前设置房间的暂停规则
+func (g *Game) setPausePolicy(host string, policy *pb.PausePolicy) {
+	g.pause = pauseState{policy: policy, host: host, used: make(map[string]int32)}
+}
+
+// maxPause 每次暂停的最长时间，取房间规则和服务器上限中较小的一个，0表示不限制
+func (g *Game) maxPause() time.Duration {
+	limit := g.settings.MaxPause
+	if d := time.Duration(g.pause.policy.GetMaxDurationMs()) * time.Millisecond; d > 0 && (limit == 0 || d < limit) {
+		limit = d
+	}
+	return limit
+}
+
+// pauseTimer 当前截止时间的计时器，没有截止时间时为nil
+func (g *Game) pauseTimer() <-chan time.Time {
+	if g.pause.timer == nil {
+		return nil
+	}
+	return g.pause.timer.C()
+}
+
+// setPauseStatus 切换暂停状态，d大于0时在d之后触发onPauseTimer
+func (g *Game) setPauseStatus(status pb.PauseStatus, d time.Duration) {
+	if g.pause.timer != nil {
+		g.pause.timer.Stop()
+		g.pause.timer = nil
+	}
+	g.pause.status = status
+	g.pause.deadline = time.Time{}
+	if d > 0 {
+		g.pause.deadline = g.clock.Now().Add(d)
+		g.pause.timer = g.clock.NewTimer(d)
+	}
+}
+
+// broadcastPauseState 向所有玩家广播当前的暂停状态
+func (g *Game) broadcastPauseState(reason string) {
+	state := &pb.S2C_PauseState{
+		Status:      g.pause.status,
+		RequestedBy: g.pause.requestedBy,
+		FrameNumber: g.frameNumber,
+		Reason:      reason,
+	}
+	for id := range g.pause.votes {
+		state.Votes = append(state.Votes, id)
+	}
+	sort.Strings(state.Votes)
+	if !g.pause.deadline.IsZero() {
+		state.Deadline = g.pause.deadline.UnixMilli()
+	}
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CPauseState{S2CPauseState: state},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- msg:
+		default:
+			metrics.SendDrops.WithLabelValues("pause_state").Inc()
+			g.log.Warn("Failed to send pause state", logging.KeyPlayer, p.playerID)
+		}
+	}
+}
+
+// handlePause 处理暂停请求、投票和恢复请求，并回复请求的玩家
+func (g *Game) handlePause(message *pb.C2S_Pause) {
+	playerID := message.GetPlayerId()
+	player, ok := g.players[playerID]
+	if !ok {
+		g.log.Warn("Player not found when handling pause", logging.KeyPlayer, playerID)
+		return
+	}
+	var err error
+	switch message.GetAction() {
+	case pb.PauseAction_PAUSE_REQUEST:
+		err = g.requestPause(player)
+	case pb.PauseAction_PAUSE_RESUME:
+		err = g.requestResume(player)
+	case pb.PauseAction_PAUSE_REJECT:
+		err = g.rejectPause(player)
+	default:
+		err = fmt.Errorf("unknown pause action %d", message.GetAction())
+	}
+	reply := &pb.S2C_Pause{}
+	if err != nil {
+		g.log.Info("Pause request refused", logging.KeyPlayer, playerID,
+			"action", message.GetAction().String(), logging.KeyError, err)
+		reply.Error = true
+		reply.ErrorMsg = err.Error()
+	}
+	select {
+	case player.conn.SendChan() <- &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CPause{S2CPause: reply},
+	}:
+	default:
+		metrics.SendDrops.WithLabelValues("pause").Inc()
+	}
+}
+
+// requestPause 房主直接暂停，全体同意时发起投票，投票中的请求表示同意
+func (g *Game) requestPause(p *GamePlayer) error {
+	vote := g.pause.policy.GetVote()
+	switch {
+	case vote == pb.PauseVote_PAUSE_DISABLED:
+		return errors.New("pausing is disabled in this room")
+	case g.status != PlayingGame:
+		return errors.New("game is not running")
+	case p.ended:
+		return errors.New("player has ended the game")
+	case vote == pb.PauseVote_PAUSE_HOST_ONLY && p.playerID != g.pause.host:
+		return errors.New("only the host can pause")
+	}
+	if g.pause.status == pb.PauseStatus_PAUSE_VOTING {
+		g.pause.votes[p.playerID] = true
+		if !g.checkPauseVote() {
+			g.broadcastPauseState("")
+		}
+		return nil
+	}
+	if limit := g.pause.policy.GetMaxPauses(); limit > 0 && g.pause.used[p.playerID] >= limit {
+		return fmt.Errorf("no pauses left, each player can pause %d times", limit)
+	}
+
+	g.pause.requestedBy = p.playerID
+	if vote == pb.PauseVote_PAUSE_HOST_ONLY {
+		g.startPause()
+		return nil
+	}
+	g.pause.votes = map[string]bool{p.playerID: true}
+	g.setPauseStatus(pb.PauseStatus_PAUSE_VOTING, pauseVoteTimeout)
+	g.log.Info("Pause vote started", logging.KeyPlayer, p.playerID)
+	if !g.checkPauseVote() {
+		g.broadcastPauseState("")
+	}
+	return nil
+}
+
+// checkPauseVote 所有未结束的玩家都同意时开始暂停，机器人代打的玩家不参与投票
+func (g *Game) checkPauseVote() bool {
+	if g.pause.status != pb.PauseStatus_PAUSE_VOTING {
+		return false
+	}
+	for id, p := range g.players {
+		if !p.ended && !p.bot && !g.pause.votes[id] {
+			return false
+		}
+	}
+	g.startPause()
+	return true
+}
+
+// cancelPauseVote 取消正在进行的投票，游戏继续
+func (g *Game) cancelPauseVote(reason string) {
+	g.log.Info("Pause vote canceled", logging.KeyPlayer, g.pause.requestedBy, "reason", reason)
+	g.setPauseStatus(pb.PauseStatus_PAUSE_NONE, 0)
+	g.pause.votes = nil
+	g.broadcastPauseState(reason)
+	g.pause.requestedBy = ""
+}
+
+// rejectPause 拒绝正在进行的投票
+func (g *Game) rejectPause(p *GamePlayer) error {
+	if g.pause.status != pb.PauseStatus_PAUSE_VOTING {
+		return errors.New("no pause vote in progress")
+	}
+	g.cancelPauseVote(fmt.Sprintf("rejected by %s", p.playerID))
+	return nil
+}
+
+// startPause 停止帧计时，到达暂停的最长时间后自动恢复
+func (g *Game) startPause() {
+	g.ticker.Stop()
+	g.status = PausedGame
+	g.pause.used[g.pause.requestedBy]++
+	g.pause.votes = nil
+	g.pause.pausedAt = g.clock.Now()
+	g.setPauseStatus(pb.PauseStatus_PAUSE_PAUSED, g.maxPause())
+	g.log.Info("Game paused", logging.KeyPlayer, g.pause.requestedBy, "frame", g.frameNumber)
+	g.broadcastPauseState("")
+}
+
+// requestResume 开始恢复倒计时，HOST_ONLY时只有房主可以恢复
+func (g *Game) requestResume(p *GamePlayer) error {
+	if g.pause.status != pb.PauseStatus_PAUSE_PAUSED {
+		return errors.New("game is not paused")
+	}
+	if g.pause.policy.GetVote() == pb.PauseVote_PAUSE_HOST_ONLY && p.playerID != g.pause.host {
+		return errors.New("only the host can resume")
+	}
+	g.startResume(fmt.Sprintf("resumed by %s", p.playerID))
+	return nil
+}
+
+// startResume 广播恢复的时间，倒计时结束后继续帧同步
+func (g *Game) startResume(reason string) {
+	g.setPauseStatus(pb.PauseStatus_PAUSE_RESUMING, resumeCountdown)
+	g.broadcastPauseState(reason)
+}
+
+// resumePlaying 重新开始帧计时，并在回放中记录本次暂停
+func (g *Game) resumePlaying() {
+	paused := g.clock.Now().Sub(g.pause.pausedAt)
+	g.replay.recordEvent(ReplayEvent{
+		Frame:     g.frameNumber,
+		Type:      ReplayEventPause,
+		FromFrame: g.frameNumber,
+		ToFrame:   g.frameNumber,
+		Players:   []string{g.pause.requestedBy},
+		Detail:    fmt.Sprintf("paused for %s", paused.Round(time.Millisecond)),
+	})
+	g.setPauseStatus(pb.PauseStatus_PAUSE_NONE, 0)
+	// 先启动计时器再通知客户端，暂停的时间不计入帧间隔的抖动
+	g.status = PlayingGame
+	g.lastTick = time.Time{}
+	g.ticker = g.clock.NewTicker(g.interval)
+	g.log.Info("Game resumed", "frame", g.frameNumber, "paused", paused)
+	g.broadcastPauseState("")
+	g.pause.requestedBy = ""
+}
+
+// onPauseTimer 当前状态到达截止时间
+func (g *Game) onPauseTimer() {
+	g.pause.timer = nil
+	switch g.pause.status {
+	case pb.PauseStatus_PAUSE_VOTING:
+		g.cancelPauseVote("vote timed out")
+	case pb.PauseStatus_PAUSE_PAUSED:
+		g.startResume("pause time limit reached")
+	case pb.PauseStatus_PAUSE_RESUMING:
+		g.resumePlaying()
+	}
+}
@@ -0,0 +1,158 @@
+package game
+
+import (
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"testing"
+	"time"
+)
+
+func isPause(m *pb.MessageWrapper) bool { return m.GetS2CPause() != nil }
+
+// isPauseStatus 匹配指定状态的S2C_PauseState
+func isPauseStatus(status pb.PauseStatus) func(*pb.MessageWrapper) bool {
+	return func(m *pb.MessageWrapper) bool {
+		state := m.GetS2CPauseState()
+		return state != nil && state.GetStatus() == status
+	}
+}
+
+// startPausableGame 房主设置暂停规则后开始使用手动时钟的对战
+func startPausableGame(t *testing.T, policy *pb.PausePolicy, settings Settings, playerIDs ...string) (*nettest.ManualClock, []*nettest.Client) {
+	t.Helper()
+	clock, m := newClockedRoomManager(t, settings)
+
+	clients := make([]*nettest.Client, len(playerIDs))
+	for i, id := range playerIDs {
+		clients[i] = nettest.NewClient(id, m)
+	}
+	roomID := createModeRoom(t, clients[0], ModeVersus)
+	for _, c := range clients[1:] {
+		enterRoom(t, c, roomID)
+	}
+	// 只有房主可以设置
+	clients[1].SetPausePolicy(roomID, policy)
+	if reply := mustWait(t, clients[1], func(m *pb.MessageWrapper) bool { return m.GetS2CSetPausePolicy() != nil }); !reply.GetS2CSetPausePolicy().GetError() {
+		t.Fatal("non-host set the pause policy")
+	}
+	clients[0].SetPausePolicy(roomID, policy)
+	if reply := mustWait(t, clients[0], func(m *pb.MessageWrapper) bool { return m.GetS2CSetPausePolicy() != nil }); reply.GetS2CSetPausePolicy().GetError() {
+		t.Fatalf("set pause policy failed: %s", reply.GetS2CSetPausePolicy().GetErrorMsg())
+	}
+	info := mustWait(t, clients[1], isRoomInfoChanged).GetS2CRoomInfoChanged()
+	if info.GetPausePolicy().GetVote() != policy.GetVote() {
+		t.Fatalf("room info has pause policy %v", info.GetPausePolicy())
+	}
+
+	clients[0].StartGame(roomID)
+	for _, c := range clients {
+		mustWait(t, c, isStartGame)
+	}
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+	return clock, clients
+}
+
+// mustRefuse 发送暂停操作并检查被拒绝
+func mustRefuse(t *testing.T, c *nettest.Client, action pb.PauseAction) {
+	t.Helper()
+	c.Pause(action)
+	if reply := mustWait(t, c, isPause).GetS2CPause(); !reply.GetError() {
+		t.Fatalf("%s %v was accepted", c.PlayerID, action)
+	}
+}
+
+func TestPauseVote(t *testing.T) {
+	policy := &pb.PausePolicy{Vote: pb.PauseVote_PAUSE_UNANIMOUS, MaxPauses: 1}
+	clock, clients := startPausableGame(t, policy, Settings{MaxPause: time.Minute}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Pause(pb.PauseAction_PAUSE_REQUEST)
+	for _, c := range clients {
+		state := mustWait(t, c, isPauseStatus(pb.PauseStatus_PAUSE_VOTING)).GetS2CPauseState()
+		if state.GetRequestedBy() != "p1" || len(state.GetVotes()) != 1 || state.GetDeadline() != pauseVoteTimeout.Milliseconds() {
+			t.Fatalf("got vote %v", state)
+		}
+	}
+	clients[1].Pause(pb.PauseAction_PAUSE_REQUEST)
+	for _, c := range clients {
+		state := mustWait(t, c, isPauseStatus(pb.PauseStatus_PAUSE_PAUSED)).GetS2CPauseState()
+		if state.GetDeadline() != time.Minute.Milliseconds() {
+			t.Fatalf("got pause %v, want the server limit as deadline", state)
+		}
+	}
+
+	// 暂停时帧号不再增加
+	frame := game.Info().FrameNumber
+	clock.Advance(10 * FrameInterval)
+	if info := game.Info(); info.Status != PausedGame || info.FrameNumber != frame {
+		t.Fatalf("paused game is %s at frame %d, want frame %d", info.Status, info.FrameNumber, frame)
+	}
+
+	clients[1].Pause(pb.PauseAction_PAUSE_RESUME)
+	for _, c := range clients {
+		state := mustWait(t, c, isPauseStatus(pb.PauseStatus_PAUSE_RESUMING)).GetS2CPauseState()
+		if state.GetReason() != "resumed by p2" || state.GetFrameNumber() != frame {
+			t.Fatalf("got resume %v", state)
+		}
+	}
+	clock.Advance(resumeCountdown)
+	for _, c := range clients {
+		mustWait(t, c, isPauseStatus(pb.PauseStatus_PAUSE_NONE))
+	}
+	clock.BlockUntil(1)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+
+	// 每个玩家只能暂停一次，投票可以被拒绝
+	mustRefuse(t, clients[0], pb.PauseAction_PAUSE_REQUEST)
+	clients[1].Pause(pb.PauseAction_PAUSE_REQUEST)
+	mustWait(t, clients[0], isPauseStatus(pb.PauseStatus_PAUSE_VOTING))
+	clients[0].Pause(pb.PauseAction_PAUSE_REJECT)
+	state := mustWait(t, clients[1], isPauseStatus(pb.PauseStatus_PAUSE_NONE)).GetS2CPauseState()
+	if state.GetReason() != "rejected by p1" {
+		t.Fatalf("got %v, want the vote rejected", state)
+	}
+}
+
+func TestPauseHostOnly(t *testing.T) {
+	policy := &pb.PausePolicy{Vote: pb.PauseVote_PAUSE_HOST_ONLY, MaxDurationMs: 1000}
+	clock, clients := startPausableGame(t, policy, Settings{MaxPause: time.Minute}, "p1", "p2")
+
+	mustRefuse(t, clients[1], pb.PauseAction_PAUSE_REQUEST)
+	clients[0].Pause(pb.PauseAction_PAUSE_REQUEST)
+	mustWait(t, clients[1], isPauseStatus(pb.PauseStatus_PAUSE_PAUSED))
+	mustRefuse(t, clients[1], pb.PauseAction_PAUSE_RESUME)
+
+	// 到达房间规则的最长时间后自动恢复
+	clock.Advance(time.Second)
+	state := mustWait(t, clients[1], isPauseStatus(pb.PauseStatus_PAUSE_RESUMING)).GetS2CPauseState()
+	if state.GetReason() != "pause time limit reached" || state.GetDeadline() != (time.Second+resumeCountdown).Milliseconds() {
+		t.Fatalf("got %v", state)
+	}
+	clock.Advance(resumeCountdown)
+	mustWait(t, clients[1], isPauseStatus(pb.PauseStatus_PAUSE_NONE))
+}
+
+func TestPausePolicyLockedDuringGame(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := startModeGameOn(t, m, ModeVersus, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	isSetPolicy := func(m *pb.MessageWrapper) bool { return m.GetS2CSetPausePolicy() != nil }
+	policy := &pb.PausePolicy{Vote: pb.PauseVote_PAUSE_HOST_ONLY}
+
+	// 房主从新的连接修改规则也不影响进行中的游戏
+	host := nettest.NewClient("p1", m)
+	host.SetPausePolicy(game.roomID, policy)
+	if reply := mustWait(t, host, isSetPolicy).GetS2CSetPausePolicy(); reply.GetErrorMsg() != "Room is already in game" {
+		t.Fatalf("set pause policy during the game got %v, want already in game", reply)
+	}
+
+	game.Stop()
+	<-game.Done()
+	mustWait(t, clients[0], isRoomInfoChanged)
+	clients[0].SetPausePolicy(game.roomID, policy)
+	if reply := mustWait(t, clients[0], isSetPolicy).GetS2CSetPausePolicy(); reply.GetError() {
+		t.Fatalf("set pause policy after the game failed: %s", reply.GetErrorMsg())
+	}
+}
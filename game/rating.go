@@ -0,0 +1,100 @@
+package game
+
+import (
+	"TetrisSvr/history"
+	"math"
+	"sort"
+	"sync"
+)
+
+// DefaultRating 没有比赛记录的玩家的团队积分
+const DefaultRating = 1500
+
+// ratingK 每场比赛积分变化的上限
+const ratingK = 32
+
+// Ratings 玩家的团队积分，可以在任意协程中使用
+// 所有积分读入内存，每次变化先写入store，与比赛记录保存在一起
+type Ratings struct {
+	mu      sync.Mutex
+	store   history.Store
+	ratings map[string]int32
+}
+
+// NewRatings 从store读入所有玩家的积分
+func NewRatings(store history.Store) (*Ratings, error) {
+	ratings, err := store.Ratings()
+	if err != nil {
+		return nil, err
+	}
+	return &Ratings{store: store, ratings: ratings}, nil
+}
+
+// Get 返回玩家的积分
+func (r *Ratings) Get(playerID string) int32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.get(playerID)
+}
+
+func (r *Ratings) get(playerID string) int32 {
+	if rating, ok := r.ratings[playerID]; ok {
+		return rating
+	}
+	return DefaultRating
+}
+
+// applyTeams 按队伍名次用Elo更新积分，队伍的积分为队员的平均值，队员得到相同的变化
+// members为队伍到队员的映射，places中名次小的获胜，返回每个队伍的积分变化
+// 写入store失败时积分不变并返回错误
+func (r *Ratings) applyTeams(members map[int32][]string, places map[int32]int32) (map[int32]int32, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	teams := make([]int32, 0, len(members))
+	average := make(map[int32]float64, len(members))
+	for team, ids := range members {
+		teams = append(teams, team)
+		sum := 0.0
+		for _, id := range ids {
+			sum += float64(r.get(id))
+		}
+		average[team] = sum / float64(len(ids))
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i] < teams[j] })
+
+	changes := make(map[int32]int32, len(teams))
+	for _, a := range teams {
+		delta := 0.0
+		for _, b := range teams {
+			if a == b {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (average[b]-average[a])/400))
+			score := 0.5
+			if places[a] < places[b] {
+				score = 1
+			} else if places[a] > places[b] {
+				score = 0
+			}
+			delta += score - expected
+		}
+		if len(teams) > 1 {
+			delta /= float64(len(teams) - 1)
+		}
+		changes[a] = int32(math.Round(ratingK * delta))
+	}
+	updated := make(map[string]int32)
+	for team, ids := range members {
+		for _, id := range ids {
+			updated[id] = r.get(id) + changes[team]
+		}
+	}
+	if err := r.store.SetRatings(updated); err != nil {
+		return nil, err
+	}
+	for id, rating := range updated {
+		r.ratings[id] = rating
+	}
+	return changes, nil
+}
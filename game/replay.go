@@ -0,0 +1,193 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 回放中的事件类型
+const (
+	ReplayEventDesync = "desync"
+	ReplayEventCheat  = "cheat"
+	// ReplayEventElimination 大逃杀中的淘汰，Players为被淘汰的玩家和获得KO的玩家
+	ReplayEventElimination = "elimination"
+	// ReplayEventPause 游戏在Frame帧之前暂停，Players为请求暂停的玩家，Detail为暂停的时长
+	ReplayEventPause = "pause"
+	// ReplayEventDisconnect 玩家从FromFrame断线到ToFrame重连、弃权或由机器人接管，Detail为结果
+	ReplayEventDisconnect = "disconnect"
+)
+
+// Replay 一局游戏的记录
+// 包括种子和规则、每帧最终发出的输入以及游戏中发生的事件，
+// 用相同的种子和规则按帧重放输入即可复现整局游戏
+type Replay struct {
+	GameID          string        `json:"game_id"`
+	RoomID          string        `json:"room_id"`
+	Players         []string      `json:"players"`
+	Seed            int64         `json:"seed"`
+	Mode            string        `json:"mode"`
+	RulesVersion    int32         `json:"rules_version"`
+	Rules           MatchRules    `json:"rules"`
+	FrameIntervalMs float64       `json:"frame_interval_ms"`
+	InputDelay      int32         `json:"input_delay"`
+	StartedAt       time.Time     `json:"started_at"`
+	EndedAt         time.Time     `json:"ended_at"`
+	Frames          []ReplayFrame `json:"frames"`
+	Events          []ReplayEvent `json:"events"`
+	// Flags 被标记的玩家，Voided 比赛是否因此作废
+	Flags  []CheatFlag `json:"flags,omitempty"`
+	Voided bool        `json:"voided,omitempty"`
+	// Ranking 大逃杀中名次从高到低的玩家
+	Ranking []string `json:"ranking,omitempty"`
+	// Teams 团队模式中每个玩家的队伍，WinningTeam 获胜的队伍
+	Teams       map[string]int32 `json:"teams,omitempty"`
+	WinningTeam int32            `json:"winning_team,omitempty"`
+}
+
+// ReplayFrame 一帧中各玩家的输入和收到的垃圾，只记录有内容的帧
+type ReplayFrame struct {
+	Frame   int32                      `json:"frame"`
+	Inputs  map[string][][]byte        `json:"inputs,omitempty"`
+	Garbage map[string][]ReplayGarbage `json:"garbage,omitempty"`
+}
+
+// ReplayGarbage 玩家在这一帧开始时收到的攻击
+type ReplayGarbage struct {
+	From  string `json:"from"`
+	Lines int32  `json:"lines"`
+	Hole  int32  `json:"hole"`
+}
+
+// ReplayEvent 游戏中发生的事件
+// FromFrame和ToFrame为事件涉及的帧范围，Players为相关的玩家
+type ReplayEvent struct {
+	Frame     int32    `json:"frame"`
+	Type      string   `json:"type"`
+	FromFrame int32    `json:"from_frame"`
+	ToFrame   int32    `json:"to_frame"`
+	Players   []string `json:"players,omitempty"`
+	Detail    string   `json:"detail,omitempty"`
+}
+
+// newReplay 在所有玩家加载完成时创建回放
+func (g *Game) newReplay() *Replay {
+	r := &Replay{
+		GameID:          g.gameID,
+		RoomID:          g.roomID,
+		Seed:            g.setup.GetSeed(),
+		Mode:            g.setup.GetMode(),
+		RulesVersion:    g.setup.GetRulesVersion(),
+		Rules:           g.settings.Rules,
+		FrameIntervalMs: float64(g.interval) / float64(time.Millisecond),
+		InputDelay:      g.inputDelay,
+		StartedAt:       g.clock.Now(),
+	}
+	if g.team != nil {
+		r.Teams = g.team.members
+	}
+	for id := range g.players {
+		r.Players = append(r.Players, id)
+	}
+	sort.Strings(r.Players)
+	return r
+}
+
+// saveReplay 游戏结束时保存回放，没有开始的游戏没有回放
+func (g *Game) saveReplay() {
+	if g.replay == nil {
+		return
+	}
+	g.replay.EndedAt = g.clock.Now()
+	g.replay.Flags = g.flags
+	g.replay.Voided = g.voided
+	if g.royale != nil {
+		g.replay.Ranking = g.royale.ranking(g.players)
+	}
+	if g.settings.ReplayDir == "" {
+		return
+	}
+	path, err := g.replay.save(g.settings.ReplayDir)
+	if err != nil {
+		g.log.Error("Failed to save replay", logging.KeyError, err)
+		return
+	}
+	g.replayPath = path
+	g.log.Info("Replay saved", "path", path, "frames", g.frameNumber)
+}
+
+// recordFrame 记录已经发出的一帧，只在游戏协程中调用
+func (r *Replay) recordFrame(frame int32, players map[string]*GamePlayer) {
+	record := ReplayFrame{Frame: frame}
+	for id, p := range players {
+		data, ok := p.frames[frame]
+		if !ok {
+			continue
+		}
+		if len(data.Operations) > 0 {
+			if record.Inputs == nil {
+				record.Inputs = make(map[string][][]byte)
+			}
+			record.Inputs[id] = data.Operations
+		}
+		for _, garbage := range data.Garbage {
+			if record.Garbage == nil {
+				record.Garbage = make(map[string][]ReplayGarbage)
+			}
+			record.Garbage[id] = append(record.Garbage[id], ReplayGarbage{
+				From:  garbage.GetFrom(),
+				Lines: garbage.GetLines(),
+				Hole:  garbage.GetHole(),
+			})
+		}
+	}
+	if record.Inputs != nil || record.Garbage != nil {
+		r.Frames = append(r.Frames, record)
+	}
+}
+
+func (r *Replay) recordEvent(event ReplayEvent) {
+	sort.Strings(event.Players)
+	r.Events = append(r.Events, event)
+}
+
+// save 将回放写入dir/<游戏ID>.json，文件已经存在时返回错误，不覆盖之前的回放
+// 先写入临时文件再硬链接到目标路径，链接在目标存在时原子地失败，读取方不会看到写了一半的回放
+func (r *Replay) save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, r.GameID+".json")
+	tmp, err := os.CreateTemp(dir, r.GameID+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return "", fmt.Errorf("replay %s already exists", path)
+		}
+		return "", err
+	}
+	return path, nil
+}
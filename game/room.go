@@ -2,8 +2,11 @@ package game
 
 import (
 	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
 	"context"
 	"fmt"
+	"sort"
+	"time"
 )
 
 type IPlayer interface {
@@ -32,17 +35,54 @@ func NewPlayer(id string, conn network.IConn) IPlayer {
 	}
 }
 
+// IGame 一局正在进行的游戏
+type IGame interface {
+	network.IConnHandler
+	ID() string
+	// Done 在游戏结束后关闭
+	Done() <-chan struct{}
+	// Stop 强制结束游戏并通知所有玩家
+	Stop()
+	Info() GameInfo
+	Kick(playerID string) error
+	// Setup 本局所有玩家共用的种子和规则
+	Setup() *pb.MatchSetup
+	// Rejoin 断线的玩家用新的连接回到游戏
+	Rejoin(playerID string, conn network.IConn) error
+}
+
 type IRoom interface {
 	ID() string
 	Status() string
+	// Mode 创建房间时选择的游戏模式
+	Mode() string
+	// Host 房主，创建房间的玩家离开后由ID最小的玩家担任
+	Host() string
 	AddPlayer(playerID string, conn network.IConn) error
 	RemovePlayer(playerID string) error
 	Players() []IPlayer
-	Game(ctx context.Context, config *network.Config) network.IConnHandler
+	// SetTeam 团队模式中由房主分配队伍，team为0表示开始时自动分配
+	SetTeam(hostID, playerID string, team int32) error
+	// Teams 已经分配的队伍
+	Teams() map[string]int32
+	// SetPausePolicy 房主设置之后开始的游戏的暂停规则
+	SetPausePolicy(hostID string, policy *pb.PausePolicy) error
+	PausePolicy() *pb.PausePolicy
+	// SetDisconnectPolicy 房主设置之后开始的游戏的断线规则
+	SetDisconnectPolicy(hostID string, policy *pb.DisconnectPolicy) error
+	DisconnectPolicy() *pb.DisconnectPolicy
+	// SetConn 玩家重连后替换其连接
+	SetConn(playerID string, conn network.IConn) error
+	// Prepare 开始游戏前的检查，团队模式中为没有分配的玩家自动分队
+	Prepare() error
+	// Game 开始新的一局游戏，房间进入GameRoom状态，游戏结束时玩家的连接交还给lobby
+	Game(ctx context.Context, config *network.Config, settings Settings, services Services, lobby network.IConnHandler) IGame
+	// EndGame 游戏结束或取消后房间回到WaitingRoom状态
+	EndGame()
 }
 
 type IRoomCreator interface {
-	CreateRoom() (IRoom, error)
+	CreateRoom(mode string, settings Settings) (IRoom, error)
 }
 
 // 唯一ID房间创建器实现
@@ -50,19 +90,39 @@ type UniqueIDRoomCreator struct {
 	nextID uint64 // 原子计数器
 }
 
-func (c *UniqueIDRoomCreator) CreateRoom() (IRoom, error) {
+func (c *UniqueIDRoomCreator) CreateRoom(mode string, settings Settings) (IRoom, error) {
 	c.nextID++
+	maxPlayers := settings.MaxPlayers
+	switch {
+	case mode == ModeRoyale:
+		maxPlayers = settings.RoyaleMaxPlayers
+	case soloMode(mode):
+		maxPlayers = 1
+	}
 	return &Room{
-		id:      fmt.Sprintf("%d", c.nextID),
-		status:  WaitingRoom,
-		players: make(map[string]IPlayer),
+		id:         fmt.Sprintf("%d", c.nextID),
+		status:     WaitingRoom,
+		mode:       mode,
+		players:    make(map[string]IPlayer),
+		teams:      make(map[string]int32),
+		maxPlayers: maxPlayers,
 	}, nil
 }
 
 type Room struct {
 	id      string
 	status  string
+	mode    string
+	host    string
 	players map[string]IPlayer
+	// teams 团队模式中已经分配的队伍，从1开始
+	teams map[string]int32
+	// maxPlayers 最大人数，0表示不限制
+	maxPlayers int
+	// pausePolicy 房主设置的暂停规则，为nil时不能暂停
+	pausePolicy *pb.PausePolicy
+	// disconnectPolicy 房主设置的断线规则，为nil时使用服务器的等待时间后弃权
+	disconnectPolicy *pb.DisconnectPolicy
 }
 
 func (r *Room) ID() string {
@@ -73,11 +133,25 @@ func (r *Room) Status() string {
 	return r.status
 }
 
+func (r *Room) Mode() string {
+	return r.mode
+}
+
+func (r *Room) Host() string {
+	return r.host
+}
+
 func (r *Room) AddPlayer(playerID string, conn network.IConn) error {
 	if _, exists := r.players[playerID]; exists {
 		return fmt.Errorf("player %s already in room", playerID)
 	}
+	if r.maxPlayers > 0 && len(r.players) >= r.maxPlayers {
+		return fmt.Errorf("room %s is full", r.id)
+	}
 	r.players[playerID] = NewPlayer(playerID, conn)
+	if r.host == "" {
+		r.host = playerID
+	}
 	return nil
 }
 
@@ -86,6 +160,127 @@ func (r *Room) RemovePlayer(playerID string) error {
 		return fmt.Errorf("player %s not found", playerID)
 	}
 	delete(r.players, playerID)
+	delete(r.teams, playerID)
+	if r.host == playerID {
+		r.host = ""
+		for id := range r.players {
+			if r.host == "" || id < r.host {
+				r.host = id
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Room) SetConn(playerID string, conn network.IConn) error {
+	if _, exists := r.players[playerID]; !exists {
+		return fmt.Errorf("player %s not found", playerID)
+	}
+	r.players[playerID] = NewPlayer(playerID, conn)
+	return nil
+}
+
+func (r *Room) SetTeam(hostID, playerID string, team int32) error {
+	if r.mode != ModeTeams {
+		return fmt.Errorf("room %s is not a team room", r.id)
+	}
+	if hostID != r.host {
+		return fmt.Errorf("only the host can assign teams")
+	}
+	if _, exists := r.players[playerID]; !exists {
+		return fmt.Errorf("player %s not found", playerID)
+	}
+	if team < 0 || team > teamCount {
+		return fmt.Errorf("team %d must be between 1 and %d", team, teamCount)
+	}
+	if team == 0 {
+		delete(r.teams, playerID)
+	} else {
+		r.teams[playerID] = team
+	}
+	return nil
+}
+
+func (r *Room) Teams() map[string]int32 {
+	teams := make(map[string]int32, len(r.teams))
+	for id, team := range r.teams {
+		teams[id] = team
+	}
+	return teams
+}
+
+func (r *Room) SetPausePolicy(hostID string, policy *pb.PausePolicy) error {
+	if hostID != r.host {
+		return fmt.Errorf("only the host can set the pause policy")
+	}
+	if r.mode == ModeRoyale || soloMode(r.mode) {
+		return fmt.Errorf("pausing is not available in %s mode", r.mode)
+	}
+	if _, ok := pb.PauseVote_name[int32(policy.GetVote())]; !ok {
+		return fmt.Errorf("unknown pause vote %d", policy.GetVote())
+	}
+	if policy.GetMaxPauses() < 0 || policy.GetMaxDurationMs() < 0 {
+		return fmt.Errorf("max pauses and max duration must not be negative")
+	}
+	r.pausePolicy = policy
+	return nil
+}
+
+func (r *Room) PausePolicy() *pb.PausePolicy {
+	return r.pausePolicy
+}
+
+func (r *Room) SetDisconnectPolicy(hostID string, policy *pb.DisconnectPolicy) error {
+	if hostID != r.host {
+		return fmt.Errorf("only the host can set the disconnect policy")
+	}
+	if _, ok := pb.DisconnectAction_name[int32(policy.GetAction())]; !ok {
+		return fmt.Errorf("unknown disconnect action %d", policy.GetAction())
+	}
+	if policy.GetAction() == pb.DisconnectAction_DISCONNECT_BOT && soloMode(r.mode) {
+		return fmt.Errorf("bots are not available in %s mode", r.mode)
+	}
+	if policy.GetGraceMs() < 0 {
+		return fmt.Errorf("grace period must not be negative")
+	}
+	r.disconnectPolicy = policy
+	return nil
+}
+
+func (r *Room) DisconnectPolicy() *pb.DisconnectPolicy {
+	return r.disconnectPolicy
+}
+
+func (r *Room) Prepare() error {
+	if r.mode != ModeTeams {
+		return nil
+	}
+	// 没有分配的玩家按ID顺序加入人数最少的队伍
+	sizes := make([]int, teamCount+1)
+	var unassigned []string
+	for id := range r.players {
+		if team, ok := r.teams[id]; ok {
+			sizes[team]++
+		} else {
+			unassigned = append(unassigned, id)
+		}
+	}
+	sort.Strings(unassigned)
+	for _, id := range unassigned {
+		team := int32(1)
+		for t := int32(2); t <= teamCount; t++ {
+			if sizes[t] < sizes[team] {
+				team = t
+			}
+		}
+		r.teams[id] = team
+		sizes[team]++
+	}
+	for team := 1; team <= teamCount; team++ {
+		if sizes[team] == 0 {
+			return fmt.Errorf("team %d has no players", team)
+		}
+	}
 	return nil
 }
 
@@ -97,7 +292,19 @@ func (r *Room) Players() []IPlayer {
 	return players
 }
 
-func (r *Room) Game(ctx context.Context, config *network.Config) network.IConnHandler {
-	game := NewGame(ctx, r.id, config, r.players)
+func (r *Room) Game(ctx context.Context, config *network.Config, settings Settings, services Services, lobby network.IConnHandler) IGame {
+	r.status = GameRoom
+	game := NewGame(ctx, newGameID(time.Now()), r.id, r.mode, config, settings, r.players)
+	game.services = services
+	game.lobby = lobby
+	if r.mode == ModeTeams {
+		game.setTeams(r.Teams())
+	}
+	game.setPausePolicy(r.host, r.pausePolicy)
+	game.setDisconnectPolicy(r.disconnectPolicy)
 	return game
 }
+
+func (r *Room) EndGame() {
+	r.status = WaitingRoom
+}
@@ -1,42 +1,107 @@
 package game
 
 import (
+	"TetrisSvr/history"
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
 	"TetrisSvr/network"
 	pb "TetrisSvr/proto"
 	"context"
-
-	log "github.com/jeanphorn/log4go"
+	"errors"
+	"log/slog"
+	"time"
 )
 
 const WaitingRoom = "waiting_room"
 const GameRoom = "game_room"
 
 type RoomManager struct {
+	log         *slog.Logger
 	ctx         context.Context
 	cfg         *network.Config
 	creator     IRoomCreator
 	rooms       map[string]IRoom
 	player2room map[string]IRoom
+	games       map[string]IGame
+	services    Services
 	handleChan  chan *network.ConnMessage
+	controlChan chan func()
+
+	settings     Settings
+	shuttingDown bool
+}
+
+// Settings 运行时可以修改的房间和游戏参数，修改后只影响之后创建的房间和游戏
+type Settings struct {
+	// MaxPlayers 新房间的最大人数，0表示不限制
+	MaxPlayers int
+	// FrameInterval 新游戏的帧同步间隔，为0时使用FrameInterval
+	FrameInterval time.Duration
+	// MinInputDelay和MaxInputDelay 输入延迟帧数的范围，
+	// 游戏开始时根据玩家的RTT在此范围内选择输入延迟
+	MinInputDelay int32
+	MaxInputDelay int32
+	// HashInterval 客户端上报状态哈希的帧间隔，0表示不检查是否同步
+	HashInterval int32
+	// ReplayDir 游戏结束后保存回放的目录，为空时不保存
+	ReplayDir string
+	// CheatPolicy 玩家被标记后的处理：CheatPolicyWarn、CheatPolicyKick或CheatPolicyVoid
+	CheatPolicy string
+	// ValidateInput 按tetris.Op的编码校验输入：拒绝并标记无法解析的输入，
+	// 拒绝模拟中堆到顶之后的输入，检查MaxOpsPerFrame；关闭时操作按原样转发
+	// 开启表示所有客户端都实现了tetris.RulesVersion的规则，只有这时才使用服务器模拟的结果
+	ValidateInput bool
+	// MaxOpsPerFrame 一帧中最多的操作数，MaxPPS 持续的每秒方块数上限，0表示不检查，都只在开启ValidateInput时检查
+	MaxOpsPerFrame int
+	MaxPPS         float64
+	// Rules 随种子发给客户端的规则
+	Rules MatchRules
+	// RoyaleMaxPlayers 新的大逃杀房间的最大人数，0表示不限制
+	RoyaleMaxPlayers int
+	// RoyaleFullBoards 大逃杀中每个玩家接收完整帧的棋盘数（包括自己），其余棋盘只接收概要
+	RoyaleFullBoards int
+	// StatsInterval 每隔多少帧广播一次玩家统计，0表示只在结束消息中附带；统计来自模拟，只在开启ValidateInput时发送
+	StatsInterval int32
+	// MaxPause 每次暂停的最长时间，房间的暂停规则不能超过，0表示不限制
+	MaxPause time.Duration
+	// LoadTimeout 等待所有玩家加载完成的时长，0表示一直等待
+	// LoadTimeoutPolicy 超时后的处理：LoadTimeoutStart或LoadTimeoutAbort
+	LoadTimeout       time.Duration
+	LoadTimeoutPolicy string
+	// DisconnectGrace 玩家断线后等待重连的最长时间，房间的断线规则不能超过，0表示立即处理
+	DisconnectGrace time.Duration
 }
 
 func (m *RoomManager) HandleChan() chan<- *network.ConnMessage {
 	return m.handleChan
 }
 
-func (m *RoomManager) broadcastRoomInfoChanged(roomID string, playerID string) {
-	room := m.rooms[roomID]
+// roomInfoChanged 房间当前的玩家、模式和队伍
+func roomInfoChanged(room IRoom) *pb.S2C_RoomInfoChanged {
 	players := room.Players()
 	playerIDs := make([]string, len(players))
 	for i, p := range players {
 		playerIDs[i] = p.ID()
 	}
+	return &pb.S2C_RoomInfoChanged{
+		RoomId:           room.ID(),
+		PlayerIds:        playerIDs,
+		Mode:             room.Mode(),
+		Teams:            room.Teams(),
+		HostId:           room.Host(),
+		PausePolicy:      room.PausePolicy(),
+		DisconnectPolicy: room.DisconnectPolicy(),
+	}
+}
+
+// broadcastRoomInfoChanged 通知房间中除playerID之外的玩家，playerID为空时通知所有玩家
+func (m *RoomManager) broadcastRoomInfoChanged(roomID string, playerID string) {
+	room := m.rooms[roomID]
+	players := room.Players()
 	reply := &pb.MessageWrapper{
 		Msg: &pb.MessageWrapper_S2CRoomInfoChanged{
-			S2CRoomInfoChanged: &pb.S2C_RoomInfoChanged{
-				RoomId:    roomID,
-				PlayerIds: playerIDs,
-			},
+			S2CRoomInfoChanged: roomInfoChanged(room),
 		},
 	}
 	for _, p := range players {
@@ -48,11 +113,20 @@ func (m *RoomManager) broadcastRoomInfoChanged(roomID string, playerID string) {
 	}
 }
 
+// connLog 返回带有连接和房间信息的Logger
+func (m *RoomManager) connLog(conn network.IConn, roomID string) *slog.Logger {
+	log := m.log.With(conn.LogAttrs()...)
+	if roomID != "" {
+		log = log.With(logging.KeyRoom, roomID)
+	}
+	return log
+}
+
 func (m *RoomManager) handleEnterRoom(conn network.IConn, message *pb.C2S_EnterRoom) {
-	// log.Info("接收到消息: %s", message)
 	replyMsg := &pb.S2C_EnterRoom{}
 	roomID := message.GetRoomId()
 	playerID := message.GetPlayerId()
+	log := m.connLog(conn, roomID)
 
 	defer func() {
 		reply := &pb.MessageWrapper{
@@ -66,44 +140,40 @@ func (m *RoomManager) handleEnterRoom(conn network.IConn, message *pb.C2S_EnterR
 		}
 	}()
 
+	if m.shuttingDown {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Server is shutting down"
+		return
+	}
 	r, ok := m.rooms[roomID]
 	if !ok {
-		log.Error("Room not found: %s", roomID)
+		log.Warn("Room not found")
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Room not found"
 		return
 	}
 	if r.Status() == GameRoom {
-		log.Error("Room is already in game: %s", roomID)
+		log.Warn("Room is already in game")
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Room is already in game"
 		return
 	}
 	err := r.AddPlayer(playerID, conn)
 	if err != nil {
-		log.Error("Failed to add player to room: %v", err)
+		log.Warn("Failed to add player to room", logging.KeyError, err)
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Failed to add player to room"
 		return
 	}
 	m.player2room[playerID] = r
 	replyMsg.Error = false
-
-	players := r.Players()
-	playerIDs := make([]string, len(players))
-	for i, p := range players {
-		playerIDs[i] = p.ID()
-	}
-
-	replyMsg.Info = &pb.S2C_RoomInfoChanged{
-		RoomId:    roomID,
-		PlayerIds: playerIDs,
-	}
+	log.Info("Player entered room")
+	replyMsg.Info = roomInfoChanged(r)
 }
 
 func (m *RoomManager) handleCreateRoom(conn network.IConn, message *pb.C2S_CreateRoom) {
-	log.Info("接收到消息: %s", message)
 	replyMsg := &pb.S2C_CreateRoom{}
+	log := m.connLog(conn, "")
 
 	defer func() {
 		reply := &pb.MessageWrapper{
@@ -111,47 +181,67 @@ func (m *RoomManager) handleCreateRoom(conn network.IConn, message *pb.C2S_Creat
 				S2CCreateRoom: replyMsg,
 			},
 		}
-		log.Info("Sending reply: %v", reply)
 		conn.SendChan() <- reply
 	}()
 
+	if m.shuttingDown {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Server is shutting down"
+		return
+	}
 	playerID := message.GetPlayerId()
 	_, ok := m.player2room[playerID]
 	if ok {
-		log.Error("Player already in a room: %s", playerID)
+		log.Warn("Player already in a room")
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Player already in a room"
 		return
 	}
+	mode := message.GetMode()
+	if mode == "" {
+		mode = ModeVersus
+	}
+	if !validMode(mode) {
+		log.Warn("Unknown game mode", "mode", mode)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Unknown game mode"
+		return
+	}
+	if simulatedMode(mode) && !m.settings.ValidateInput {
+		log.Warn("Game mode requires input validation", "mode", mode)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Game mode requires input validation"
+		return
+	}
 
-	r, err := m.creator.CreateRoom()
+	r, err := m.creator.CreateRoom(mode, m.settings)
 	if err != nil {
-		log.Error("Failed to create room: %v", err)
+		log.Error("Failed to create room", logging.KeyError, err)
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Failed to create room"
 		return
 	}
 	m.rooms[r.ID()] = r
+	metrics.RoomsActive.Set(float64(len(m.rooms)))
+	log = log.With(logging.KeyRoom, r.ID())
 	err = r.AddPlayer(playerID, conn)
 	if err != nil {
-		log.Error("Failed to add player to room: %v", err)
+		log.Error("Failed to add player to room", logging.KeyError, err)
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Failed to add player to room"
 		return
 	}
 	m.player2room[playerID] = r
 
-	replyMsg.Info = &pb.S2C_RoomInfoChanged{
-		RoomId:    r.ID(),
-		PlayerIds: []string{playerID},
-	}
+	replyMsg.Info = roomInfoChanged(r)
 	replyMsg.Error = false
+	log.Info("Room created", "mode", mode)
 }
 
 func (m *RoomManager) handleStartGame(conn network.IConn, message *pb.C2S_StartGame) {
-	log.Info("接收到消息: %s", message)
 	replyMsg := &pb.S2C_StartGame{}
 	roomID := message.GetRoomId()
+	log := m.connLog(conn, roomID)
 
 	defer func() {
 		reply := &pb.MessageWrapper{
@@ -162,8 +252,11 @@ func (m *RoomManager) handleStartGame(conn network.IConn, message *pb.C2S_StartG
 		// Send to all players on success, only requester on error
 		if !replyMsg.Error {
 			room := m.rooms[roomID]
-			handler := room.Game(m.ctx, m.cfg)
+			handler := room.Game(m.ctx, m.cfg, m.settings, m.services, m)
+			replyMsg.Setup = handler.Setup()
 			handler.Start()
+			m.watchGame(roomID, handler)
+			log.Info("Game started", logging.KeyGame, handler.ID())
 			for _, p := range room.Players() {
 				p.Conn().SetHandler(handler)
 				p.Conn().SendChan() <- reply
@@ -173,22 +266,530 @@ func (m *RoomManager) handleStartGame(conn network.IConn, message *pb.C2S_StartG
 		}
 	}()
 
-	_, ok := m.rooms[roomID]
+	if m.shuttingDown {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Server is shutting down"
+		return
+	}
+	r, ok := m.rooms[roomID]
 	if !ok {
-		log.Error("Room not found: %s", roomID)
+		log.Warn("Room not found")
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Room not found"
 		return
 	}
+	if message.GetPlayerId() != r.Host() {
+		log.Warn("Only the host can start the game", logging.KeyPlayer, message.GetPlayerId())
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Only the host can start the game"
+		return
+	}
+	if r.Status() == GameRoom {
+		log.Warn("Room is already in game")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is already in game"
+		return
+	}
+	// 房间创建之后设置可能已经修改
+	if simulatedMode(r.Mode()) && !m.settings.ValidateInput {
+		log.Warn("Game mode requires input validation", "mode", r.Mode())
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Game mode requires input validation"
+		return
+	}
+	if r.DisconnectPolicy().GetAction() == pb.DisconnectAction_DISCONNECT_BOT && !m.settings.ValidateInput {
+		log.Warn("Bots require input validation")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Bots require input validation"
+		return
+	}
+	if err := r.Prepare(); err != nil {
+		log.Warn("Room is not ready to start", logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is not ready to start: " + err.Error()
+		return
+	}
 
 	replyMsg.Error = false
 }
 
+// handleSetTeam 房主为玩家分配队伍，成功后通知房间中的所有玩家
+func (m *RoomManager) handleSetTeam(conn network.IConn, message *pb.C2S_SetTeam) {
+	replyMsg := &pb.S2C_SetTeam{}
+	roomID := message.GetRoomId()
+	log := m.connLog(conn, roomID)
+
+	defer func() {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CSetTeam{
+				S2CSetTeam: replyMsg,
+			},
+		}
+		if !replyMsg.Error {
+			m.broadcastRoomInfoChanged(roomID, "")
+		}
+	}()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		log.Warn("Room not found")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room not found"
+		return
+	}
+	if r.Status() == GameRoom {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is already in game"
+		return
+	}
+	if err := r.SetTeam(message.GetPlayerId(), message.GetTargetId(), message.GetTeam()); err != nil {
+		log.Warn("Failed to set team", logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = err.Error()
+		return
+	}
+	log.Info("Team assigned", logging.KeyPlayer, message.GetTargetId(), "team", message.GetTeam())
+}
+
+// handleSetPausePolicy 房主设置房间的暂停规则，成功后通知房间中的所有玩家
+func (m *RoomManager) handleSetPausePolicy(conn network.IConn, message *pb.C2S_SetPausePolicy) {
+	replyMsg := &pb.S2C_SetPausePolicy{}
+	roomID := message.GetRoomId()
+	log := m.connLog(conn, roomID)
+
+	defer func() {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CSetPausePolicy{
+				S2CSetPausePolicy: replyMsg,
+			},
+		}
+		if !replyMsg.Error {
+			m.broadcastRoomInfoChanged(roomID, "")
+		}
+	}()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		log.Warn("Room not found")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room not found"
+		return
+	}
+	if r.Status() == GameRoom {
+		log.Warn("Room is already in game")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is already in game"
+		return
+	}
+	if err := r.SetPausePolicy(message.GetPlayerId(), message.GetPolicy()); err != nil {
+		log.Warn("Failed to set pause policy", logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = err.Error()
+		return
+	}
+	log.Info("Pause policy set", "vote", message.GetPolicy().GetVote().String(),
+		"max_pauses", message.GetPolicy().GetMaxPauses(), "max_duration_ms", message.GetPolicy().GetMaxDurationMs())
+}
+
+// handleSetDisconnectPolicy 房主设置房间的断线规则，成功后通知房间中的所有玩家
+func (m *RoomManager) handleSetDisconnectPolicy(conn network.IConn, message *pb.C2S_SetDisconnectPolicy) {
+	replyMsg := &pb.S2C_SetDisconnectPolicy{}
+	roomID := message.GetRoomId()
+	log := m.connLog(conn, roomID)
+
+	defer func() {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CSetDisconnectPolicy{
+				S2CSetDisconnectPolicy: replyMsg,
+			},
+		}
+		if !replyMsg.Error {
+			m.broadcastRoomInfoChanged(roomID, "")
+		}
+	}()
+
+	r, ok := m.rooms[roomID]
+	if !ok {
+		log.Warn("Room not found")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room not found"
+		return
+	}
+	if r.Status() == GameRoom {
+		log.Warn("Room is already in game")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is already in game"
+		return
+	}
+	// 机器人按服务器的模拟放置方块
+	if message.GetPolicy().GetAction() == pb.DisconnectAction_DISCONNECT_BOT && !m.settings.ValidateInput {
+		log.Warn("Bots require input validation")
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Bots require input validation"
+		return
+	}
+	if err := r.SetDisconnectPolicy(message.GetPlayerId(), message.GetPolicy()); err != nil {
+		log.Warn("Failed to set disconnect policy", logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = err.Error()
+		return
+	}
+	log.Info("Disconnect policy set", "action", message.GetPolicy().GetAction().String(),
+		"grace_ms", message.GetPolicy().GetGraceMs())
+}
+
+// handleRejoin 断线的玩家用新的连接回到房间中进行的游戏
+// 成功时由游戏回复S2C_Rejoin，保证回复在重新发送的帧之前到达
+func (m *RoomManager) handleRejoin(conn network.IConn, message *pb.C2S_Rejoin) {
+	replyMsg := &pb.S2C_Rejoin{}
+	roomID := message.GetRoomId()
+	playerID := message.GetPlayerId()
+	log := m.connLog(conn, roomID)
+
+	defer func() {
+		if replyMsg.Error {
+			conn.SendChan() <- &pb.MessageWrapper{
+				Msg: &pb.MessageWrapper_S2CRejoin{
+					S2CRejoin: replyMsg,
+				},
+			}
+		}
+	}()
+
+	r, ok := m.rooms[roomID]
+	if !ok || m.player2room[playerID] != r {
+		log.Warn("Player is not in the room", logging.KeyPlayer, playerID)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Player is not in the room"
+		return
+	}
+	game, ok := m.games[roomID]
+	if !ok {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Room is not in game"
+		return
+	}
+	if err := game.Rejoin(playerID, conn); err != nil {
+		log.Warn("Failed to rejoin game", logging.KeyPlayer, playerID, logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Failed to rejoin game: " + err.Error()
+		return
+	}
+	r.SetConn(playerID, conn)
+	log.Info("Player rejoined game", logging.KeyPlayer, playerID, logging.KeyGame, game.ID())
+}
+
+// maxLeaderboardEntries 一次查询最多返回的成绩数
+const maxLeaderboardEntries = 100
+
+// handleLeaderboard 查询单人模式排行榜从offset开始的top名和玩家前后各around名
+// season为0时查询当前赛季，回复中带有所有赛季供客户端查询归档的赛季
+func (m *RoomManager) handleLeaderboard(conn network.IConn, message *pb.C2S_Leaderboard) {
+	mode := message.GetMode()
+	replyMsg := &pb.S2C_Leaderboard{Mode: mode}
+	defer func() {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CLeaderboard{
+				S2CLeaderboard: replyMsg,
+			},
+		}
+	}()
+
+	if _, ok := LeaderboardOrders[mode]; !ok {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Unknown leaderboard mode"
+		return
+	}
+	store := m.services.Leaderboard
+	query := leaderboard.Query{
+		Mode:   mode,
+		Season: int(message.GetSeason()),
+		Offset: int(message.GetOffset()),
+		Limit:  min(int(message.GetTop()), maxLeaderboardEntries),
+	}
+	top, err := store.Top(query)
+	var around leaderboard.Page
+	if err == nil && message.GetAround() > 0 {
+		query.Limit = min(int(message.GetAround()), maxLeaderboardEntries/2)
+		around, err = store.Around(query, message.GetPlayerId())
+	}
+	var seasons []leaderboard.Season
+	if err == nil {
+		seasons, err = store.Seasons()
+	}
+	if errors.Is(err, leaderboard.ErrSeasonNotFound) {
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Season not found"
+		return
+	}
+	if err != nil {
+		m.connLog(conn, "").Error("Failed to query leaderboard", logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Failed to query leaderboard"
+		return
+	}
+	replyMsg.Season = leaderboardSeason(top.Season)
+	replyMsg.Total = int32(top.Total)
+	replyMsg.Top = leaderboardEntries(top.Entries)
+	replyMsg.Around = leaderboardEntries(around.Entries)
+	for _, season := range seasons {
+		replyMsg.Seasons = append(replyMsg.Seasons, leaderboardSeason(season))
+	}
+}
+
+func leaderboardSeason(season leaderboard.Season) *pb.LeaderboardSeason {
+	s := &pb.LeaderboardSeason{
+		Id:       int32(season.ID),
+		Start:    season.Start.UnixMilli(),
+		Archived: season.Archived,
+	}
+	if !season.End.IsZero() {
+		s.End = season.End.UnixMilli()
+	}
+	return s
+}
+
+func leaderboardEntries(ranked []leaderboard.Ranked) []*pb.LeaderboardEntry {
+	entries := make([]*pb.LeaderboardEntry, 0, len(ranked))
+	for _, r := range ranked {
+		entries = append(entries, &pb.LeaderboardEntry{
+			Rank:        int32(r.Rank),
+			PlayerId:    r.PlayerID,
+			Value:       r.Value,
+			SubmittedAt: r.SubmittedAt.UnixMilli(),
+		})
+	}
+	return entries
+}
+
+// maxProfileMatches 个人资料中一次最多返回的比赛数
+const maxProfileMatches = 50
+
+// handlePlayerProfile 查询玩家的累计统计、团队积分和从新到旧的比赛记录，target_id为空时查询自己
+func (m *RoomManager) handlePlayerProfile(conn network.IConn, message *pb.C2S_PlayerProfile) {
+	replyMsg := &pb.S2C_PlayerProfile{}
+	defer func() {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CPlayerProfile{
+				S2CPlayerProfile: replyMsg,
+			},
+		}
+	}()
+
+	playerID := message.GetTargetId()
+	if playerID == "" {
+		playerID = message.GetPlayerId()
+	}
+	stats, err := m.services.History.Stats(playerID)
+	var matches []history.Match
+	var total int
+	if err == nil {
+		matches, total, err = m.services.History.Matches(playerID, int(message.GetOffset()),
+			min(int(message.GetLimit()), maxProfileMatches))
+	}
+	if err != nil {
+		m.connLog(conn, "").Error("Failed to query player profile", logging.KeyPlayer, playerID, logging.KeyError, err)
+		replyMsg.Error = true
+		replyMsg.ErrorMsg = "Failed to query player profile"
+		return
+	}
+	replyMsg.Stats = &pb.PlayerStats{
+		PlayerId:  playerID,
+		Games:     int32(stats.Games),
+		Wins:      int32(stats.Wins),
+		Pieces:    stats.Pieces,
+		Lines:     stats.Lines,
+		LinesSent: stats.LinesSent,
+		PlayMs:    stats.PlayMs,
+		Apm:       stats.APM(),
+		Pps:       stats.PPS(),
+		Rating:    m.services.Ratings.Get(playerID),
+	}
+	if !stats.LastPlayed.IsZero() {
+		replyMsg.Stats.LastPlayed = stats.LastPlayed.UnixMilli()
+	}
+	replyMsg.Total = int32(total)
+	for _, match := range matches {
+		record := &pb.MatchRecord{
+			GameId:     match.GameID,
+			RoomId:     match.RoomID,
+			Mode:       match.Mode,
+			StartedAt:  match.StartedAt.UnixMilli(),
+			EndedAt:    match.EndedAt.UnixMilli(),
+			Frames:     match.Frames,
+			DurationMs: match.DurationMs,
+			Voided:     match.Voided,
+			HasReplay:  match.Replay != "",
+		}
+		for _, p := range match.Players {
+			record.Players = append(record.Players, &pb.MatchPlayerResult{
+				PlayerId:  p.ID,
+				Team:      p.Team,
+				Place:     p.Place,
+				Frames:    p.Frames,
+				Pieces:    int32(p.Pieces),
+				Lines:     int32(p.Lines),
+				LinesSent: int32(p.LinesSent),
+			})
+		}
+		replyMsg.Matches = append(replyMsg.Matches, record)
+	}
+}
+
+// watchGame 记录进行中的游戏，游戏结束后在管理器协程中移除
+func (m *RoomManager) watchGame(roomID string, game IGame) {
+	m.games[roomID] = game
+	metrics.GamesActive.Set(float64(len(m.games)))
+	go func() {
+		select {
+		case <-m.ctx.Done():
+		case <-game.Done():
+			m.run(func() {
+				delete(m.games, roomID)
+				metrics.GamesActive.Set(float64(len(m.games)))
+				m.returnToRoom(roomID)
+			})
+		}
+	}()
+}
+
+// returnToRoom 游戏结束或取消后房间回到等待状态，并通知房间的状态
+// 玩家的连接在游戏结束前已经由游戏交还给管理器
+func (m *RoomManager) returnToRoom(roomID string) {
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return
+	}
+	room.EndGame()
+	m.log.Info("Players returned to room", logging.KeyRoom, roomID)
+	m.broadcastRoomInfoChanged(roomID, "")
+}
+
+// run 在管理器协程中执行f，管理器已停止时返回false
+func (m *RoomManager) run(f func()) bool {
+	done := make(chan struct{})
+	select {
+	case m.controlChan <- func() { f(); close(done) }:
+	case <-m.ctx.Done():
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// SetLeaderboard 替换单人模式的排行榜，需要在Start之前调用
+func (m *RoomManager) SetLeaderboard(store leaderboard.Store) {
+	m.services.Leaderboard = store
+}
+
+// SetHistory 替换比赛记录，团队积分也从中读取并保存到其中，需要在Start之前调用
+func (m *RoomManager) SetHistory(store history.Store) error {
+	ratings, err := NewRatings(store)
+	if err != nil {
+		return err
+	}
+	m.services.History = store
+	m.services.Ratings = ratings
+	return nil
+}
+
+// Services 返回游戏共用的积分、排行榜和比赛记录，Start之后不再修改
+func (m *RoomManager) Services() Services {
+	return m.services
+}
+
+// ApplySettings 在管理器协程中更新房间和游戏参数
+func (m *RoomManager) ApplySettings(s Settings) error {
+	ok := m.run(func() {
+		m.settings = s
+		m.log.Info("Settings applied", "max_players", s.MaxPlayers, "frame_interval", s.FrameInterval,
+			"min_input_delay", s.MinInputDelay, "max_input_delay", s.MaxInputDelay)
+	})
+	if !ok {
+		return ErrRoomManagerStopped
+	}
+	return nil
+}
+
+// Shutdown 拒绝之后的建房、进房和开始游戏请求，
+// 通知所有房间中的玩家服务器将在deadline关闭，并返回仍在进行的游戏
+func (m *RoomManager) Shutdown(reason string, deadline time.Time) []IGame {
+	var games []IGame
+	m.run(func() {
+		m.shuttingDown = true
+		shutdown := &pb.S2C_ServerShutdown{Reason: reason}
+		if !deadline.IsZero() {
+			shutdown.Deadline = deadline.UnixMilli()
+		}
+		notice := &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CServerShutdown{
+				S2CServerShutdown: shutdown,
+			},
+		}
+		for _, room := range m.rooms {
+			for _, p := range room.Players() {
+				select {
+				case p.Conn().SendChan() <- notice:
+				default:
+					metrics.SendDrops.WithLabelValues("server_shutdown").Inc()
+					m.log.Warn("Failed to send shutdown notice", logging.KeyPlayer, p.ID())
+				}
+			}
+		}
+		for _, g := range m.games {
+			games = append(games, g)
+		}
+		m.log.Info("Room manager shutting down", "running_games", len(games))
+	})
+	return games
+}
+
+// Drain 关闭房间管理器并等待进行中的游戏结束
+// ctx结束时强制结束剩余的游戏，最多等待stopTimeout让它们保存回放和比赛记录，然后返回ctx.Err()
+func (m *RoomManager) Drain(ctx context.Context, reason string) error {
+	deadline, _ := ctx.Deadline()
+	games := m.Shutdown(reason, deadline)
+	for i, g := range games {
+		select {
+		case <-g.Done():
+		case <-ctx.Done():
+			m.log.Warn("Shutdown deadline reached, stopping games", "running_games", len(games)-i)
+			m.stopGames(games[i:])
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// stopTimeout 强制结束游戏后等待游戏保存结果的最长时间
+const stopTimeout = 5 * time.Second
+
+// stopGames 强制结束games并等待它们退出，超过stopTimeout后放弃等待
+func (m *RoomManager) stopGames(games []IGame) {
+	for _, g := range games {
+		g.Stop()
+	}
+	timeout := time.NewTimer(stopTimeout)
+	defer timeout.Stop()
+	for i, g := range games {
+		select {
+		case <-g.Done():
+		case <-timeout.C:
+			m.log.Error("Timed out waiting for stopped games", "running_games", len(games)-i)
+			return
+		}
+	}
+}
+
 func (m *RoomManager) handleExitRoom(conn network.IConn, message *pb.C2S_ExitRoom) {
-	log.Info("接收到消息: %s", message)
 	replyMsg := &pb.S2C_ExitRoom{}
 	roomID := message.GetRoomId()
 	playerID := message.GetPlayerId()
+	log := m.connLog(conn, roomID)
 
 	defer func() {
 		if !replyMsg.Error {
@@ -204,14 +805,14 @@ func (m *RoomManager) handleExitRoom(conn network.IConn, message *pb.C2S_ExitRoo
 
 	r, ok := m.rooms[roomID]
 	if !ok {
-		log.Error("Room not found: %s", roomID)
+		log.Warn("Room not found")
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Room not found"
 		return
 	}
 	err := r.RemovePlayer(playerID)
 	if err != nil {
-		log.Error("Failed to remove player from room: %v", err)
+		log.Warn("Failed to remove player from room", logging.KeyError, err)
 		replyMsg.Error = true
 		replyMsg.ErrorMsg = "Failed to remove player from room"
 		return
@@ -219,11 +820,11 @@ func (m *RoomManager) handleExitRoom(conn network.IConn, message *pb.C2S_ExitRoo
 	delete(m.player2room, playerID)
 
 	replyMsg.Error = false
+	log.Info("Player exited room")
 }
 
 func (m *RoomManager) handleMessage(conn network.IConn, packet *pb.MessageWrapper) bool {
 	message := packet.Msg
-	// log.Info("Received message: %T", message)
 	switch payload := message.(type) {
 	case *pb.MessageWrapper_C2SEnterRoom:
 		m.handleEnterRoom(conn, payload.C2SEnterRoom)
@@ -233,17 +834,28 @@ func (m *RoomManager) handleMessage(conn network.IConn, packet *pb.MessageWrappe
 		m.handleExitRoom(conn, payload.C2SExitRoom)
 	case *pb.MessageWrapper_C2SStartGame:
 		m.handleStartGame(conn, payload.C2SStartGame)
+	case *pb.MessageWrapper_C2SSetTeam:
+		m.handleSetTeam(conn, payload.C2SSetTeam)
+	case *pb.MessageWrapper_C2SSetPausePolicy:
+		m.handleSetPausePolicy(conn, payload.C2SSetPausePolicy)
+	case *pb.MessageWrapper_C2SSetDisconnectPolicy:
+		m.handleSetDisconnectPolicy(conn, payload.C2SSetDisconnectPolicy)
+	case *pb.MessageWrapper_C2SRejoin:
+		m.handleRejoin(conn, payload.C2SRejoin)
+	case *pb.MessageWrapper_C2SLeaderboard:
+		m.handleLeaderboard(conn, payload.C2SLeaderboard)
+	case *pb.MessageWrapper_C2SPlayerProfile:
+		m.handlePlayerProfile(conn, payload.C2SPlayerProfile)
 	case *pb.MessageWrapper_C2SHeartbeat:
-		// Heartbeat message, no action needed
+		// Heartbeat message, liveness is already recorded by the Conn
 	default:
-		log.Error("Unknown message type: %s", payload)
+		m.connLog(conn, "").Warn("Unknown message type", "type", metrics.MessageType(packet))
 	}
 
 	return true
 }
 
 func (m *RoomManager) Start() {
-	// log.Info("room mgr start")
 	go func() {
 		for {
 			select {
@@ -251,6 +863,8 @@ func (m *RoomManager) Start() {
 				return
 			case msg := <-m.handleChan:
 				m.handleMessage(msg.Conn(), msg.Msg())
+			case f := <-m.controlChan:
+				f()
 			}
 
 		}
@@ -258,12 +872,23 @@ func (m *RoomManager) Start() {
 }
 
 func NewRoomManager(context context.Context, config *network.Config, creator IRoomCreator) *RoomManager {
+	matches := history.NewMemoryStore()
+	// 内存中的比赛记录读取积分不会失败
+	ratings, _ := NewRatings(matches)
 	return &RoomManager{
-		ctx:         context,
-		cfg:         config,
-		creator:     creator,
-		rooms:       make(map[string]IRoom),
+		log:     logging.For(logging.Room),
+		ctx:     context,
+		cfg:     config,
+		creator: creator,
+		rooms:   make(map[string]IRoom),
+		games:   make(map[string]IGame),
+		services: Services{
+			Ratings:     ratings,
+			Leaderboard: leaderboard.NewMemoryStore(leaderboard.Options{Orders: LeaderboardOrders}),
+			History:     matches,
+		},
 		handleChan:  make(chan *network.ConnMessage, config.ReceiveChanSize),
+		controlChan: make(chan func()),
 		player2room: make(map[string]IRoom),
 	}
 }
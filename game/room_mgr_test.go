@@ -0,0 +1,406 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/network"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func testConfig() *network.Config {
+	return &network.Config{
+		ReceiveChanSize: 1024,
+		ReceiveTimeout:  30 * time.Second,
+		SendChanSize:    1024,
+		SendTimeout:     30 * time.Second,
+	}
+}
+
+func newTestRoomManager(t *testing.T) *RoomManager {
+	t.Helper()
+	return newTestRoomManagerWithConfig(t, testConfig())
+}
+
+func newTestRoomManagerWithConfig(t *testing.T, cfg *network.Config) *RoomManager {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	m := NewRoomManager(ctx, cfg, &UniqueIDRoomCreator{})
+	m.Start()
+	return m
+}
+
+func isCreateRoom(m *pb.MessageWrapper) bool      { return m.GetS2CCreateRoom() != nil }
+func isEnterRoom(m *pb.MessageWrapper) bool       { return m.GetS2CEnterRoom() != nil }
+func isExitRoom(m *pb.MessageWrapper) bool        { return m.GetS2CExitRoom() != nil }
+func isRoomInfoChanged(m *pb.MessageWrapper) bool { return m.GetS2CRoomInfoChanged() != nil }
+func isStartGame(m *pb.MessageWrapper) bool       { return m.GetS2CStartGame() != nil }
+
+func mustWait(t *testing.T, c *nettest.Client, match func(*pb.MessageWrapper) bool) *pb.MessageWrapper {
+	t.Helper()
+	msg, err := c.WaitFor(match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// createRoom 让host创建房间并返回房间ID
+func createRoom(t *testing.T, host *nettest.Client) string {
+	t.Helper()
+	return createModeRoom(t, host, "")
+}
+
+// createModeRoom 让host创建指定模式的房间并返回房间ID
+func createModeRoom(t *testing.T, host *nettest.Client, mode string) string {
+	t.Helper()
+	host.CreateRoomWithMode(mode)
+	reply := mustWait(t, host, isCreateRoom).GetS2CCreateRoom()
+	if reply.GetError() {
+		t.Fatalf("create room failed: %s", reply.GetErrorMsg())
+	}
+	return reply.GetInfo().GetRoomId()
+}
+
+func enterRoom(t *testing.T, c *nettest.Client, roomID string) {
+	t.Helper()
+	c.EnterRoom(roomID)
+	reply := mustWait(t, c, isEnterRoom).GetS2CEnterRoom()
+	if reply.GetError() {
+		t.Fatalf("enter room failed: %s", reply.GetErrorMsg())
+	}
+}
+
+func TestCreateRoom(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+
+	host.CreateRoom()
+	reply := mustWait(t, host, isCreateRoom).GetS2CCreateRoom()
+	if reply.GetError() {
+		t.Fatalf("create room failed: %s", reply.GetErrorMsg())
+	}
+	if reply.GetInfo().GetRoomId() == "" {
+		t.Fatal("create room reply has no room ID")
+	}
+	if got := reply.GetInfo().GetPlayerIds(); len(got) != 1 || got[0] != "p1" {
+		t.Fatalf("room players = %v, want [p1]", got)
+	}
+	if reply.GetInfo().GetMode() != ModeVersus {
+		t.Fatalf("room mode = %q, want versus by default", reply.GetInfo().GetMode())
+	}
+
+	// 已经在房间中的玩家不能再创建房间
+	host.CreateRoom()
+	reply = mustWait(t, host, isCreateRoom).GetS2CCreateRoom()
+	if !reply.GetError() {
+		t.Fatal("second create room succeeded, want error")
+	}
+}
+
+func TestCreateRoomWithMode(t *testing.T) {
+	m := newTestRoomManager(t)
+	if err := m.ApplySettings(Settings{MaxPlayers: 2, RoyaleMaxPlayers: 3}); err != nil {
+		t.Fatal(err)
+	}
+	guest := nettest.NewClient("p0", m)
+	guest.CreateRoomWithMode("marathon")
+	if reply := mustWait(t, guest, isCreateRoom).GetS2CCreateRoom(); !reply.GetError() {
+		t.Fatal("unknown mode accepted, want error")
+	}
+	// 大逃杀依赖服务器模拟，需要开启输入校验
+	guest.CreateRoomWithMode(ModeRoyale)
+	if reply := mustWait(t, guest, isCreateRoom).GetS2CCreateRoom(); reply.GetErrorMsg() != "Game mode requires input validation" {
+		t.Fatalf("got %v, want royale rejected without input validation", reply)
+	}
+	if err := m.ApplySettings(Settings{MaxPlayers: 2, RoyaleMaxPlayers: 3, ValidateInput: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 大逃杀房间使用自己的人数上限
+	clients := []*nettest.Client{nettest.NewClient("p1", m), nettest.NewClient("p2", m), nettest.NewClient("p3", m)}
+	roomID := createModeRoom(t, clients[0], ModeRoyale)
+	for _, c := range clients[1:] {
+		enterRoom(t, c, roomID)
+	}
+	info, err := m.Room(roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != ModeRoyale || len(info.Players) != 3 {
+		t.Fatalf("got %+v, want a royale room with 3 players", info)
+	}
+}
+
+func TestCreateRoomUniqueIDs(t *testing.T) {
+	m := newTestRoomManager(t)
+	a := nettest.NewClient("p1", m)
+	b := nettest.NewClient("p2", m)
+
+	if createRoom(t, a) == createRoom(t, b) {
+		t.Fatal("two rooms got the same ID")
+	}
+}
+
+func TestEnterRoom(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+
+	guest.EnterRoom(roomID)
+	reply := mustWait(t, guest, isEnterRoom).GetS2CEnterRoom()
+	if reply.GetError() {
+		t.Fatalf("enter room failed: %s", reply.GetErrorMsg())
+	}
+	if got := reply.GetInfo().GetPlayerIds(); len(got) != 2 {
+		t.Fatalf("room players = %v, want 2 players", got)
+	}
+
+	// 房间内其他玩家收到房间变化通知
+	info := mustWait(t, host, isRoomInfoChanged).GetS2CRoomInfoChanged()
+	if info.GetRoomId() != roomID || len(info.GetPlayerIds()) != 2 {
+		t.Fatalf("host got room info %v", info)
+	}
+
+	// 触发变化的玩家自己不会收到通知
+	if n := guest.Conn.Pending(); n != 0 {
+		t.Fatalf("guest has %d pending messages, want 0", n)
+	}
+}
+
+func TestEnterRoomErrors(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	roomID := createRoom(t, host)
+
+	guest := nettest.NewClient("p2", m)
+	guest.EnterRoom("missing")
+	if reply := mustWait(t, guest, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("enter missing room succeeded, want error")
+	}
+
+	host.EnterRoom(roomID)
+	if reply := mustWait(t, host, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("entering the same room twice succeeded, want error")
+	}
+}
+
+func TestEnterFullRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m := NewRoomManager(ctx, testConfig(), &UniqueIDRoomCreator{})
+	m.Start()
+	unlimited := createRoom(t, nettest.NewClient("p0", m))
+	if err := m.ApplySettings(Settings{MaxPlayers: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	host := nettest.NewClient("p1", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, nettest.NewClient("p2", m), roomID)
+
+	third := nettest.NewClient("p3", m)
+	third.EnterRoom(roomID)
+	if reply := mustWait(t, third, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("entering a full room succeeded, want error")
+	}
+
+	// 已有的房间不受新设置影响
+	enterRoom(t, nettest.NewClient("p4", m), unlimited)
+	enterRoom(t, nettest.NewClient("p5", m), unlimited)
+}
+
+func TestExitRoom(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+	mustWait(t, host, isRoomInfoChanged)
+
+	guest.ExitRoom(roomID)
+	if reply := mustWait(t, guest, isExitRoom).GetS2CExitRoom(); reply.GetError() {
+		t.Fatalf("exit room failed: %s", reply.GetErrorMsg())
+	}
+	info := mustWait(t, host, isRoomInfoChanged).GetS2CRoomInfoChanged()
+	if got := info.GetPlayerIds(); len(got) != 1 || got[0] != "p1" {
+		t.Fatalf("room players after exit = %v, want [p1]", got)
+	}
+
+	// 退出后可以重新创建房间
+	if createRoom(t, guest) == roomID {
+		t.Fatal("new room reused the old room ID")
+	}
+
+	guest.ExitRoom(roomID)
+	if reply := mustWait(t, guest, isExitRoom).GetS2CExitRoom(); !reply.GetError() {
+		t.Fatal("exiting a room twice succeeded, want error")
+	}
+}
+
+func TestStartGame(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+
+	host.StartGame("missing")
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); !reply.GetError() {
+		t.Fatal("start missing room succeeded, want error")
+	}
+	if guest.Conn.Handler() != m {
+		t.Fatal("failed start moved guest away from the room manager")
+	}
+
+	rules := MatchRules{Gravity: []GravityStep{{Frame: 0, FramesPerRow: 20}}, LockDelay: 12, GarbageCancel: true}
+	if err := m.ApplySettings(Settings{Rules: rules, ValidateInput: true}); err != nil {
+		t.Fatal(err)
+	}
+	host.StartGame(roomID)
+	var setups []*pb.MatchSetup
+	for _, c := range []*nettest.Client{host, guest} {
+		reply := mustWait(t, c, isStartGame).GetS2CStartGame()
+		if reply.GetError() {
+			t.Fatalf("start game failed: %s", reply.GetErrorMsg())
+		}
+		game, ok := c.Conn.Handler().(*Game)
+		if !ok {
+			t.Fatalf("player %s handler is %T, want *Game", c.PlayerID, c.Conn.Handler())
+		}
+		if !proto.Equal(reply.GetSetup(), game.Setup()) {
+			t.Fatalf("player %s got setup %v, game has %v", c.PlayerID, reply.GetSetup(), game.Setup())
+		}
+		setups = append(setups, reply.GetSetup())
+	}
+	setup := setups[0]
+	if setup.GetRulesVersion() != tetris.RulesVersion || setup.GetMode() != ModeVersus || setup.GetLockDelay() != 12 || !setup.GetGarbage().GetCancel() ||
+		len(setup.GetGravity()) != 1 || setup.GetGravity()[0].GetFramesPerRow() != 20 {
+		t.Fatalf("got setup %v, want the configured rules", setup)
+	}
+}
+
+func TestRoomStatusFollowsGame(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createRoom(t, host)
+	enterRoom(t, guest, roomID)
+	status := func() string {
+		var s string
+		m.run(func() { s = m.rooms[roomID].Status() })
+		return s
+	}
+
+	guest.StartGame(roomID)
+	if reply := mustWait(t, guest, isStartGame).GetS2CStartGame(); !reply.GetError() {
+		t.Fatal("guest started the game, want only the host")
+	}
+	host.StartGame(roomID)
+	for _, c := range []*nettest.Client{host, guest} {
+		if reply := mustWait(t, c, isStartGame).GetS2CStartGame(); reply.GetError() {
+			t.Fatalf("start game failed: %s", reply.GetErrorMsg())
+		}
+	}
+	game := host.Conn.Handler().(*Game)
+	if got := status(); got != GameRoom {
+		t.Fatalf("room status %q during the game, want %q", got, GameRoom)
+	}
+
+	// 游戏进行中从新的连接发来的请求不能再开始一局或修改房间
+	other := nettest.NewClient("p1", m)
+	other.StartGame(roomID)
+	if reply := mustWait(t, other, isStartGame).GetS2CStartGame(); reply.GetErrorMsg() != "Room is already in game" {
+		t.Fatalf("second start got %v, want already in game", reply)
+	}
+	other.SetTeam(roomID, "p2", 1)
+	if reply := mustWait(t, other, isSetTeam).GetS2CSetTeam(); reply.GetErrorMsg() != "Room is already in game" {
+		t.Fatalf("set team during the game got %v, want already in game", reply)
+	}
+	newcomer := nettest.NewClient("p3", m)
+	newcomer.EnterRoom(roomID)
+	if reply := mustWait(t, newcomer, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("entered a room already in game")
+	}
+
+	// 游戏结束后回到房间，可以开始下一局
+	game.Stop()
+	<-game.Done()
+	for _, c := range []*nettest.Client{host, guest} {
+		mustWait(t, c, isRoomInfoChanged)
+		if c.Conn.Handler() != m {
+			t.Fatalf("player %s handler is %T after the game, want the room manager", c.PlayerID, c.Conn.Handler())
+		}
+	}
+	if got := status(); got != WaitingRoom {
+		t.Fatalf("room status %q after the game, want %q", got, WaitingRoom)
+	}
+	host.StartGame(roomID)
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); reply.GetError() {
+		t.Fatalf("restart failed: %s", reply.GetErrorMsg())
+	}
+	if host.Conn.Handler() == game {
+		t.Fatal("restart reused the finished game")
+	}
+}
+
+func TestMatchSeedsDiffer(t *testing.T) {
+	a, b := newMatchSetup(ModeVersus, Settings{}), newMatchSetup(ModeVersus, Settings{})
+	if a.GetSeed() == b.GetSeed() {
+		t.Fatalf("two matches got the same seed %d", a.GetSeed())
+	}
+}
+
+func isServerShutdown(m *pb.MessageWrapper) bool { return m.GetS2CServerShutdown() != nil }
+
+func TestShutdownRefusesNewRooms(t *testing.T) {
+	m := newTestRoomManager(t)
+	host := nettest.NewClient("p1", m)
+	roomID := createRoom(t, host)
+
+	deadline := time.Unix(100, 0)
+	if games := m.Shutdown("maintenance", deadline); len(games) != 0 {
+		t.Fatalf("Shutdown returned %d games, want 0", len(games))
+	}
+	notice := mustWait(t, host, isServerShutdown).GetS2CServerShutdown()
+	if notice.GetReason() != "maintenance" || notice.GetDeadline() != deadline.UnixMilli() {
+		t.Fatalf("got shutdown notice %v", notice)
+	}
+
+	guest := nettest.NewClient("p2", m)
+	guest.CreateRoom()
+	if reply := mustWait(t, guest, isCreateRoom).GetS2CCreateRoom(); !reply.GetError() {
+		t.Fatal("create room during shutdown succeeded, want error")
+	}
+	guest.EnterRoom(roomID)
+	if reply := mustWait(t, guest, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("enter room during shutdown succeeded, want error")
+	}
+	host.StartGame(roomID)
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); !reply.GetError() {
+		t.Fatal("start game during shutdown succeeded, want error")
+	}
+
+	// 退出房间仍然允许
+	host.ExitRoom(roomID)
+	if reply := mustWait(t, host, isExitRoom).GetS2CExitRoom(); reply.GetError() {
+		t.Fatalf("exit room during shutdown failed: %s", reply.GetErrorMsg())
+	}
+}
+
+func TestRulesVersionOnlyWithValidation(t *testing.T) {
+	// 没有开启输入校验时服务器不使用模拟，不要求客户端实现规则版本
+	if v := newMatchSetup(ModeVersus, Settings{}).GetRulesVersion(); v != 0 {
+		t.Fatalf("rules version = %d without validation, want 0", v)
+	}
+	if v := newMatchSetup(ModeVersus, Settings{ValidateInput: true}).GetRulesVersion(); v != tetris.RulesVersion {
+		t.Fatalf("rules version = %d with validation, want %d", v, tetris.RulesVersion)
+	}
+}
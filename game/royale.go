@@ -0,0 +1,252 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// summaryInterval 大逃杀中每隔多少帧发送一次棋盘概要
+const summaryInterval = 10
+
+// defaultFullBoards 没有设置RoyaleFullBoards时每个玩家接收完整帧的棋盘数
+const defaultFullBoards = 8
+
+// badgeBonus 徽章点数达到points时攻击增加percent
+var badgeBonus = []struct{ points, percent int32 }{
+	{2, 25},
+	{6, 50},
+	{14, 75},
+	{30, 100},
+}
+
+// royale 大逃杀的目标、徽章和名次，只在游戏协程中使用
+type royale struct {
+	// rng 由种子决定的目标选择，同一局的回放得到相同的目标
+	rng      *rand.Rand
+	strategy map[string]pb.TargetStrategy
+	// targets 每个玩家最近一次攻击的目标
+	targets map[string]string
+	// lastHit 最近一次把垃圾放入玩家的攻击者，玩家被淘汰时由其获得KO
+	lastHit map[string]string
+	badges  map[string]int32
+	places  map[string]int32
+	// eliminated 按淘汰顺序排列的玩家
+	eliminated []string
+}
+
+func newRoyale(setup *pb.MatchSetup) *royale {
+	seed := uint64(setup.GetSeed())
+	return &royale{
+		rng:      rand.New(rand.NewPCG(seed, seed^0xD1B54A32D192ED03)),
+		strategy: make(map[string]pb.TargetStrategy),
+		targets:  make(map[string]string),
+		lastHit:  make(map[string]string),
+		badges:   make(map[string]int32),
+		places:   make(map[string]int32),
+	}
+}
+
+// boost 按徽章点数增加攻击行数
+func (r *royale) boost(from string, lines int32) int32 {
+	percent := int32(0)
+	for _, bonus := range badgeBonus {
+		if r.badges[from] >= bonus.points {
+			percent = bonus.percent
+		}
+	}
+	return lines * (100 + percent) / 100
+}
+
+// pick 从候选中随机选择一个
+func (r *royale) pick(candidates []string) string {
+	return candidates[r.rng.IntN(len(candidates))]
+}
+
+// target 按from选择的策略从opponents中选择目标，opponents按ID排序且不为空
+func (r *royale) target(from string, opponents []string, players map[string]*GamePlayer) string {
+	var candidates []string
+	switch r.strategy[from] {
+	case pb.TargetStrategy_TARGET_ATTACKERS:
+		// 正在攻击自己的玩家
+		for _, id := range opponents {
+			if r.targets[id] == from {
+				candidates = append(candidates, id)
+			}
+		}
+	case pb.TargetStrategy_TARGET_KOS:
+		// 最接近堆到顶的玩家：最高的列加上待收的垃圾
+		best := -1
+		for _, id := range opponents {
+			sim := players[id].check.sim
+			heights := sim.Board().Heights()
+			danger := slices.Max(heights[:]) + sim.PendingGarbage()
+			if danger > best {
+				best, candidates = danger, nil
+			}
+			if danger == best {
+				candidates = append(candidates, id)
+			}
+		}
+	case pb.TargetStrategy_TARGET_BADGES:
+		// 徽章点数最多的玩家，都没有徽章时随机选择
+		best := int32(1)
+		for _, id := range opponents {
+			if r.badges[id] > best {
+				best, candidates = r.badges[id], nil
+			}
+			if r.badges[id] == best {
+				candidates = append(candidates, id)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = opponents
+	}
+	target := r.pick(candidates)
+	r.targets[from] = target
+	return target
+}
+
+// ranking 名次从高到低的玩家，仍然存活的玩家按ID排在最前面
+func (r *royale) ranking(players map[string]*GamePlayer) []string {
+	var ranking []string
+	for id := range players {
+		if r.places[id] == 0 {
+			ranking = append(ranking, id)
+		}
+	}
+	sort.Strings(ranking)
+	for i := len(r.eliminated) - 1; i >= 0; i-- {
+		ranking = append(ranking, r.eliminated[i])
+	}
+	return ranking
+}
+
+// assignBoards 为每个玩家选择接收完整帧的棋盘：自己和按ID排序后紧随其后的玩家
+func (g *Game) assignBoards() {
+	ids := make([]string, 0, len(g.players))
+	for id := range g.players {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	n := g.settings.RoyaleFullBoards
+	if n <= 0 {
+		n = defaultFullBoards
+	}
+	n = min(n, len(ids))
+	for i, id := range ids {
+		boards := make(map[string]bool, n)
+		for j := 0; j < n; j++ {
+			boards[ids[(i+j)%len(ids)]] = true
+		}
+		g.players[id].boards = boards
+	}
+}
+
+// boardSummaries 所有玩家的棋盘概要，按玩家ID排序
+func (g *Game) boardSummaries() []*pb.BoardSummary {
+	summaries := make([]*pb.BoardSummary, 0, len(g.players))
+	for id, p := range g.players {
+		heights := p.check.sim.Board().Heights()
+		summary := &pb.BoardSummary{
+			PlayerId: id,
+			Heights:  make([]byte, len(heights)),
+			Pending:  int32(p.check.sim.PendingGarbage()),
+			Badges:   g.royale.badges[id],
+			Target:   g.royale.targets[id],
+			Place:    g.royale.places[id],
+		}
+		for x, h := range heights {
+			summary.Heights[x] = byte(h)
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].PlayerId < summaries[j].PlayerId })
+	return summaries
+}
+
+// handleSetTarget 大逃杀中修改玩家的目标策略，下一次攻击时生效
+func (g *Game) handleSetTarget(message *pb.C2S_SetTarget) {
+	playerID := message.GetPlayerId()
+	if g.royale == nil {
+		g.log.Warn("Target strategy is only used in battle royale", logging.KeyPlayer, playerID)
+		return
+	}
+	if _, ok := g.players[playerID]; !ok {
+		g.log.Warn("Player not found when setting target", logging.KeyPlayer, playerID)
+		return
+	}
+	if _, ok := pb.TargetStrategy_name[int32(message.GetStrategy())]; !ok {
+		g.log.Warn("Unknown target strategy", logging.KeyPlayer, playerID, "strategy", message.GetStrategy())
+		return
+	}
+	g.royale.strategy[playerID] = message.GetStrategy()
+	g.log.Debug("Target strategy changed", logging.KeyPlayer, playerID, "strategy", message.GetStrategy())
+}
+
+// eliminate 淘汰玩家并广播名次，koBy仍然存活时获得1点加上被淘汰玩家的徽章点数
+// 只剩一名玩家时其获得第一名，游戏结束
+func (g *Game) eliminate(player *GamePlayer, koBy string) {
+	place := int32(0)
+	for _, p := range g.players {
+		if !p.ended {
+			place++
+		}
+	}
+	g.finish(player)
+	r := g.royale
+	r.places[player.playerID] = place
+	r.eliminated = append(r.eliminated, player.playerID)
+
+	elimination := &pb.S2C_Elimination{PlayerId: player.playerID, Place: place}
+	if attacker, ok := g.players[koBy]; ok && koBy != player.playerID && !attacker.ended {
+		r.badges[koBy] += 1 + r.badges[player.playerID]
+		elimination.KoBy = koBy
+		elimination.Badges = r.badges[koBy]
+	}
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CElimination{S2CElimination: elimination},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- msg:
+		default:
+			metrics.SendDrops.WithLabelValues("elimination").Inc()
+			g.log.Warn("Failed to send elimination", logging.KeyPlayer, p.playerID)
+		}
+	}
+	g.log.Info("Player eliminated", logging.KeyPlayer, player.playerID, "place", place, "ko_by", elimination.KoBy)
+	if g.replay != nil {
+		players := []string{player.playerID}
+		if elimination.KoBy != "" {
+			players = append(players, elimination.KoBy)
+		}
+		g.replay.recordEvent(ReplayEvent{
+			Frame:     g.frameNumber,
+			Type:      ReplayEventElimination,
+			FromFrame: g.frameNumber,
+			ToFrame:   g.frameNumber,
+			Players:   players,
+			Detail:    fmt.Sprintf("place %d", place),
+		})
+	}
+
+	if place > 2 {
+		return
+	}
+	for _, p := range g.players {
+		if !p.ended {
+			g.finish(p)
+			r.places[p.playerID] = 1
+			r.eliminated = append(r.eliminated, p.playerID)
+			g.log.Info("Player won", logging.KeyPlayer, p.playerID)
+		}
+	}
+	g.broadcastGameEnd(&pb.S2C_GameEnd{EndGame: true})
+	g.endGame()
+}
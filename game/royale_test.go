@@ -0,0 +1,152 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"slices"
+	"testing"
+)
+
+func isElimination(m *pb.MessageWrapper) bool { return m.GetS2CElimination() != nil }
+
+func TestRoyaleTargeting(t *testing.T) {
+	r := newRoyale(&pb.MatchSetup{Seed: 1})
+	players := make(map[string]*GamePlayer)
+	for _, id := range []string{"p1", "p2", "p3", "p4"} {
+		players[id] = &GamePlayer{playerID: id, check: validation{sim: tetris.NewGame(1, tetris.DefaultConfig())}}
+	}
+	opponents := []string{"p2", "p3", "p4"}
+
+	r.targets["p3"] = "p1"
+	r.strategy["p1"] = pb.TargetStrategy_TARGET_ATTACKERS
+	if got := r.target("p1", opponents, players); got != "p3" {
+		t.Errorf("attackers: got %s, want p3", got)
+	}
+
+	players["p4"].check.sim.ReceiveGarbage(6, 0)
+	r.strategy["p1"] = pb.TargetStrategy_TARGET_KOS
+	if got := r.target("p1", opponents, players); got != "p4" {
+		t.Errorf("KOs: got %s, want p4", got)
+	}
+
+	r.badges["p2"] = 3
+	r.badges["p4"] = 1
+	r.strategy["p1"] = pb.TargetStrategy_TARGET_BADGES
+	if got := r.target("p1", opponents, players); got != "p2" {
+		t.Errorf("badges: got %s, want p2", got)
+	}
+	if r.targets["p1"] != "p2" {
+		t.Errorf("target not recorded: %v", r.targets)
+	}
+
+	// 没有符合策略的对手时随机选择
+	r.strategy["p2"] = pb.TargetStrategy_TARGET_ATTACKERS
+	if got := r.target("p2", []string{"p3", "p4"}, players); got != "p3" && got != "p4" {
+		t.Errorf("fallback: got %s", got)
+	}
+}
+
+func TestRoyaleBadgeBonus(t *testing.T) {
+	r := newRoyale(&pb.MatchSetup{Seed: 1})
+	for _, tc := range []struct{ badges, lines, want int32 }{
+		{0, 4, 4},
+		{1, 4, 4},
+		{2, 4, 5},
+		{6, 4, 6},
+		{14, 4, 7},
+		{30, 4, 8},
+	} {
+		r.badges["p1"] = tc.badges
+		if got := r.boost("p1", tc.lines); got != tc.want {
+			t.Errorf("%d badges: %d lines boosted to %d, want %d", tc.badges, tc.lines, got, tc.want)
+		}
+	}
+}
+
+func TestRoyaleBoardsAndSummaries(t *testing.T) {
+	clock, clients := startClockedGame(t, ModeRoyale, Settings{RoyaleFullBoards: 2, ValidateInput: true}, "p1", "p2", "p3", "p4")
+
+	clock.Advance(FrameInterval)
+	for i, c := range clients {
+		sync := mustWait(t, c, isSyncFrames).GetS2CSyncFrames()
+		var full, summarized []string
+		for _, p := range sync.GetPlayers() {
+			full = append(full, p.GetPlayerId())
+		}
+		for _, s := range sync.GetSummaries() {
+			summarized = append(summarized, s.GetPlayerId())
+			if len(s.GetHeights()) != tetris.Width || s.GetPlace() != 0 {
+				t.Errorf("%s: bad summary %v", c.PlayerID, s)
+			}
+		}
+		slices.Sort(full)
+		next := clients[(i+1)%len(clients)].PlayerID
+		want := []string{c.PlayerID, next}
+		slices.Sort(want)
+		if !slices.Equal(full, want) {
+			t.Errorf("%s: full boards %v, want %v", c.PlayerID, full, want)
+		}
+		if len(summarized) != 2 || slices.Contains(summarized, c.PlayerID) || slices.Contains(summarized, next) {
+			t.Errorf("%s: summaries for %v", c.PlayerID, summarized)
+		}
+	}
+}
+
+func TestRoyaleEliminations(t *testing.T) {
+	_, clients := startClockedGame(t, ModeRoyale, Settings{ValidateInput: true}, "p1", "p2", "p3")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// p1的垃圾最后落在p3上，p3结束时p1获得KO
+	game.run(func() { game.royale.lastHit["p3"] = "p1" })
+	clients[2].GameEnd(false, nil)
+	for _, c := range clients {
+		e := mustWait(t, c, isElimination).GetS2CElimination()
+		if e.GetPlayerId() != "p3" || e.GetPlace() != 3 || e.GetKoBy() != "p1" || e.GetBadges() != 1 {
+			t.Fatalf("%s: got %v, want p3 in 3rd place KOed by p1", c.PlayerID, e)
+		}
+	}
+	for _, p := range game.Info().Players {
+		if p.ID == "p1" && p.Badges != 1 {
+			t.Fatalf("p1 has %d badges, want 1", p.Badges)
+		}
+	}
+
+	clients[1].GameEnd(false, nil)
+	for _, c := range clients {
+		for {
+			end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+			if !end.GetEndGame() {
+				continue
+			}
+			if !slices.Equal(end.GetRanking(), []string{"p1", "p2", "p3"}) {
+				t.Fatalf("%s: ranking %v", c.PlayerID, end.GetRanking())
+			}
+			break
+		}
+	}
+	assertGameOver(t, game)
+}
+
+func TestRoyaleRequiresValidation(t *testing.T) {
+	m := newTestRoomManager(t)
+	if err := m.ApplySettings(Settings{ValidateInput: true}); err != nil {
+		t.Fatal(err)
+	}
+	host := nettest.NewClient("p1", m)
+	guest := nettest.NewClient("p2", m)
+	roomID := createModeRoom(t, host, ModeRoyale)
+	enterRoom(t, guest, roomID)
+
+	// 房间创建之后关闭了输入校验，不能开始
+	if err := m.ApplySettings(Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	host.StartGame(roomID)
+	if reply := mustWait(t, host, isStartGame).GetS2CStartGame(); reply.GetErrorMsg() != "Game mode requires input validation" {
+		t.Fatalf("got %v, want royale start rejected without input validation", reply)
+	}
+	if guest.Conn.Handler() != m {
+		t.Fatal("rejected start moved guest away from the room manager")
+	}
+}
@@ -0,0 +1,159 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	pb "TetrisSvr/proto"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// 房间的游戏模式，创建房间时选择
+const (
+	// ModeVersus 对战模式，攻击轮流发给对手
+	ModeVersus = "versus"
+	// ModeRoyale 大逃杀模式，玩家选择目标策略，淘汰对手获得徽章
+	ModeRoyale = "royale"
+	// ModeTeams 团队对战，队员全部结束的队伍输掉比赛
+	ModeTeams = "teams"
+	// ModeSprint 单人40行竞速，按用时排名
+	ModeSprint = "sprint"
+	// ModeUltra 单人2分钟限时，按得分排名
+	ModeUltra = "ultra"
+)
+
+// validMode 是否为支持的模式
+func validMode(mode string) bool {
+	switch mode {
+	case ModeVersus, ModeRoyale, ModeTeams, ModeSprint, ModeUltra:
+		return true
+	}
+	return false
+}
+
+// simulatedMode 名次或成绩由服务器模拟决定的模式，只有开启ValidateInput时才能创建和开始
+func simulatedMode(mode string) bool {
+	return mode == ModeRoyale || soloMode(mode)
+}
+
+// soloMode 是否为单人模式
+func soloMode(mode string) bool {
+	return mode == ModeSprint || mode == ModeUltra
+}
+
+// GravityStep 从第Frame帧开始，方块自然下落一格需要FramesPerRow帧
+type GravityStep struct {
+	Frame        int32 `json:"frame"`
+	FramesPerRow int32 `json:"frames_per_row"`
+}
+
+// MatchRules 由配置决定的规则参数，开始游戏时随种子发给所有玩家
+type MatchRules struct {
+	// Gravity 按帧号升序排列的重力曲线，为空时使用tetris.DefaultConfig的重力
+	Gravity []GravityStep `json:"gravity"`
+	// LockDelay 方块落地后锁定的帧数，0表示使用默认值
+	LockDelay int32 `json:"lock_delay"`
+	// GarbageCancel 攻击是否先抵消自己待收的垃圾
+	GarbageCancel bool `json:"garbage_cancel"`
+	// GarbageDelay 攻击经过多少帧后进入对手的待收垃圾
+	GarbageDelay int32 `json:"garbage_delay"`
+	// Attack 攻击表，为空时使用tetris.DefaultAttackTable
+	Attack *tetris.AttackTable `json:"attack,omitempty"`
+}
+
+// newSeed 生成密码学安全的随机种子，不让任何客户端选择方块序列
+func newSeed() int64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// 系统随机源不可用时无法公平地开始游戏
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// newGameID 生成全局唯一的游戏ID，服务器重启后也不会与之前的游戏重复
+// 以开始时间开头，回放文件按名字排序即按时间排序
+func newGameID(now time.Time) string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%s-%x", now.UTC().Format("20060102T150405"), b)
+}
+
+// newMatchSetup 生成本局所有玩家共用的比赛设置
+// 只有开启ValidateInput时服务器才使用模拟的结果，这时才要求客户端实现tetris.RulesVersion的规则，否则规则版本为0
+func newMatchSetup(mode string, settings Settings) *pb.MatchSetup {
+	rules := settings.Rules
+	setup := &pb.MatchSetup{
+		Seed:         newSeed(),
+		Mode:         mode,
+		LockDelay:    rules.LockDelay,
+		Garbage: &pb.GarbageRules{
+			Cancel:      rules.GarbageCancel,
+			DelayFrames: rules.GarbageDelay,
+		},
+	}
+	if settings.ValidateInput {
+		setup.RulesVersion = tetris.RulesVersion
+	}
+	for _, step := range rules.Gravity {
+		setup.Gravity = append(setup.Gravity, &pb.GravityStep{Frame: step.Frame, FramesPerRow: step.FramesPerRow})
+	}
+	table := tetris.DefaultAttackTable()
+	if rules.Attack != nil {
+		table = *rules.Attack
+	}
+	setup.Attack = &pb.AttackTable{
+		Lines:        int32s(table.Lines),
+		TSpin:        int32s(table.TSpin),
+		TSpinMini:    int32s(table.TSpinMini),
+		Combo:        int32s(table.Combo),
+		BackToBack:   int32(table.BackToBack),
+		PerfectClear: int32(table.PerfectClear),
+	}
+	return setup
+}
+
+func int32s(values []int) []int32 {
+	out := make([]int32, len(values))
+	for i, v := range values {
+		out[i] = int32(v)
+	}
+	return out
+}
+
+func ints(values []int32) []int {
+	out := make([]int, len(values))
+	for i, v := range values {
+		out[i] = int(v)
+	}
+	return out
+}
+
+// simConfig 服务器端模拟使用与客户端相同的比赛设置
+func simConfig(setup *pb.MatchSetup) tetris.Config {
+	config := tetris.DefaultConfig()
+	if setup.GetLockDelay() > 0 {
+		config.LockDelay = int(setup.GetLockDelay())
+	}
+	config.CancelGarbage = setup.GetGarbage().GetCancel()
+	if attack := setup.GetAttack(); attack != nil {
+		config.Attack = tetris.AttackTable{
+			Lines:        ints(attack.GetLines()),
+			TSpin:        ints(attack.GetTSpin()),
+			TSpinMini:    ints(attack.GetTSpinMini()),
+			Combo:        ints(attack.GetCombo()),
+			BackToBack:   int(attack.GetBackToBack()),
+			PerfectClear: int(attack.GetPerfectClear()),
+		}
+	}
+	for _, step := range setup.GetGravity() {
+		config.GravityCurve = append(config.GravityCurve, tetris.GravityStep{
+			Frame:        step.GetFrame(),
+			FramesPerRow: int(step.GetFramesPerRow()),
+		})
+	}
+	return config
+}
@@ -0,0 +1,78 @@
+package game
+
+import (
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/logging"
+	pb "TetrisSvr/proto"
+	"time"
+)
+
+const (
+	// sprintLines 竞速模式需要消除的行数
+	sprintLines = 40
+	// ultraDuration 限时模式的时长
+	ultraDuration = 2 * time.Minute
+)
+
+// LeaderboardOrders 单人模式排行榜的排序方向
+var LeaderboardOrders = map[string]leaderboard.Order{
+	ModeSprint: leaderboard.Ascending,
+	ModeUltra:  leaderboard.Descending,
+}
+
+// checkSolo 在模拟推进后检查单人模式是否结束，成绩以服务器端模拟为准
+// 竞速模式消除足够行数后按用时计成绩，堆到顶则未完成；限时模式到时或堆到顶时按得分计成绩
+func (g *Game) checkSolo(frame int32, toppedOut []string) {
+	for _, p := range g.players {
+		if p.ended || g.status == GameOver {
+			continue
+		}
+		stats := p.check.sim.Stats()
+		switch g.setup.GetMode() {
+		case ModeSprint:
+			if stats.Lines >= sprintLines {
+				g.finishSolo(p, frame, (time.Duration(frame+1) * g.interval).Milliseconds(), true)
+			} else if len(toppedOut) > 0 {
+				g.finishSolo(p, frame, 0, false)
+			}
+		case ModeUltra:
+			if len(toppedOut) > 0 || frame+1 >= ultraFrames(g.interval) {
+				g.finishSolo(p, frame, int64(stats.Score), true)
+			}
+		}
+	}
+}
+
+// ultraFrames 限时模式的帧数，不足一帧的时间按一帧计算
+func ultraFrames(interval time.Duration) int32 {
+	return int32((ultraDuration + interval - 1) / interval)
+}
+
+// finishSolo 提交成绩并结束单人游戏，未完成或被标记的玩家不进入排行榜
+func (g *Game) finishSolo(p *GamePlayer, frame int32, value int64, completed bool) {
+	g.finish(p)
+	result := &pb.SoloResult{
+		Mode:      g.setup.GetMode(),
+		Value:     value,
+		Lines:     int32(p.check.sim.Stats().Lines),
+		Frames:    frame + 1,
+		Completed: completed,
+	}
+	if completed && len(p.check.flagged) == 0 && g.services.Leaderboard != nil {
+		ranked, best, err := g.services.Leaderboard.Submit(leaderboard.Entry{
+			Mode:        result.Mode,
+			PlayerID:    p.playerID,
+			Value:       value,
+			GameID:      g.gameID,
+			SubmittedAt: g.clock.Now(),
+		})
+		if err != nil {
+			g.log.Error("Failed to submit leaderboard entry", logging.KeyPlayer, p.playerID, logging.KeyError, err)
+		}
+		result.Rank, result.Season, result.PersonalBest = int32(ranked.Rank), int32(ranked.Season), best
+	}
+	g.log.Info("Solo game finished", logging.KeyPlayer, p.playerID, "value", value,
+		"completed", completed, "rank", result.Rank, "personal_best", result.PersonalBest)
+	g.broadcastGameEnd(&pb.S2C_GameEnd{EndPlayer: p.playerID, EndGame: true, Result: result})
+	g.endGame()
+}
@@ -0,0 +1,126 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/leaderboard"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func isLeaderboard(m *pb.MessageWrapper) bool { return m.GetS2CLeaderboard() != nil }
+
+// topOut 在同一列不停硬降直到堆到顶，返回结束消息
+func topOut(t *testing.T, clock *nettest.ManualClock, c *nettest.Client) *pb.S2C_GameEnd {
+	t.Helper()
+	game := c.Conn.Handler().(*Game)
+	c.Input(bytes.Repeat(ops(tetris.OpHardDrop), 40))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	return mustWait(t, c, isGameEnd).GetS2CGameEnd()
+}
+
+func TestUltraSubmitsScore(t *testing.T) {
+	clock, clients := startClockedGame(t, ModeUltra, Settings{ValidateInput: true}, "p1")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 限时模式堆到顶也按得分计成绩
+	end := topOut(t, clock, clients[0])
+	result := end.GetResult()
+	if !end.GetEndGame() || !result.GetCompleted() || result.GetValue() <= 0 {
+		t.Fatalf("got %v, want a completed ultra score", end)
+	}
+	if result.GetRank() != 1 || result.GetSeason() != 1 || !result.GetPersonalBest() {
+		t.Fatalf("got %v, want a first personal best in season 1", result)
+	}
+	assertGameOver(t, game)
+	page, _ := game.services.Leaderboard.Top(leaderboard.Query{Mode: ModeUltra, Limit: 10})
+	top := page.Entries
+	if len(top) != 1 || top[0].PlayerID != "p1" || top[0].Value != result.GetValue() || top[0].GameID != game.ID() {
+		t.Fatalf("leaderboard %+v", top)
+	}
+
+	// 到时后以当前得分结束
+	_, clients = startClockedGame(t, ModeUltra, Settings{ValidateInput: true}, "p2")
+	game = clients[0].Conn.Handler().(*Game)
+	game.run(func() { game.checkSolo(ultraFrames(FrameInterval)-1, nil) })
+	end = mustWait(t, clients[0], isGameEnd).GetS2CGameEnd()
+	if !end.GetResult().GetCompleted() || end.GetResult().GetFrames() != ultraFrames(FrameInterval) {
+		t.Fatalf("got %v, want the game to end at the time limit", end)
+	}
+	assertGameOver(t, game)
+}
+
+func TestSprintTopOutIsNotRanked(t *testing.T) {
+	clock, clients := startClockedGame(t, ModeSprint, Settings{ValidateInput: true}, "p1")
+	game := clients[0].Conn.Handler().(*Game)
+
+	end := topOut(t, clock, clients[0])
+	if result := end.GetResult(); result.GetCompleted() || result.GetRank() != 0 || result.GetMode() != ModeSprint {
+		t.Fatalf("got %v, want an unranked sprint", end)
+	}
+	assertGameOver(t, game)
+	if page, _ := game.services.Leaderboard.Top(leaderboard.Query{Mode: ModeSprint, Limit: 10}); page.Total != 0 {
+		t.Fatalf("leaderboard %+v, want empty", page)
+	}
+}
+
+func TestQueryLeaderboard(t *testing.T) {
+	m := newTestRoomManager(t)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		m.services.Leaderboard.Submit(leaderboard.Entry{
+			Mode:        ModeSprint,
+			PlayerID:    id,
+			Value:       int64(60000 + i*1000),
+			SubmittedAt: time.Unix(int64(i), 0),
+		})
+	}
+
+	c := nettest.NewClient("d", m)
+	c.Leaderboard(ModeSprint, 2, 1)
+	reply := mustWait(t, c, isLeaderboard).GetS2CLeaderboard()
+	if reply.GetError() || len(reply.GetTop()) != 2 || reply.GetTop()[0].GetPlayerId() != "a" || reply.GetTotal() != 5 {
+		t.Fatalf("got %v, want the top 2 of 5", reply)
+	}
+	if reply.GetSeason().GetId() != 1 || len(reply.GetSeasons()) != 1 || reply.GetSeasons()[0].GetArchived() {
+		t.Fatalf("got season %v in %v, want the current season 1", reply.GetSeason(), reply.GetSeasons())
+	}
+	around := reply.GetAround()
+	if len(around) != 3 || around[0].GetPlayerId() != "c" || around[1].GetRank() != 4 || around[2].GetPlayerId() != "e" {
+		t.Fatalf("around = %v, want c, d, e", around)
+	}
+
+	c.LeaderboardPage(ModeSprint, 1, 4, 10, 0)
+	reply = mustWait(t, c, isLeaderboard).GetS2CLeaderboard()
+	if len(reply.GetTop()) != 1 || reply.GetTop()[0].GetRank() != 5 || len(reply.GetAround()) != 0 {
+		t.Fatalf("got %v, want only rank 5 on the last page", reply)
+	}
+
+	c.Leaderboard(ModeVersus, 10, 0)
+	if reply := mustWait(t, c, isLeaderboard).GetS2CLeaderboard(); !reply.GetError() {
+		t.Fatal("queried a versus leaderboard, want error")
+	}
+	c.LeaderboardPage(ModeSprint, 9, 0, 10, 0)
+	if reply := mustWait(t, c, isLeaderboard).GetS2CLeaderboard(); reply.GetErrorMsg() != "Season not found" {
+		t.Fatalf("got %v, want season not found", reply)
+	}
+
+	// 成绩由服务器模拟判定，单人模式需要开启输入校验
+	c.CreateRoomWithMode(ModeSprint)
+	if reply := mustWait(t, c, isCreateRoom).GetS2CCreateRoom(); reply.GetErrorMsg() != "Game mode requires input validation" {
+		t.Fatalf("got %v, want sprint rejected without input validation", reply)
+	}
+	if err := m.ApplySettings(Settings{ValidateInput: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 单人模式的房间只能有一个玩家
+	roomID := createModeRoom(t, c, ModeSprint)
+	other := nettest.NewClient("f", m)
+	other.EnterRoom(roomID)
+	if reply := mustWait(t, other, isEnterRoom).GetS2CEnterRoom(); !reply.GetError() {
+		t.Fatal("second player entered a sprint room")
+	}
+}
@@ -0,0 +1,62 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"slices"
+	"sort"
+	"time"
+)
+
+// liveStats 玩家在服务器模拟中的本局统计，APM和PPS按玩家的帧数计算
+func (g *Game) liveStats(p *GamePlayer) *pb.LiveStats {
+	stats := p.check.sim.Stats()
+	live := &pb.LiveStats{
+		PlayerId:      p.playerID,
+		Frames:        stats.Frames,
+		Pieces:        int32(stats.Pieces),
+		Lines:         int32(stats.Lines),
+		LinesSent:     int32(stats.Attack),
+		LinesReceived: int32(stats.Received),
+		Combo:         int32(p.check.sim.Combo()),
+		MaxCombo:      int32(stats.MaxCombo),
+		Finesse:       int32(stats.Finesse),
+	}
+	if played := g.interval * time.Duration(stats.Frames); played > 0 {
+		live.Apm = float32(float64(stats.Attack) / played.Minutes())
+		live.Pps = float32(float64(stats.Pieces) / played.Seconds())
+	}
+	return live
+}
+
+// gameStats 所有参加比赛的玩家的统计，按玩家ID排序，游戏还没有开始或不使用模拟时为nil
+func (g *Game) gameStats() []*pb.LiveStats {
+	if g.replay == nil || !g.simulated() {
+		return nil
+	}
+	finished, alive := g.participants()
+	var stats []*pb.LiveStats
+	for _, p := range slices.Concat(finished, alive) {
+		stats = append(stats, g.liveStats(p))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].PlayerId < stats[j].PlayerId })
+	return stats
+}
+
+// broadcastStats 向所有玩家广播第frame帧之后的统计，发送队列已满时放弃，之后的广播会带上最新的统计
+func (g *Game) broadcastStats(frame int32) {
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CGameStats{
+			S2CGameStats: &pb.S2C_GameStats{FrameNumber: frame, Players: g.gameStats()},
+		},
+	}
+	for _, p := range g.players {
+		select {
+		case p.conn.SendChan() <- msg:
+		default:
+			metrics.SendDrops.WithLabelValues("game_stats").Inc()
+			g.log.Debug("Failed to send game stats", logging.KeyPlayer, p.playerID, "frame", frame)
+		}
+	}
+}
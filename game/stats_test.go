@@ -0,0 +1,60 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	pb "TetrisSvr/proto"
+	"testing"
+)
+
+func isGameStats(m *pb.MessageWrapper) bool { return m.GetS2CGameStats() != nil }
+
+func TestBroadcastStats(t *testing.T) {
+	clock, clients := startClockedGame(t, ModeVersus, Settings{StatsInterval: 2, ValidateInput: true}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 左右来回移动是多余的操作
+	clients[0].Input(ops(tetris.OpMoveLeft, tetris.OpMoveRight, tetris.OpHardDrop))
+	waitForMessages(t, game)
+	for i := 0; i < 2; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[1], isSyncFrames)
+	}
+	for _, c := range clients {
+		msg := mustWait(t, c, isGameStats).GetS2CGameStats()
+		players := msg.GetPlayers()
+		if msg.GetFrameNumber() != 1 || len(players) != 2 || players[0].GetPlayerId() != "p1" {
+			t.Fatalf("got %v, want stats of both players after frame 1", msg)
+		}
+		p1 := players[0]
+		if p1.GetPieces() != 1 || p1.GetFrames() != 2 || p1.GetFinesse() != 1 || p1.GetCombo() != -1 || p1.GetPps() != 15 {
+			t.Fatalf("p1 stats %v", p1)
+		}
+	}
+
+	// 结束消息附带最终的统计
+	clients[1].GameEnd(true, nil)
+	end := mustWait(t, clients[0], isGameEnd).GetS2CGameEnd()
+	if stats := end.GetStats(); len(stats) != 2 || stats[0].GetPieces() != 1 || stats[1].GetPlayerId() != "p2" {
+		t.Fatalf("game end stats %v", stats)
+	}
+}
+
+func TestNoStatsWithoutValidation(t *testing.T) {
+	// 客户端没有实现服务器的规则时，模拟的统计与客户端不一致，不发送
+	clock, clients := startClockedGame(t, ModeVersus, Settings{StatsInterval: 1}, "p1", "p2")
+
+	for i := 0; i < 2; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[1], isSyncFrames)
+	}
+	clients[1].GameEnd(true, nil)
+	end := mustWait(t, clients[0], isGameEnd).GetS2CGameEnd()
+	if stats := end.GetStats(); stats != nil {
+		t.Fatalf("game end stats %v without input validation", stats)
+	}
+	for _, msg := range clients[0].Received() {
+		if isGameStats(msg) {
+			t.Fatalf("got %v without input validation", msg)
+		}
+	}
+}
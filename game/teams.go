@@ -0,0 +1,113 @@
+package game
+
+import (
+	"TetrisSvr/logging"
+	pb "TetrisSvr/proto"
+	"fmt"
+	"sort"
+)
+
+// teamCount 团队模式的队伍数
+const teamCount = 2
+
+// teamMatch 团队模式的队伍和名次，只在游戏协程中使用
+type teamMatch struct {
+	// members 开始游戏时每个玩家所在的队伍，被移出游戏的玩家仍然保留
+	members map[string]int32
+	// places 已经确定的队伍名次，队员全部结束的队伍按顺序获得名次
+	places map[int32]int32
+}
+
+// setTeams 在游戏开始前设置队伍，队伍随比赛设置发给所有玩家
+func (g *Game) setTeams(teams map[string]int32) {
+	g.team = &teamMatch{
+		members: teams,
+		places:  make(map[int32]int32),
+	}
+	g.setup.Teams = teams
+}
+
+// teammates 两个玩家是否在同一队伍
+func (g *Game) teammates(a, b string) bool {
+	return g.team != nil && g.team.members[a] == g.team.members[b]
+}
+
+// teamMembers 每个队伍的队员，按ID排序
+func (t *teamMatch) teamMembers() map[int32][]string {
+	members := make(map[int32][]string)
+	for id, team := range t.members {
+		members[team] = append(members[team], id)
+	}
+	for _, ids := range members {
+		sort.Strings(ids)
+	}
+	return members
+}
+
+// checkTeams 在玩家结束后检查队伍，队员全部结束的队伍被淘汰，只剩一个队伍时游戏结束
+func (g *Game) checkTeams() {
+	alive := make(map[int32]bool)
+	for id, p := range g.players {
+		if !p.ended {
+			alive[g.team.members[id]] = true
+		}
+	}
+	for team := int32(1); team <= teamCount; team++ {
+		if !alive[team] && g.team.places[team] == 0 {
+			g.team.places[team] = int32(len(alive)) + 1
+			g.log.Info("Team eliminated", "team", team, "place", g.team.places[team])
+			if g.replay != nil {
+				g.replay.recordEvent(ReplayEvent{
+					Frame:     g.frameNumber,
+					Type:      ReplayEventElimination,
+					FromFrame: g.frameNumber,
+					ToFrame:   g.frameNumber,
+					Players:   g.team.teamMembers()[team],
+					Detail:    fmt.Sprintf("team %d place %d", team, g.team.places[team]),
+				})
+			}
+		}
+	}
+	if len(alive) > 1 {
+		return
+	}
+	winner := int32(0)
+	for team := range alive {
+		winner = team
+		g.team.places[team] = 1
+	}
+	g.finishTeams(winner)
+}
+
+// finishTeams 更新积分并广播队伍的结果，winner为0表示没有获胜的队伍
+// 加载阶段就结束的游戏不影响积分
+func (g *Game) finishTeams(winner int32) {
+	members := g.team.teamMembers()
+	var changes map[int32]int32
+	if g.status != WaitingGame {
+		var err error
+		if changes, err = g.services.Ratings.applyTeams(members, g.team.places); err != nil {
+			g.log.Error("Failed to save ratings", logging.KeyError, err)
+		}
+	}
+	end := &pb.S2C_GameEnd{EndGame: true, WinningTeam: winner}
+	for team := int32(1); team <= teamCount; team++ {
+		result := &pb.TeamResult{
+			Team:         team,
+			PlayerIds:    members[team],
+			Place:        g.team.places[team],
+			RatingChange: changes[team],
+			Ratings:      make(map[string]int32, len(members[team])),
+		}
+		for _, id := range members[team] {
+			result.Ratings[id] = g.services.Ratings.Get(id)
+		}
+		end.Teams = append(end.Teams, result)
+	}
+	g.log.Info("Team game finished", "winning_team", winner)
+	if g.replay != nil {
+		g.replay.WinningTeam = winner
+	}
+	g.broadcastGameEnd(end)
+	g.endGame()
+}
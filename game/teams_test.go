@@ -0,0 +1,133 @@
+package game
+
+import (
+	"TetrisSvr/history"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func isSetTeam(m *pb.MessageWrapper) bool { return m.GetS2CSetTeam() != nil }
+
+func TestRatingsApplyTeams(t *testing.T) {
+	store := history.NewMemoryStore()
+	r, _ := NewRatings(store)
+	changes, err := r.applyTeams(map[int32][]string{1: {"a", "b"}, 2: {"c"}}, map[int32]int32{1: 1, 2: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes[1] != 16 || changes[2] != -16 {
+		t.Fatalf("got %v, want +16/-16 for equal teams", changes)
+	}
+	if r.Get("a") != DefaultRating+16 || r.Get("c") != DefaultRating-16 {
+		t.Fatalf("ratings a=%d c=%d", r.Get("a"), r.Get("c"))
+	}
+
+	// 积分高的队伍获胜时变化更小
+	changes, _ = r.applyTeams(map[int32][]string{1: {"a"}, 2: {"c"}}, map[int32]int32{1: 1, 2: 2})
+	if changes[1] <= 0 || changes[1] >= 16 || changes[1] != -changes[2] {
+		t.Fatalf("got %v, want a smaller change for the favorite", changes)
+	}
+
+	// 积分写入比赛记录，重新读取后仍然存在
+	reloaded, err := NewRatings(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if reloaded.Get(id) != r.Get(id) {
+			t.Fatalf("reloaded %s rating %d, want %d", id, reloaded.Get(id), r.Get(id))
+		}
+	}
+}
+
+func TestAssignTeams(t *testing.T) {
+	m := newTestRoomManager(t)
+	clients := []*nettest.Client{
+		nettest.NewClient("p1", m), nettest.NewClient("p2", m),
+		nettest.NewClient("p3", m), nettest.NewClient("p4", m),
+	}
+	roomID := createModeRoom(t, clients[0], ModeTeams)
+	for _, c := range clients[1:] {
+		enterRoom(t, c, roomID)
+	}
+
+	// 只有房主可以分配队伍
+	clients[1].SetTeam(roomID, "p2", 1)
+	if reply := mustWait(t, clients[1], isSetTeam).GetS2CSetTeam(); !reply.GetError() {
+		t.Fatal("guest assigned a team, want error")
+	}
+	clients[0].SetTeam(roomID, "p2", 1)
+	if reply := mustWait(t, clients[0], isSetTeam).GetS2CSetTeam(); reply.GetError() {
+		t.Fatalf("host failed to assign a team: %s", reply.GetErrorMsg())
+	}
+	for _, c := range clients {
+		for {
+			info := mustWait(t, c, isRoomInfoChanged).GetS2CRoomInfoChanged()
+			if len(info.GetTeams()) == 0 {
+				continue
+			}
+			if info.GetTeams()["p2"] != 1 || info.GetHostId() != "p1" {
+				t.Fatalf("%s: got %v, want p2 in team 1 hosted by p1", c.PlayerID, info)
+			}
+			break
+		}
+	}
+
+	// 其余玩家按ID顺序加入人数最少的队伍
+	clients[0].StartGame(roomID)
+	want := map[string]int32{"p1": 2, "p2": 1, "p3": 1, "p4": 2}
+	for _, c := range clients {
+		setup := mustWait(t, c, isStartGame).GetS2CStartGame().GetSetup()
+		if setup.GetMode() != ModeTeams || !maps.Equal(setup.GetTeams(), want) {
+			t.Fatalf("%s: got %v, want teams %v", c.PlayerID, setup, want)
+		}
+	}
+}
+
+func TestTeamGame(t *testing.T) {
+	_, clients := startClockedGame(t, ModeTeams, Settings{}, "p1", "p2", "p3", "p4")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 自动分队后p1和p3、p2和p4为队友，攻击只发给对手
+	var opponents []string
+	game.run(func() { opponents = game.opponents("p1") })
+	if !slices.Equal(opponents, []string{"p2", "p4"}) {
+		t.Fatalf("p1 opponents %v, want [p2 p4]", opponents)
+	}
+
+	// 队伍中还有存活的队员时比赛继续
+	clients[1].GameEnd(false, nil)
+	for _, c := range clients {
+		if end := mustWait(t, c, isGameEnd).GetS2CGameEnd(); end.GetEndGame() || end.GetEndPlayer() != "p2" {
+			t.Fatalf("%s: got %v, want only p2 ended", c.PlayerID, end)
+		}
+	}
+	clients[3].GameEnd(false, nil)
+	for _, c := range clients {
+		var end *pb.S2C_GameEnd
+		for end == nil || !end.GetEndGame() {
+			end = mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		}
+		if end.GetWinningTeam() != 1 || len(end.GetTeams()) != 2 {
+			t.Fatalf("%s: got %v, want team 1 to win", c.PlayerID, end)
+		}
+		for _, result := range end.GetTeams() {
+			wantPlace, wantChange := int32(1), int32(16)
+			if result.GetTeam() == 2 {
+				wantPlace, wantChange = 2, -16
+			}
+			if result.GetPlace() != wantPlace || result.GetRatingChange() != wantChange {
+				t.Fatalf("%s: team %d got %v", c.PlayerID, result.GetTeam(), result)
+			}
+			for _, id := range result.GetPlayerIds() {
+				if result.GetRatings()[id] != DefaultRating+wantChange {
+					t.Fatalf("%s: %s rating %d", c.PlayerID, id, result.GetRatings()[id])
+				}
+			}
+		}
+	}
+	assertGameOver(t, game)
+}
@@ -0,0 +1,61 @@
+package tetris
+
+// rng 确定性随机数生成器(splitmix64)
+// 客户端需要使用相同的算法，不能依赖各语言标准库的随机数
+type rng struct {
+	state uint64
+}
+
+func newRNG(seed int64) *rng {
+	return &rng{state: uint64(seed)}
+}
+
+func (r *rng) next() uint64 {
+	r.state += 0x9E3779B97F4A7C15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// intn 返回[0, n)中的数
+func (r *rng) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// bag 7-bag随机器，每包7种方块各出现一次
+// 每包按IOTSZJL的顺序用Fisher-Yates洗牌：i从6到1，与intn(i+1)交换
+type bag struct {
+	rng   *rng
+	queue []Piece
+}
+
+func newBag(seed int64) *bag {
+	return &bag{rng: newRNG(seed)}
+}
+
+func (b *bag) refill() {
+	var pieces [pieceCount]Piece
+	for i := range pieces {
+		pieces[i] = Piece(i + 1)
+	}
+	for i := pieceCount - 1; i > 0; i-- {
+		j := b.rng.intn(i + 1)
+		pieces[i], pieces[j] = pieces[j], pieces[i]
+	}
+	b.queue = append(b.queue, pieces[:]...)
+}
+
+// peek 返回接下来的n个方块，不足时补充新的一包
+func (b *bag) peek(n int) []Piece {
+	for len(b.queue) < n {
+		b.refill()
+	}
+	return b.queue[:n]
+}
+
+func (b *bag) pop() Piece {
+	p := b.peek(1)[0]
+	b.queue = b.queue[1:]
+	return p
+}
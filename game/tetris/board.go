@@ -0,0 +1,147 @@
+package tetris
+
+import "strings"
+
+const (
+	Width  = 10
+	Height = 40
+	// Visible 可见的行数，之上的行只用于出生和缓冲
+	Visible = 20
+)
+
+// Garbage 垃圾行中的格子
+const Garbage Piece = pieceCount + 1
+
+// Board 棋盘，第0行在最下面
+type Board struct {
+	cells [Height][Width]Piece
+}
+
+// Cell 返回格子中的方块，超出棋盘时返回PieceNone
+func (b *Board) Cell(x, y int) Piece {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return PieceNone
+	}
+	return b.cells[y][x]
+}
+
+// occupied 棋盘外的格子视为已占用
+func (b *Board) occupied(x, y int) bool {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return true
+	}
+	return b.cells[y][x] != PieceNone
+}
+
+func (b *Board) fits(a Active) bool {
+	for _, c := range a.Cells() {
+		if b.occupied(c[0], c[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// rotate 按SRS依次尝试踢墙偏移，返回旋转后的位置和使用的偏移序号，都放不下时返回false
+func (b *Board) rotate(a Active, to Rotation) (Active, int, bool) {
+	for i, k := range kickTable(a.Piece, a.Rotation, to) {
+		rotated := a
+		rotated.Rotation = to
+		rotated.X += k.x
+		rotated.Y += k.y
+		if b.fits(rotated) {
+			return rotated, i, true
+		}
+	}
+	return a, 0, false
+}
+
+func (b *Board) place(a Active) {
+	for _, c := range a.Cells() {
+		b.cells[c[1]][c[0]] = a.Piece
+	}
+}
+
+// clearLines 消除填满的行，返回消除的行数
+func (b *Board) clearLines() int {
+	cleared := 0
+	for y := 0; y < Height; y++ {
+		full := true
+		for x := 0; x < Width; x++ {
+			if b.cells[y][x] == PieceNone {
+				full = false
+				break
+			}
+		}
+		if full {
+			cleared++
+			continue
+		}
+		if cleared > 0 {
+			b.cells[y-cleared] = b.cells[y]
+		}
+	}
+	for y := Height - cleared; y < Height; y++ {
+		b.cells[y] = [Width]Piece{}
+	}
+	return cleared
+}
+
+// addGarbage 从底部加入lines行垃圾，hole列为空
+// 有方块被推出棋盘时返回false
+func (b *Board) addGarbage(lines, hole int) bool {
+	lines = min(lines, Height)
+	ok := true
+	for y := Height - lines; y < Height; y++ {
+		if b.cells[y] != ([Width]Piece{}) {
+			ok = false
+		}
+	}
+	copy(b.cells[lines:], b.cells[:Height-lines])
+	for y := 0; y < lines; y++ {
+		for x := range b.cells[y] {
+			b.cells[y][x] = Garbage
+		}
+		if hole >= 0 && hole < Width {
+			b.cells[y][hole] = PieceNone
+		}
+	}
+	return ok
+}
+
+// Heights 每列最高的方块之上的行号，空列为0
+func (b *Board) Heights() [Width]int {
+	var heights [Width]int
+	for x := 0; x < Width; x++ {
+		for y := Height - 1; y >= 0; y-- {
+			if b.cells[y][x] != PieceNone {
+				heights[x] = y + 1
+				break
+			}
+		}
+	}
+	return heights
+}
+
+func (b *Board) empty() bool {
+	return b.cells == [Height][Width]Piece{}
+}
+
+// String 可见部分的文本表示，最上面一行在前，空格为'.'，垃圾为'#'
+func (b *Board) String() string {
+	var sb strings.Builder
+	for y := Visible - 1; y >= 0; y-- {
+		for x := 0; x < Width; x++ {
+			switch p := b.cells[y][x]; p {
+			case PieceNone:
+				sb.WriteByte('.')
+			case Garbage:
+				sb.WriteByte('#')
+			default:
+				sb.WriteString(p.String())
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
@@ -0,0 +1,95 @@
+package tetris
+
+import (
+	"math"
+	"slices"
+)
+
+// 机器人评估落点的权重：各列高度之和、消除的行数、空洞数和相邻列的高度差之和
+const (
+	weightHeight    = -0.51
+	weightLines     = 0.76
+	weightHoles     = -0.36
+	weightBumpiness = -0.18
+	// weightLockOut 有格子锁定在可见区域之外的落点，只在没有其他选择时使用
+	weightLockOut = -1000
+)
+
+// turns 从当前朝向转到各个朝向的旋转操作
+var turns = [][]Op{nil, {OpRotateCW}, {OpRotateCW, OpRotateCW}, {OpRotateCCW}}
+
+// Plan 为当前方块选择落点，返回在一帧中到达落点的操作，最后一个操作为硬降
+// 只考虑先旋转、再平移、然后硬降能到达的落点，不使用暂存；游戏结束后返回nil
+func (g *Game) Plan() []Op {
+	if g.over {
+		return nil
+	}
+	var best []Op
+	bestScore := math.Inf(-1)
+	for _, turn := range turns {
+		a := g.active
+		for _, op := range turn {
+			to := a.Rotation.cw()
+			if op == OpRotateCCW {
+				to = a.Rotation.ccw()
+			}
+			a, _, _ = g.board.rotate(a, to)
+		}
+		for _, move := range []Op{OpMoveLeft, OpMoveRight} {
+			dx := -1
+			if move == OpMoveRight {
+				dx = 1
+			}
+			ops := slices.Clone(turn)
+			for at := a; ; {
+				if score := g.board.evaluate(at); score > bestScore {
+					bestScore = score
+					best = append(slices.Clone(ops), OpHardDrop)
+				}
+				at.X += dx
+				if !g.board.fits(at) {
+					break
+				}
+				ops = append(ops, move)
+			}
+		}
+	}
+	return best
+}
+
+// evaluate 方块a硬降并消行后棋盘的得分，越大越好
+func (b *Board) evaluate(a Active) float64 {
+	for {
+		down := a
+		down.Y--
+		if !b.fits(down) {
+			break
+		}
+		a = down
+	}
+	after := *b
+	after.place(a)
+	score := 0.0
+	for _, c := range a.Cells() {
+		if c[1] >= Visible {
+			score += weightLockOut
+			break
+		}
+	}
+	score += weightLines * float64(after.clearLines())
+
+	heights := after.Heights()
+	height, holes, bumpiness := 0, 0, 0
+	for x, h := range heights {
+		height += h
+		if x > 0 {
+			bumpiness += abs(h - heights[x-1])
+		}
+		for y := 0; y < h; y++ {
+			if after.cells[y][x] == PieceNone {
+				holes++
+			}
+		}
+	}
+	return score + weightHeight*float64(height) + weightHoles*float64(holes) + weightBumpiness*float64(bumpiness)
+}
@@ -0,0 +1,48 @@
+package tetris
+
+import "slices"
+
+// spawnX 方块出生时包围盒的列
+const spawnX = 3
+
+// rotationInputs 从出生朝向转到各个朝向最少需要的旋转次数
+var rotationInputs = [4]int{Rotation0: 0, RotationR: 1, Rotation2: 2, RotationL: 1}
+
+// footprint 格子平移到左下角后的形状和最左边的列
+func footprint(cells [4][2]int) ([4][2]int, int) {
+	minX, minY := cells[0][0], cells[0][1]
+	for _, c := range cells[1:] {
+		minX, minY = min(minX, c[0]), min(minY, c[1])
+	}
+	for i := range cells {
+		cells[i][0] -= minX
+		cells[i][1] -= minY
+	}
+	slices.SortFunc(cells[:], func(a, b [2]int) int { return (a[1]*Width + a[0]) - (b[1]*Width + b[0]) })
+	return cells, minX
+}
+
+// minInputs 在空中从出生位置移动到与a占据相同列和形状的位置最少需要的移动和旋转次数
+// I、S、Z和O的不同朝向可能得到相同的形状，取其中最少的一个
+func minInputs(a Active) int {
+	want, left := footprint(a.Cells())
+	best := -1
+	for r := Rotation0; r <= RotationL; r++ {
+		shape, x := footprint(Active{Piece: a.Piece, Rotation: r}.Cells())
+		if shape != want {
+			continue
+		}
+		n := rotationInputs[r] + abs(left-x-spawnX)
+		if best < 0 || n < best {
+			best = n
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
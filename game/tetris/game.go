@@ -0,0 +1,546 @@
+// Package tetris 服务器端的俄罗斯方块规则：棋盘、7-bag随机器、SRS旋转、锁定、消行、计分和垃圾行
+//
+// 这些规则和C2S_Input.operations中的操作编码由服务器定义，不是从现有的客户端移植的，
+// 也没有用客户端产生的对局核对过，因此是客户端协议的一部分：只有实现了相同版本规则的客户端，
+// 服务器的模拟才与客户端的棋盘一致。服务器只在运营者开启game.validate_input、确认所有客户端都实现了
+// 该版本时才使用模拟的结果。修改随机器、操作编码、旋转、锁定或计分中的任何一项都必须增加RulesVersion，
+// 并与对应的客户端一起发布。
+package tetris
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RulesVersion 本包实现的规则和操作编码的版本，开启game.validate_input时开始游戏通过MatchSetup.rules_version发给客户端
+//
+//	1：splitmix64驱动的7-bag（每包按IOTSZJL顺序做Fisher-Yates洗牌），Op编码1到7，
+//	   SRS踢墙和三角T旋判定，本包的计分、锁定延迟和攻击表
+const RulesVersion = 1
+
+// Op 玩家的一个操作，C2S_Input.operations中每个字节为一个Op
+type Op uint8
+
+const (
+	OpMoveLeft Op = iota + 1
+	OpMoveRight
+	OpSoftDrop
+	OpHardDrop
+	OpRotateCW
+	OpRotateCCW
+	OpHold
+)
+
+var ErrUnknownOp = errors.New("unknown operation")
+
+// DecodeOps 解析一条输入中的操作
+func DecodeOps(data []byte) ([]Op, error) {
+	ops := make([]Op, len(data))
+	for i, b := range data {
+		op := Op(b)
+		if op < OpMoveLeft || op > OpHold {
+			return nil, fmt.Errorf("%w: %d at %d", ErrUnknownOp, b, i)
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+// EncodeOps 把操作编码为C2S_Input.operations
+func EncodeOps(ops []Op) []byte {
+	data := make([]byte, len(ops))
+	for i, op := range ops {
+		data[i] = byte(op)
+	}
+	return data
+}
+
+// GravityStep 从第Frame帧开始，方块自然下落一格需要FramesPerRow帧
+type GravityStep struct {
+	Frame        int32
+	FramesPerRow int
+}
+
+// Config 与帧率相关的规则参数，单位都是帧
+type Config struct {
+	// Gravity 方块自然下落一格需要的帧数
+	Gravity int
+	// GravityCurve 按帧号升序排列，到达某一项的帧号后用其代替Gravity
+	GravityCurve []GravityStep
+	// LockDelay 方块落地后经过多少帧锁定
+	LockDelay int
+	// MaxLockResets 落地后移动或旋转最多重置锁定计时的次数
+	MaxLockResets int
+	// Previews 可以预览的后续方块数
+	Previews int
+	// CancelGarbage 消行的攻击是否先抵消待收的垃圾
+	CancelGarbage bool
+	// Attack 消行产生的攻击行数
+	Attack AttackTable
+}
+
+// AttackTable 攻击表，按消行数或连击次数取值，超出长度的取最后一项
+type AttackTable struct {
+	// Lines 普通消0到4行
+	Lines []int `json:"lines"`
+	// TSpin和TSpinMini T旋消0到3行
+	TSpin     []int `json:"t_spin"`
+	TSpinMini []int `json:"t_spin_mini"`
+	// Combo 第n次连续消行额外的攻击，第一次消行为第0项
+	Combo []int `json:"combo"`
+	// BackToBack 连续两次四消或T旋消行的额外攻击，PerfectClear 全消的额外攻击
+	BackToBack   int `json:"back_to_back"`
+	PerfectClear int `json:"perfect_clear"`
+}
+
+// DefaultAttackTable 常见的对战攻击表
+func DefaultAttackTable() AttackTable {
+	return AttackTable{
+		Lines:        []int{0, 0, 1, 2, 4},
+		TSpin:        []int{0, 2, 4, 6},
+		TSpinMini:    []int{0, 0, 1},
+		Combo:        []int{0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 4, 5},
+		BackToBack:   1,
+		PerfectClear: 10,
+	}
+}
+
+// lookup 取table[i]，超出长度时取最后一项，表为空时为0
+func lookup(table []int, i int) int {
+	if len(table) == 0 {
+		return 0
+	}
+	return table[min(i, len(table)-1)]
+}
+
+// DefaultConfig 30帧每秒时的默认参数
+func DefaultConfig() Config {
+	return Config{
+		Gravity:       30,
+		LockDelay:     15,
+		MaxLockResets: 15,
+		Previews:      5,
+		CancelGarbage: true,
+		Attack:        DefaultAttackTable(),
+	}
+}
+
+// gravityAt 第frame帧的重力
+func (c *Config) gravityAt(frame int32) int {
+	gravity := c.Gravity
+	for _, step := range c.GravityCurve {
+		if step.Frame > frame {
+			break
+		}
+		gravity = step.FramesPerRow
+	}
+	return gravity
+}
+
+// TSpin T旋的种类
+type TSpin uint8
+
+const (
+	TSpinNone TSpin = iota
+	TSpinMini
+	TSpinFull
+)
+
+// Event 方块锁定时产生的事件
+type Event struct {
+	Frame int32
+	Piece Piece
+	Lines int
+	TSpin TSpin
+	// Combo 连续消行的次数，第一次消行为0，没有消行为-1
+	Combo        int
+	BackToBack   bool
+	PerfectClear bool
+	// Attack 这次消行产生的攻击行数，Sent为抵消待收垃圾后实际发出的行数
+	Attack int
+	Sent   int
+	// Garbage 这次锁定后升起的垃圾行数
+	Garbage   int
+	ToppedOut bool
+}
+
+// Stats 累计的统计数据
+type Stats struct {
+	Frames  int32
+	Pieces  int
+	Lines   int
+	Score   int
+	Attack  int
+	TSpins  int
+	Garbage int
+	// Received 收到的攻击行数，包括被抵消的
+	Received int
+	// MaxCombo 最大的连续消行次数，第一次消行为0，没有消过行为-1
+	MaxCombo int
+	// Finesse 移动和旋转多于最少次数的方块数，软降或T旋放置的方块不计
+	Finesse int
+}
+
+type pendingGarbage struct {
+	lines, hole int
+}
+
+// Game 一个玩家的确定性模拟
+// 相同的种子、参数和逐帧操作总是得到相同的结果，非并发安全
+type Game struct {
+	config Config
+	board  Board
+	bag    *bag
+	active Active
+	hold   Piece
+	// holdUsed 当前方块已经暂存过，锁定后才能再次暂存
+	holdUsed bool
+	pending  []pendingGarbage
+
+	gravity    int
+	lockFrames int
+	lockResets int
+	lowest     int
+	// lastRotate 最后一次成功的动作是旋转，lastKick为其使用的踢墙序号
+	lastRotate bool
+	lastKick   int
+	combo      int
+	b2b        bool
+	// inputs 当前方块的移动和旋转次数，softDropped 当前方块是否软降过
+	inputs      int
+	softDropped bool
+
+	over   bool
+	frame  int32
+	stats  Stats
+	events []Event
+}
+
+// NewGame 用种子初始化7-bag并生成第一个方块
+func NewGame(seed int64, config Config) *Game {
+	g := &Game{
+		config: config,
+		bag:    newBag(seed),
+		combo:  -1,
+		stats:  Stats{MaxCombo: -1},
+	}
+	g.spawn(g.bag.pop())
+	return g
+}
+
+// Step 执行一帧：按顺序处理ops，然后处理重力和锁定，返回这一帧中锁定方块产生的事件
+func (g *Game) Step(ops ...Op) []Event {
+	if g.over {
+		return nil
+	}
+	g.events = nil
+	for _, op := range ops {
+		if g.over {
+			break
+		}
+		g.apply(op)
+	}
+	if !g.over {
+		g.fall()
+	}
+	g.frame++
+	g.stats.Frames = g.frame
+	return g.events
+}
+
+func (g *Game) apply(op Op) {
+	switch op {
+	case OpMoveLeft:
+		g.shift(-1)
+	case OpMoveRight:
+		g.shift(1)
+	case OpSoftDrop:
+		g.softDropped = true
+		if g.moveDown() {
+			g.stats.Score++
+			g.gravity = 0
+		}
+	case OpHardDrop:
+		for g.moveDown() {
+			g.stats.Score += 2
+		}
+		g.lock()
+	case OpRotateCW:
+		g.rotate(g.active.Rotation.cw())
+	case OpRotateCCW:
+		g.rotate(g.active.Rotation.ccw())
+	case OpHold:
+		g.swapHold()
+	}
+}
+
+// fall 处理重力，方块落地后开始锁定计时
+func (g *Game) fall() {
+	g.gravity++
+	if g.gravity >= g.config.gravityAt(g.frame) {
+		g.gravity = 0
+		g.moveDown()
+	}
+	if g.grounded() {
+		g.lockFrames++
+		if g.lockFrames >= g.config.LockDelay {
+			g.lock()
+		}
+	} else {
+		g.lockFrames = 0
+	}
+}
+
+func (g *Game) grounded() bool {
+	down := g.active
+	down.Y--
+	return !g.board.fits(down)
+}
+
+func (g *Game) moveDown() bool {
+	down := g.active
+	down.Y--
+	if !g.board.fits(down) {
+		return false
+	}
+	g.active = down
+	g.lastRotate = false
+	if down.Y < g.lowest {
+		g.lowest = down.Y
+		g.lockResets = 0
+	}
+	return true
+}
+
+func (g *Game) shift(dx int) {
+	g.inputs++
+	moved := g.active
+	moved.X += dx
+	if !g.board.fits(moved) {
+		return
+	}
+	g.active = moved
+	g.lastRotate = false
+	g.resetLock()
+}
+
+func (g *Game) rotate(to Rotation) {
+	g.inputs++
+	if rotated, kick, ok := g.board.rotate(g.active, to); ok {
+		g.active = rotated
+		g.lastRotate = true
+		g.lastKick = kick
+		g.resetLock()
+	}
+}
+
+// resetLock 落地后移动或旋转成功时重置锁定计时，次数有限
+func (g *Game) resetLock() {
+	if g.lockFrames > 0 && g.lockResets < g.config.MaxLockResets {
+		g.lockFrames = 0
+		g.lockResets++
+	}
+}
+
+func (g *Game) swapHold() {
+	if g.holdUsed {
+		return
+	}
+	next := g.hold
+	g.hold = g.active.Piece
+	if next == PieceNone {
+		next = g.bag.pop()
+	}
+	g.spawn(next)
+	g.holdUsed = true
+}
+
+// spawn 在可见区域上方生成方块，位置被占用时游戏结束
+func (g *Game) spawn(p Piece) {
+	g.active = Active{Piece: p, Rotation: Rotation0, X: spawnX, Y: Visible + 1}
+	g.inputs = 0
+	g.softDropped = false
+	g.gravity = 0
+	g.lockFrames = 0
+	g.lockResets = 0
+	g.lowest = g.active.Y
+	g.lastRotate = false
+	if !g.board.fits(g.active) {
+		g.over = true
+	}
+}
+
+// tSpin 根据T方块包围盒四个角的占用情况判断T旋
+// 三个角被占用为T旋，朝向一侧的两个角都被占用或使用了最后一个踢墙偏移时为完整T旋，否则为mini
+func (g *Game) tSpin() TSpin {
+	a := g.active
+	if a.Piece != PieceT || !g.lastRotate {
+		return TSpinNone
+	}
+	// 按row, col排列的四个角：左上、右上、右下、左下
+	corners := [4]cell{{0, 0}, {0, 2}, {2, 2}, {2, 0}}
+	var filled [4]bool
+	count := 0
+	for i, c := range corners {
+		if g.board.occupied(a.X+c.col, a.Y-c.row) {
+			filled[i] = true
+			count++
+		}
+	}
+	if count < 3 {
+		return TSpinNone
+	}
+	// 朝向为r时，朝向一侧的角为corners[r]和corners[r+1]
+	front := filled[a.Rotation] && filled[(a.Rotation+1)%4]
+	if front || g.lastKick == 4 {
+		return TSpinFull
+	}
+	return TSpinMini
+}
+
+var (
+	lineScore  = [...]int{0, 100, 300, 500, 800}
+	miniScore  = [...]int{100, 200, 400}
+	tSpinScore = [...]int{400, 800, 1200, 1600}
+)
+
+// lock 锁定当前方块，消行、计算攻击、升起垃圾并生成下一个方块
+func (g *Game) lock() {
+	a := g.active
+	spin := g.tSpin()
+	g.board.place(a)
+	lockOut := true
+	for _, c := range a.Cells() {
+		if c[1] < Visible {
+			lockOut = false
+		}
+	}
+	lines := g.board.clearLines()
+
+	event := Event{Frame: g.frame, Piece: a.Piece, Lines: lines, TSpin: spin}
+	table := &g.config.Attack
+	score := 0
+	switch spin {
+	case TSpinFull:
+		event.Attack = lookup(table.TSpin, lines)
+		score = tSpinScore[lines]
+	case TSpinMini:
+		event.Attack = lookup(table.TSpinMini, lines)
+		score = miniScore[min(lines, len(miniScore)-1)]
+	default:
+		event.Attack = lookup(table.Lines, lines)
+		score = lineScore[lines]
+	}
+	if lines > 0 {
+		difficult := lines == 4 || spin != TSpinNone
+		if difficult && g.b2b {
+			event.BackToBack = true
+			event.Attack += table.BackToBack
+			score = score * 3 / 2
+		}
+		g.b2b = difficult
+		g.combo++
+		event.Attack += lookup(table.Combo, g.combo)
+		score += 50 * g.combo
+		if g.board.empty() {
+			event.PerfectClear = true
+			event.Attack += table.PerfectClear
+		}
+	} else {
+		g.combo = -1
+	}
+	event.Combo = g.combo
+
+	// 攻击先抵消待收的垃圾(CancelGarbage)，没有消行时待收的垃圾全部升起
+	event.Sent = event.Attack
+	for g.config.CancelGarbage && event.Sent > 0 && len(g.pending) > 0 {
+		n := min(event.Sent, g.pending[0].lines)
+		event.Sent -= n
+		g.pending[0].lines -= n
+		if g.pending[0].lines == 0 {
+			g.pending = g.pending[1:]
+		}
+	}
+	if lines == 0 {
+		for _, garbage := range g.pending {
+			event.Garbage += garbage.lines
+			if !g.board.addGarbage(garbage.lines, garbage.hole) {
+				g.over = true
+			}
+		}
+		g.pending = nil
+	}
+
+	g.stats.Pieces++
+	g.stats.Lines += lines
+	g.stats.Score += score
+	g.stats.Attack += event.Sent
+	g.stats.Garbage += event.Garbage
+	g.stats.MaxCombo = max(g.stats.MaxCombo, g.combo)
+	if spin != TSpinNone {
+		g.stats.TSpins++
+	} else if !g.softDropped && g.inputs > minInputs(a) {
+		g.stats.Finesse++
+	}
+
+	g.holdUsed = false
+	if lockOut {
+		g.over = true
+	}
+	if !g.over {
+		g.spawn(g.bag.pop())
+	}
+	event.ToppedOut = g.over
+	g.events = append(g.events, event)
+}
+
+// ReceiveGarbage 收到对手的攻击，在下一次没有消行的锁定后从底部升起，hole为空缺的列
+func (g *Game) ReceiveGarbage(lines, hole int) {
+	if lines > 0 && !g.over {
+		g.pending = append(g.pending, pendingGarbage{lines: lines, hole: hole})
+		g.stats.Received += lines
+	}
+}
+
+// PendingGarbage 待升起的垃圾行数
+func (g *Game) PendingGarbage() int {
+	total := 0
+	for _, garbage := range g.pending {
+		total += garbage.lines
+	}
+	return total
+}
+
+func (g *Game) Board() *Board {
+	return &g.board
+}
+
+func (g *Game) Active() Active {
+	return g.active
+}
+
+func (g *Game) Hold() Piece {
+	return g.hold
+}
+
+// Next 接下来的Config.Previews个方块
+func (g *Game) Next() []Piece {
+	return append([]Piece(nil), g.bag.peek(g.config.Previews)...)
+}
+
+// Combo 当前的连续消行次数，上一个方块没有消行时为-1
+func (g *Game) Combo() int {
+	return g.combo
+}
+
+func (g *Game) Over() bool {
+	return g.over
+}
+
+func (g *Game) Frame() int32 {
+	return g.frame
+}
+
+func (g *Game) Stats() Stats {
+	return g.stats
+}
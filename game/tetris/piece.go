@@ -0,0 +1,133 @@
+package tetris
+
+// Piece 方块种类，0表示没有方块
+type Piece uint8
+
+const (
+	PieceNone Piece = iota
+	PieceI
+	PieceO
+	PieceT
+	PieceS
+	PieceZ
+	PieceJ
+	PieceL
+)
+
+// pieceCount 方块种类数，也是一包的大小
+const pieceCount = 7
+
+func (p Piece) String() string {
+	if p > PieceNone && p <= PieceL {
+		return string("IOTSZJL"[p-1])
+	}
+	return "-"
+}
+
+// Rotation SRS中的朝向：0为出生朝向，R为顺时针转一次，2为转两次，L为逆时针转一次
+type Rotation uint8
+
+const (
+	Rotation0 Rotation = iota
+	RotationR
+	Rotation2
+	RotationL
+)
+
+func (r Rotation) cw() Rotation  { return (r + 1) % 4 }
+func (r Rotation) ccw() Rotation { return (r + 3) % 4 }
+
+// cell 方块在包围盒中的格子，row向下增长
+type cell struct{ row, col int }
+
+// spawnShapes 出生朝向的形状，包围盒边长为len(shape)
+var spawnShapes = [...][]string{
+	PieceI: {"....", "XXXX", "....", "...."},
+	PieceO: {".XX", ".XX", "..."},
+	PieceT: {".X.", "XXX", "..."},
+	PieceS: {".XX", "XX.", "..."},
+	PieceZ: {"XX.", ".XX", "..."},
+	PieceJ: {"X..", "XXX", "..."},
+	PieceL: {"..X", "XXX", "..."},
+}
+
+// shapes[piece][rotation] 由出生朝向在包围盒中顺时针旋转得到，与SRS一致
+var shapes [pieceCount + 1][4][]cell
+
+func init() {
+	for p := PieceI; p <= PieceL; p++ {
+		rows := spawnShapes[p]
+		n := len(rows)
+		for r, line := range rows {
+			for c, ch := range line {
+				if ch == 'X' {
+					shapes[p][Rotation0] = append(shapes[p][Rotation0], cell{r, c})
+				}
+			}
+		}
+		for rot := RotationR; rot <= RotationL; rot++ {
+			for _, c := range shapes[p][rot-1] {
+				if p == PieceO {
+					shapes[p][rot] = shapes[p][Rotation0]
+					break
+				}
+				shapes[p][rot] = append(shapes[p][rot], cell{c.col, n - 1 - c.row})
+			}
+		}
+	}
+}
+
+// offset 踢墙偏移，y向上增长
+type offset struct{ x, y int }
+
+// kicks[from][to] JLSTZ的踢墙表，第一项总是不偏移
+var kicks = map[[2]Rotation][]offset{
+	{Rotation0, RotationR}: {{0, 0}, {-1, 0}, {-1, 1}, {0, -2}, {-1, -2}},
+	{RotationR, Rotation0}: {{0, 0}, {1, 0}, {1, -1}, {0, 2}, {1, 2}},
+	{RotationR, Rotation2}: {{0, 0}, {1, 0}, {1, -1}, {0, 2}, {1, 2}},
+	{Rotation2, RotationR}: {{0, 0}, {-1, 0}, {-1, 1}, {0, -2}, {-1, -2}},
+	{Rotation2, RotationL}: {{0, 0}, {1, 0}, {1, 1}, {0, -2}, {1, -2}},
+	{RotationL, Rotation2}: {{0, 0}, {-1, 0}, {-1, -1}, {0, 2}, {-1, 2}},
+	{RotationL, Rotation0}: {{0, 0}, {-1, 0}, {-1, -1}, {0, 2}, {-1, 2}},
+	{Rotation0, RotationL}: {{0, 0}, {1, 0}, {1, 1}, {0, -2}, {1, -2}},
+}
+
+// kicksI I的踢墙表
+var kicksI = map[[2]Rotation][]offset{
+	{Rotation0, RotationR}: {{0, 0}, {-2, 0}, {1, 0}, {-2, -1}, {1, 2}},
+	{RotationR, Rotation0}: {{0, 0}, {2, 0}, {-1, 0}, {2, 1}, {-1, -2}},
+	{RotationR, Rotation2}: {{0, 0}, {-1, 0}, {2, 0}, {-1, 2}, {2, -1}},
+	{Rotation2, RotationR}: {{0, 0}, {1, 0}, {-2, 0}, {1, -2}, {-2, 1}},
+	{Rotation2, RotationL}: {{0, 0}, {2, 0}, {-1, 0}, {2, 1}, {-1, -2}},
+	{RotationL, Rotation2}: {{0, 0}, {-2, 0}, {1, 0}, {-2, -1}, {1, 2}},
+	{RotationL, Rotation0}: {{0, 0}, {1, 0}, {-2, 0}, {1, -2}, {-2, 1}},
+	{Rotation0, RotationL}: {{0, 0}, {-1, 0}, {2, 0}, {-1, 2}, {2, -1}},
+}
+
+func kickTable(p Piece, from, to Rotation) []offset {
+	switch p {
+	case PieceO:
+		return []offset{{0, 0}}
+	case PieceI:
+		return kicksI[[2]Rotation{from, to}]
+	default:
+		return kicks[[2]Rotation{from, to}]
+	}
+}
+
+// Active 正在下落的方块
+// X和Y为包围盒左上角在棋盘中的位置，Y向上增长
+type Active struct {
+	Piece    Piece
+	Rotation Rotation
+	X, Y     int
+}
+
+// Cells 方块占据的棋盘格子
+func (a Active) Cells() [4][2]int {
+	var cells [4][2]int
+	for i, c := range shapes[a.Piece][a.Rotation] {
+		cells[i] = [2]int{a.X + c.col, a.Y - c.row}
+	}
+	return cells
+}
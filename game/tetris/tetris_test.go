@@ -0,0 +1,281 @@
+package tetris
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setRows 从y=0开始按行设置棋盘，'X'为已占用
+func setRows(g *Game, rows ...string) {
+	for y, row := range rows {
+		for x, ch := range row {
+			if ch == 'X' {
+				g.board.cells[y][x] = Garbage
+			}
+		}
+	}
+}
+
+func TestBagIsDeterministicPermutations(t *testing.T) {
+	a, b := newBag(42), newBag(42)
+	seq := a.peek(21)
+	if !slices.Equal(seq, b.peek(21)) {
+		t.Fatal("same seed produced different sequences")
+	}
+	for i := 0; i < len(seq); i += pieceCount {
+		bag := slices.Clone(seq[i : i+pieceCount])
+		slices.Sort(bag)
+		if !slices.Equal(bag, []Piece{PieceI, PieceO, PieceT, PieceS, PieceZ, PieceJ, PieceL}) {
+			t.Fatalf("bag %d is %v", i/pieceCount, seq[i:i+pieceCount])
+		}
+	}
+	if slices.Equal(seq, newBag(43).peek(21)) {
+		t.Fatal("different seeds produced the same sequence")
+	}
+}
+
+func TestRotatedShapes(t *testing.T) {
+	tests := []struct {
+		piece Piece
+		rot   Rotation
+		want  []cell
+	}{
+		{PieceT, RotationR, []cell{{0, 1}, {1, 1}, {1, 2}, {2, 1}}},
+		{PieceT, Rotation2, []cell{{1, 0}, {1, 1}, {1, 2}, {2, 1}}},
+		{PieceI, RotationR, []cell{{0, 2}, {1, 2}, {2, 2}, {3, 2}}},
+		{PieceI, RotationL, []cell{{0, 1}, {1, 1}, {2, 1}, {3, 1}}},
+		{PieceO, Rotation2, []cell{{0, 1}, {0, 2}, {1, 1}, {1, 2}}},
+	}
+	for _, tt := range tests {
+		got := slices.Clone(shapes[tt.piece][tt.rot])
+		slices.SortFunc(got, func(a, b cell) int { return (a.row*4 + a.col) - (b.row*4 + b.col) })
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%v rotation %d = %v, want %v", tt.piece, tt.rot, got, tt.want)
+		}
+	}
+}
+
+func TestWallKick(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	// 贴着左墙竖放的I逆时针旋转需要踢墙
+	g.active = Active{Piece: PieceI, Rotation: RotationR, X: -2, Y: 10}
+	if !g.board.fits(g.active) {
+		t.Fatal("start position does not fit")
+	}
+	g.Step(OpRotateCCW)
+	if a := g.Active(); a.Rotation != Rotation0 || a.X != 0 || g.lastKick != 1 {
+		t.Fatalf("got %+v kick %d, want rotation 0 at x=0 with kick 1", a, g.lastKick)
+	}
+}
+
+func TestLineClearAndPerfectClear(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	setRows(g, "XXX....XXX")
+	g.active = Active{Piece: PieceI, X: 3, Y: Visible + 1}
+	events := g.Step(OpHardDrop)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Lines != 1 || !e.PerfectClear || e.Attack != DefaultAttackTable().PerfectClear || e.Combo != 0 {
+		t.Fatalf("got %+v, want a single line perfect clear", e)
+	}
+	if !g.board.empty() || g.Stats().Lines != 1 {
+		t.Fatalf("board not empty after clear:\n%s", g.Board())
+	}
+}
+
+func TestTSpinDouble(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	setRows(g,
+		"XXXX.XXXXX",
+		"XXX...XXXX",
+		"XXXX......",
+	)
+	g.active = Active{Piece: PieceT, X: 3, Y: 2}
+	events := g.Step(OpRotateCW, OpRotateCW, OpHardDrop)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if e := events[0]; e.TSpin != TSpinFull || e.Lines != 2 || e.Attack != 4 {
+		t.Fatalf("got %+v, want T-spin double with 4 attack", e)
+	}
+	if want := "XXXX......"; !strings.HasSuffix(g.Board().String(), strings.ReplaceAll(want, "X", "#")+"\n") {
+		t.Fatalf("unexpected board:\n%s", g.Board())
+	}
+}
+
+func TestGarbage(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	g.ReceiveGarbage(3, 0)
+	if g.PendingGarbage() != 3 {
+		t.Fatalf("pending %d, want 3", g.PendingGarbage())
+	}
+	e := g.Step(OpHardDrop)[0]
+	if e.Garbage != 3 || g.PendingGarbage() != 0 {
+		t.Fatalf("got %+v, pending %d", e, g.PendingGarbage())
+	}
+	for y := 0; y < 3; y++ {
+		if g.Board().Cell(0, y) != PieceNone || g.Board().Cell(1, y) != Garbage {
+			t.Fatalf("row %d is not garbage with a hole at 0:\n%s", y, g.Board())
+		}
+	}
+	if h := g.Board().Heights(); h[0] != 0 || h[9] != 3 {
+		t.Fatalf("heights %v, want an empty hole column and 3 rows at the edge", h)
+	}
+
+	// 攻击先抵消待收的垃圾
+	g = NewGame(1, DefaultConfig())
+	setRows(g,
+		"XXXX.XXXXX",
+		"XXX...XXXX",
+		"XXXX......",
+	)
+	g.ReceiveGarbage(1, 0)
+	g.ReceiveGarbage(2, 5)
+	g.active = Active{Piece: PieceT, X: 3, Y: 2}
+	e = g.Step(OpRotateCW, OpRotateCW, OpHardDrop)[0]
+	if e.Attack != 4 || e.Sent != 1 || e.Garbage != 0 || g.PendingGarbage() != 0 {
+		t.Fatalf("got %+v, pending %d; want 3 lines canceled and 1 sent", e, g.PendingGarbage())
+	}
+}
+
+func TestHold(t *testing.T) {
+	g := NewGame(7, DefaultConfig())
+	first, next := g.Active().Piece, g.Next()[0]
+	g.Step(OpHold)
+	if g.Hold() != first || g.Active().Piece != next {
+		t.Fatalf("hold %v active %v, want %v and %v", g.Hold(), g.Active().Piece, first, next)
+	}
+	g.Step(OpHold)
+	if g.Hold() != first || g.Active().Piece != next {
+		t.Fatal("held twice before locking")
+	}
+	g.Step(OpHardDrop, OpHold)
+	if g.Hold() == first || g.Active().Piece != first {
+		t.Fatalf("hold %v active %v, want %v swapped back", g.Hold(), g.Active().Piece, first)
+	}
+}
+
+func TestGravityAndLockDelay(t *testing.T) {
+	g := NewGame(3, Config{Gravity: 1, LockDelay: 2, MaxLockResets: 15, Previews: 5})
+	// 出生时最低的格子在第Visible行，每帧下落一格，落地后再经过LockDelay-1帧锁定
+	for i := 0; i < Visible; i++ {
+		if events := g.Step(); len(events) != 0 {
+			t.Fatalf("locked at frame %d", i)
+		}
+	}
+	events := g.Step()
+	if len(events) != 1 || events[0].Frame != Visible {
+		t.Fatalf("got %+v, want a lock at frame %d", events, Visible)
+	}
+}
+
+func TestTopOut(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	g.ReceiveGarbage(Visible+1, 0)
+	e := g.Step(OpHardDrop)[0]
+	if !e.ToppedOut || !g.Over() {
+		t.Fatalf("got %+v, want top out", e)
+	}
+	if events := g.Step(OpHardDrop); events != nil {
+		t.Fatalf("game over still produced %v", events)
+	}
+}
+
+func TestDecodeOps(t *testing.T) {
+	ops, err := DecodeOps([]byte{byte(OpMoveLeft), byte(OpHardDrop)})
+	if err != nil || !slices.Equal(ops, []Op{OpMoveLeft, OpHardDrop}) {
+		t.Fatalf("got %v, %v", ops, err)
+	}
+	if _, err := DecodeOps([]byte{0}); !errors.Is(err, ErrUnknownOp) {
+		t.Fatalf("got %v, want ErrUnknownOp", err)
+	}
+}
+
+func TestGravityCurve(t *testing.T) {
+	config := Config{Gravity: 30, GravityCurve: []GravityStep{{Frame: 10, FramesPerRow: 2}, {Frame: 20, FramesPerRow: 1}}}
+	for _, tt := range []struct {
+		frame int32
+		want  int
+	}{{0, 30}, {9, 30}, {10, 2}, {19, 2}, {20, 1}, {1000, 1}} {
+		if got := config.gravityAt(tt.frame); got != tt.want {
+			t.Errorf("gravityAt(%d) = %d, want %d", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestCustomAttackTable(t *testing.T) {
+	config := DefaultConfig()
+	config.Attack.TSpin = []int{0, 3, 5}
+	config.CancelGarbage = false
+	g := NewGame(1, config)
+	setRows(g,
+		"XXXX.XXXXX",
+		"XXX...XXXX",
+		"XXXX......",
+	)
+	g.ReceiveGarbage(2, 0)
+	g.active = Active{Piece: PieceT, X: 3, Y: 2}
+	e := g.Step(OpRotateCW, OpRotateCW, OpHardDrop)[0]
+	if e.Attack != 5 || e.Sent != 5 || g.PendingGarbage() != 2 {
+		t.Fatalf("got %+v, pending %d; want 5 sent without canceling", e, g.PendingGarbage())
+	}
+}
+
+func TestMinInputs(t *testing.T) {
+	tests := []struct {
+		a    Active
+		want int
+	}{
+		{Active{Piece: PieceT, Rotation: Rotation0, X: 3}, 0},
+		{Active{Piece: PieceT, Rotation: Rotation2, X: 5}, 4},
+		// I和S的两个竖直朝向相差一列，取移动较少的一个
+		{Active{Piece: PieceI, Rotation: Rotation2, X: 3}, 0},
+		{Active{Piece: PieceI, Rotation: RotationL, X: 3}, 1},
+		{Active{Piece: PieceS, Rotation: RotationR, X: 2}, 1},
+		{Active{Piece: PieceO, Rotation: RotationR, X: -1}, 4},
+	}
+	for _, tt := range tests {
+		if got := minInputs(tt.a); got != tt.want {
+			t.Errorf("minInputs(%+v) = %d, want %d", tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestFinesse(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	// 左右来回移动多按了两次
+	g.Step(OpMoveLeft, OpMoveRight, OpHardDrop)
+	g.Step(OpRotateCW, OpHardDrop)
+	// 软降后的移动不计
+	g.Step(OpSoftDrop, OpMoveLeft, OpMoveRight, OpHardDrop)
+	g.ReceiveGarbage(2, 0)
+	if stats := g.Stats(); stats.Finesse != 1 || stats.Received != 2 || stats.MaxCombo != -1 {
+		t.Fatalf("got %+v, want 1 finesse error and 2 lines received", stats)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	g := NewGame(1, DefaultConfig())
+	for i := 0; i < 1000; i++ {
+		ops := g.Plan()
+		if ops[len(ops)-1] != OpHardDrop {
+			t.Fatalf("plan %v does not end with a hard drop", ops)
+		}
+		g.Step(ops...)
+		if g.Over() {
+			t.Fatalf("bot topped out after %d pieces:\n%s", i+1, g.Board())
+		}
+	}
+	// 每个方块4格，1000个方块最多消除400行
+	if stats := g.Stats(); stats.Pieces != 1000 || stats.Lines < 390 {
+		t.Fatalf("got %+v, want the board kept low", stats)
+	}
+	g.over = true
+	if ops := g.Plan(); ops != nil {
+		t.Fatalf("got %v after game over", ops)
+	}
+}
@@ -0,0 +1,240 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
+	pb "TetrisSvr/proto"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// 玩家被标记后的处理策略
+const (
+	// CheatPolicyWarn 只记录日志、回放和比赛结果
+	CheatPolicyWarn = "warn"
+	// CheatPolicyKick 将玩家移出本局游戏
+	CheatPolicyKick = "kick"
+	// CheatPolicyVoid 结束本局游戏并将比赛作废
+	CheatPolicyVoid = "void"
+)
+
+// 标记玩家的原因，同时作为metrics.CheatFlags的标签
+const (
+	flagMalformed     = "malformed_input"
+	flagTooManyOps    = "too_many_ops"
+	flagSuperhumanPPS = "superhuman_pps"
+)
+
+// ppsWindow 统计每秒方块数的时间窗口，游戏进行不足一个窗口时不检查
+const ppsWindow = 10 * time.Second
+
+// CheatFlag 玩家因某个原因第一次被标记的帧
+type CheatFlag struct {
+	Player string `json:"player"`
+	Frame  int32  `json:"frame"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// validation 一个玩家的校验状态，只在游戏协程中使用
+type validation struct {
+	// sim 用比赛设置对玩家的输入做服务器端模拟，开始游戏时创建
+	sim *tetris.Game
+	// pieces 窗口内每个锁定的方块所在的帧
+	pieces []int32
+	// ops 窗口内每帧的操作数，按客户端标记的帧计数，晚到后放入其他帧的输入仍计入原来的帧
+	ops     map[int32]int
+	flagged map[string]bool
+}
+
+// opsAt 玩家在某一帧中已有的操作数
+func (p *GamePlayer) opsAt(frame int32) int {
+	n := 0
+	if data, ok := p.frames[frame]; ok {
+		for _, op := range data.Operations {
+			n += len(op)
+		}
+	}
+	return n
+}
+
+// flagReasons 玩家被标记的原因，按字母排序
+func (p *GamePlayer) flagReasons() []string {
+	var reasons []string
+	for reason := range p.check.flagged {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// simulated 是否使用服务器模拟的结果
+// 只有开启ValidateInput、所有客户端都实现了tetris.RulesVersion的规则时，模拟才与客户端的棋盘一致
+func (g *Game) simulated() bool {
+	return g.settings.ValidateInput
+}
+
+// rejectInput 拒绝一条输入，并通知玩家原因
+func (g *Game) rejectInput(player *GamePlayer, message *pb.C2S_Input, reason string) {
+	metrics.InputAdjustments.WithLabelValues(inputInvalid).Inc()
+	g.log.Warn("Rejected input", logging.KeyPlayer, player.playerID, "reason", reason,
+		"frame", message.GetFrameNumber(), "current", g.frameNumber)
+	g.sendInputCorrection(player, &pb.S2C_InputCorrection{
+		FrameNumber: message.GetFrameNumber(),
+		Rejected:    true,
+		Reason:      reason,
+	})
+}
+
+// validateInput 检查输入是否可能由正常的客户端产生，不可能时拒绝并返回false
+// 已经结束的玩家的输入总是被拒绝，其余检查只在开启Settings.ValidateInput时进行，
+// 格式错误和单帧操作过多的玩家会被标记；frame为输入放入的帧，操作数按客户端标记的帧计算，
+// 早于输入延迟窗口的标记按窗口内最早的帧计算，用不同的旧帧号标记输入不能绕过限制
+func (g *Game) validateInput(player *GamePlayer, message *pb.C2S_Input, frame int32) bool {
+	if player.ended {
+		g.rejectInput(player, message, "player has ended the game")
+		return false
+	}
+	if !g.settings.ValidateInput {
+		return true
+	}
+	ops, err := tetris.DecodeOps(message.GetOperations())
+	if err != nil {
+		g.rejectInput(player, message, "malformed operations")
+		g.flag(player, flagMalformed, err.Error())
+		return false
+	}
+	if player.check.sim != nil && player.check.sim.Over() {
+		g.rejectInput(player, message, "player has topped out")
+		return false
+	}
+	if message.FrameNumber != nil {
+		frame = max(message.GetFrameNumber(), g.frameNumber-g.inputDelay)
+	}
+	count := player.check.ops[frame] + len(ops)
+	if limit := g.settings.MaxOpsPerFrame; limit > 0 && count > limit {
+		g.rejectInput(player, message, "too many operations in one frame")
+		g.flag(player, flagTooManyOps, fmt.Sprintf("%d operations in frame %d", count, frame))
+		return false
+	}
+	if player.check.ops == nil {
+		player.check.ops = make(map[int32]int)
+	}
+	player.check.ops[frame] = count
+	return true
+}
+
+// checkFrame 在帧发出后推进模拟并检查每秒方块数，单人模式中判定成绩
+// 对战中玩家何时结束仍由客户端的C2S_GameEnd决定，模拟中堆到顶只记录日志
+// 与客户端相同，先收下这一帧到达的垃圾再执行操作，锁定方块发出的攻击交给路由
+func (g *Game) checkFrame(frame int32) {
+	window := int32(ppsWindow / g.interval)
+	var toppedOut []string
+	for _, p := range g.players {
+		if p.ended || g.status == GameOver {
+			continue
+		}
+		var ops []tetris.Op
+		if data, ok := p.frames[frame]; ok {
+			for _, garbage := range data.Garbage {
+				p.check.sim.ReceiveGarbage(int(garbage.GetLines()), int(garbage.GetHole()))
+			}
+			for _, raw := range data.Operations {
+				// 没有开启输入校验时，无法解析为tetris.Op的输入不参与模拟
+				decoded, _ := tetris.DecodeOps(raw)
+				ops = append(ops, decoded...)
+			}
+		}
+
+		for _, event := range p.check.sim.Step(ops...) {
+			p.check.pieces = append(p.check.pieces, frame)
+			if event.Sent > 0 && g.garbage != nil {
+				g.routeAttack(p, int32(event.Sent), frame)
+			}
+			if event.ToppedOut {
+				g.log.Info("Player topped out in simulation", logging.KeyPlayer, p.playerID, "frame", frame)
+				toppedOut = append(toppedOut, p.playerID)
+			}
+		}
+		for len(p.check.pieces) > 0 && p.check.pieces[0] <= frame-window {
+			p.check.pieces = p.check.pieces[1:]
+		}
+		for f := range p.check.ops {
+			if f <= frame-window {
+				delete(p.check.ops, f)
+			}
+		}
+
+		if g.settings.ValidateInput && g.settings.MaxPPS > 0 && frame+1 >= window {
+			pps := float64(len(p.check.pieces)) / ppsWindow.Seconds()
+			if pps > g.settings.MaxPPS {
+				g.flag(p, flagSuperhumanPPS, fmt.Sprintf("%.1f pieces per second over %s", pps, ppsWindow))
+			}
+		}
+	}
+
+	if soloMode(g.setup.GetMode()) {
+		g.checkSolo(frame, toppedOut)
+	}
+}
+
+// flag 标记玩家并按策略处理，同一原因只标记一次
+func (g *Game) flag(player *GamePlayer, reason, detail string) {
+	if player.check.flagged[reason] {
+		return
+	}
+	if player.check.flagged == nil {
+		player.check.flagged = make(map[string]bool)
+	}
+	player.check.flagged[reason] = true
+	flag := CheatFlag{Player: player.playerID, Frame: g.frameNumber, Reason: reason, Detail: detail}
+	g.flags = append(g.flags, flag)
+
+	metrics.CheatFlags.WithLabelValues(reason).Inc()
+	g.log.Warn("Player flagged", logging.KeyPlayer, player.playerID, "reason", reason,
+		"detail", detail, "policy", g.settings.CheatPolicy)
+	if g.replay != nil {
+		g.replay.recordEvent(ReplayEvent{
+			Frame:     g.frameNumber,
+			Type:      ReplayEventCheat,
+			FromFrame: g.frameNumber,
+			ToFrame:   g.frameNumber,
+			Players:   []string{player.playerID},
+			Detail:    reason + ": " + detail,
+		})
+	}
+
+	switch g.settings.CheatPolicy {
+	case CheatPolicyKick:
+		g.removePlayer(player.playerID)
+	case CheatPolicyVoid:
+		g.voidGame()
+	}
+}
+
+// flaggedPlayers 被标记过的玩家，包括已经被移出游戏的玩家
+func (g *Game) flaggedPlayers() []string {
+	seen := make(map[string]bool)
+	var players []string
+	for _, f := range g.flags {
+		if !seen[f.Player] {
+			seen[f.Player] = true
+			players = append(players, f.Player)
+		}
+	}
+	sort.Strings(players)
+	return players
+}
+
+// voidGame 作废本局游戏，通知所有玩家后结束
+func (g *Game) voidGame() {
+	if g.status == GameOver {
+		return
+	}
+	g.voided = true
+	g.broadcastGameEnd(&pb.S2C_GameEnd{EndGame: true, Voided: true})
+	g.log.Warn("Game voided", "flagged", g.flaggedPlayers())
+	g.endGame()
+}
@@ -0,0 +1,268 @@
+package game
+
+import (
+	"TetrisSvr/game/tetris"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// startValidatedGame 开启输入校验并使用给定的参数开始游戏，返回手动时钟和加载完成的客户端
+func startValidatedGame(t *testing.T, settings Settings, playerIDs ...string) (*nettest.ManualClock, []*nettest.Client) {
+	t.Helper()
+	settings.ValidateInput = true
+	return startClockedGame(t, ModeVersus, settings, playerIDs...)
+}
+
+// startClockedGame 使用手动时钟开始指定模式的游戏
+func startClockedGame(t *testing.T, mode string, settings Settings, playerIDs ...string) (*nettest.ManualClock, []*nettest.Client) {
+	t.Helper()
+	clock, m := newClockedRoomManager(t, settings)
+	clients := startModeGameOn(t, m, mode, playerIDs...)
+	loadGame(t, clients)
+	clock.BlockUntil(1)
+	return clock, clients
+}
+
+// newClockedRoomManager 创建使用手动时钟和settings的管理器
+func newClockedRoomManager(t *testing.T, settings Settings) (*nettest.ManualClock, *RoomManager) {
+	t.Helper()
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	cfg := testConfig()
+	cfg.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	m := NewRoomManager(ctx, cfg, &UniqueIDRoomCreator{})
+	m.Start()
+	if err := m.ApplySettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	return clock, m
+}
+
+func ops(ops ...tetris.Op) []byte {
+	return tetris.EncodeOps(ops)
+}
+
+func TestInputNotValidatedByDefault(t *testing.T) {
+	// 客户端的操作编码与tetris.Op不同时，输入按原样转发，玩家不会被标记
+	clock, clients := startClockedGame(t, ModeVersus, Settings{CheatPolicy: CheatPolicyKick, MaxOpsPerFrame: 1}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input([]byte{0xff})
+	clients[0].Input([]byte("rotate"))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	sync := mustWait(t, clients[1], isSyncFrames).GetS2CSyncFrames()
+	for _, op := range [][]byte{{0xff}, []byte("rotate")} {
+		if _, ok := findOperation(sync, "p1", op); !ok {
+			t.Fatalf("input %q was not relayed in %v", op, sync)
+		}
+	}
+	for _, p := range game.Info().Players {
+		if len(p.Flags) > 0 {
+			t.Fatalf("%s flagged %v without input validation", p.ID, p.Flags)
+		}
+	}
+}
+
+func TestRejectMalformedInput(t *testing.T) {
+	_, clients := startValidatedGame(t, Settings{CheatPolicy: CheatPolicyWarn}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input([]byte{0xff})
+	correction := mustWait(t, clients[0], isInputCorrection).GetS2CInputCorrection()
+	if !correction.GetRejected() || correction.GetReason() != "malformed operations" {
+		t.Fatalf("got %v, want malformed rejection", correction)
+	}
+	info := game.Info()
+	if info.Status != PlayingGame {
+		t.Fatalf("game status %s, want playing under warn policy", info.Status)
+	}
+	for _, p := range info.Players {
+		if want := p.ID == "p1"; want != slices.Contains(p.Flags, flagMalformed) {
+			t.Fatalf("%s flags %v", p.ID, p.Flags)
+		}
+	}
+}
+
+func TestKickPlayerWithTooManyOps(t *testing.T) {
+	_, clients := startValidatedGame(t, Settings{CheatPolicy: CheatPolicyKick, MaxOpsPerFrame: 4}, "p1", "p2", "p3")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input(ops(tetris.OpMoveLeft, tetris.OpMoveLeft))
+	clients[0].Input(ops(tetris.OpMoveLeft, tetris.OpMoveLeft, tetris.OpHardDrop))
+	for _, c := range clients {
+		end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		if end.GetEndPlayer() != "p1" || !slices.Equal(end.GetFlaggedPlayers(), []string{"p1"}) {
+			t.Fatalf("%s: got game end %v, want p1 kicked", c.PlayerID, end)
+		}
+	}
+	info := game.Info()
+	if info.Status != PlayingGame || len(info.Players) != 2 {
+		t.Fatalf("got %+v, want the game to continue without p1", info)
+	}
+}
+
+func TestLateInputCountsAgainstItsOwnFrame(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{CheatPolicy: CheatPolicyWarn, MaxOpsPerFrame: 4, MinInputDelay: 3}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	for i := 0; i < 3; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+
+	// 晚到的输入放入第3帧，但按第0帧计数，不与第3帧的输入合计
+	three := ops(tetris.OpMoveLeft, tetris.OpMoveLeft, tetris.OpMoveLeft)
+	clients[0].InputAt(3, three)
+	clients[0].InputAt(0, three)
+	waitForMessages(t, game)
+	if c := mustWait(t, clients[0], isInputCorrection).GetS2CInputCorrection(); c.GetRejected() || c.GetAppliedFrame() != 3 {
+		t.Fatalf("got %v, want the late input moved to frame 3", c)
+	}
+	clients[0].InputAt(0, ops(tetris.OpMoveRight, tetris.OpMoveRight))
+	if c := mustWait(t, clients[0], isInputCorrection).GetS2CInputCorrection(); !c.GetRejected() || c.GetReason() != "too many operations in one frame" {
+		t.Fatalf("got %v, want a fifth operation for frame 0 rejected", c)
+	}
+	for _, p := range game.Info().Players {
+		if want := p.ID == "p1"; want != slices.Contains(p.Flags, flagTooManyOps) {
+			t.Fatalf("%s flags %v", p.ID, p.Flags)
+		}
+	}
+}
+
+func TestStaleInputTagsShareOneLimit(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{CheatPolicy: CheatPolicyWarn, MaxOpsPerFrame: 4, MinInputDelay: 2}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+	for i := 0; i < 10; i++ {
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+
+	// 早于输入延迟窗口的帧号都按窗口内最早的帧计数，每条输入换一个旧帧号也不能超过限制
+	two := ops(tetris.OpMoveLeft, tetris.OpMoveLeft)
+	clients[0].InputAt(0, two)
+	clients[0].InputAt(1, two)
+	clients[0].InputAt(2, two)
+	var rejected *pb.S2C_InputCorrection
+	for rejected == nil {
+		if c := mustWait(t, clients[0], isInputCorrection).GetS2CInputCorrection(); c.GetRejected() {
+			rejected = c
+		}
+	}
+	if rejected.GetFrameNumber() != 2 || rejected.GetReason() != "too many operations in one frame" {
+		t.Fatalf("got %v, want the third stale input rejected", rejected)
+	}
+	for _, p := range game.Info().Players {
+		if want := p.ID == "p1"; want != slices.Contains(p.Flags, flagTooManyOps) {
+			t.Fatalf("%s flags %v", p.ID, p.Flags)
+		}
+	}
+}
+
+func TestVoidGameForSuperhumanPPS(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{CheatPolicy: CheatPolicyVoid, MaxPPS: 0.5}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 10秒内6个方块超过每秒0.5个
+	window := int(ppsWindow / FrameInterval)
+	for i := 0; i < window; i++ {
+		if i < 6 {
+			clients[1].Input(ops(tetris.OpHardDrop))
+			waitForMessages(t, game)
+		}
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+	for _, c := range clients {
+		end := mustWait(t, c, isGameEnd).GetS2CGameEnd()
+		if !end.GetVoided() || !end.GetEndGame() || !slices.Equal(end.GetFlaggedPlayers(), []string{"p2"}) {
+			t.Fatalf("%s: got game end %v, want voided with p2 flagged", c.PlayerID, end)
+		}
+	}
+	<-game.Done()
+	if !game.voided || len(game.replay.Flags) != 1 || game.replay.Flags[0].Reason != flagSuperhumanPPS {
+		t.Fatalf("replay flags %+v, voided %v", game.replay.Flags, game.voided)
+	}
+	if e := game.replay.Events[len(game.replay.Events)-1]; e.Type != ReplayEventCheat {
+		t.Fatalf("last replay event %+v, want cheat", e)
+	}
+}
+
+func TestPPSNotCheckedByDefault(t *testing.T) {
+	// 没有开启输入校验时不使用模拟锁定的方块数
+	clock, clients := startClockedGame(t, ModeVersus, Settings{CheatPolicy: CheatPolicyVoid, MaxPPS: 0.5}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	window := int(ppsWindow / FrameInterval)
+	for i := 0; i < window; i++ {
+		if i < 6 {
+			clients[1].Input(ops(tetris.OpHardDrop))
+			waitForMessages(t, game)
+		}
+		clock.Advance(FrameInterval)
+		mustWait(t, clients[0], isSyncFrames)
+	}
+	info := game.Info()
+	if info.Status != PlayingGame {
+		t.Fatalf("game status %s, want playing without input validation", info.Status)
+	}
+	for _, p := range info.Players {
+		if len(p.Flags) > 0 {
+			t.Fatalf("%s flagged %v without input validation", p.ID, p.Flags)
+		}
+	}
+}
+
+func TestRejectInputAfterSimulatedTopOut(t *testing.T) {
+	clock, clients := startValidatedGame(t, Settings{}, "p1", "p2")
+	game := clients[0].Conn.Handler().(*Game)
+
+	// 在同一列不停硬降，很快就会堆到顶
+	drops := bytes.Repeat(ops(tetris.OpHardDrop), 40)
+	clients[0].Input(drops)
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+
+	clients[0].Input(ops(tetris.OpMoveLeft))
+	correction := mustWait(t, clients[0], isInputCorrection).GetS2CInputCorrection()
+	if !correction.GetRejected() || correction.GetReason() != "player has topped out" {
+		t.Fatalf("got %v, want rejection after top out", correction)
+	}
+	clients[1].Input(ops(tetris.OpMoveLeft))
+	clock.Advance(FrameInterval)
+	sync := mustWait(t, clients[1], func(m *pb.MessageWrapper) bool {
+		_, ok := findOperation(m.GetS2CSyncFrames(), "p2", ops(tetris.OpMoveLeft))
+		return ok
+	})
+	if _, ok := findOperation(sync.GetS2CSyncFrames(), "p1", ops(tetris.OpMoveLeft)); ok {
+		t.Fatal("input after top out was relayed")
+	}
+}
+
+func TestSimulatedTopOutDoesNotEndPlayer(t *testing.T) {
+	// 模拟可能与客户端不一致，堆到顶的玩家仍由客户端决定何时结束
+	clock, clients := startClockedGame(t, ModeRoyale, Settings{ValidateInput: true}, "p1", "p2", "p3")
+	game := clients[0].Conn.Handler().(*Game)
+
+	clients[0].Input(bytes.Repeat(ops(tetris.OpHardDrop), 40))
+	waitForMessages(t, game)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+	clock.Advance(FrameInterval)
+	mustWait(t, clients[0], isSyncFrames)
+
+	var ended, over bool
+	game.run(func() {
+		p := game.players["p1"]
+		ended, over = p.ended, p.check.sim.Over()
+	})
+	if !over || ended {
+		t.Fatalf("p1 topped out %v, ended %v; want topped out in simulation but still playing", over, ended)
+	}
+}
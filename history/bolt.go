@@ -0,0 +1,203 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// matchesBucket 以递增序号为键保存比赛，遍历顺序即结束顺序
+	matchesBucket = []byte("matches")
+	// gamesBucket 游戏ID到比赛序号
+	gamesBucket = []byte("games")
+	// playersBucket 以玩家ID和比赛序号为键的空值，用于查询玩家的比赛
+	playersBucket = []byte("players")
+	// statsBucket 玩家ID到累计统计
+	statsBucket = []byte("stats")
+	// ratingsBucket 玩家ID到团队积分
+	ratingsBucket = []byte("ratings")
+)
+
+// BoltStore 保存在bbolt文件中的比赛记录，所有查询直接读取文件
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore 打开path中的比赛记录，文件不存在时创建
+func NewBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{matchesBucket, gamesBucket, playersBucket, statsBucket, ratingsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func playerKey(playerID string, seq []byte) []byte {
+	return append(append([]byte(playerID), 0), seq...)
+}
+
+func (s *BoltStore) Record(m Match) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(gamesBucket).Get([]byte(m.GameID)) != nil {
+			return ErrDuplicateMatch
+		}
+		matches := tx.Bucket(matchesBucket)
+		n, err := matches.NextSequence()
+		if err != nil {
+			return err
+		}
+		seq := binary.BigEndian.AppendUint64(nil, n)
+		if err := matches.Put(seq, data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(gamesBucket).Put([]byte(m.GameID), seq); err != nil {
+			return err
+		}
+		for _, p := range m.Players {
+			if err := tx.Bucket(playersBucket).Put(playerKey(p.ID, seq), nil); err != nil {
+				return err
+			}
+			if m.Voided {
+				continue
+			}
+			stats, err := readStats(tx, p.ID)
+			if err != nil {
+				return err
+			}
+			stats.add(m, p)
+			data, err := json.Marshal(stats)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(statsBucket).Put([]byte(p.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Match(gameID string) (Match, error) {
+	var m Match
+	err := s.db.View(func(tx *bolt.Tx) error {
+		seq := tx.Bucket(gamesBucket).Get([]byte(gameID))
+		if seq == nil {
+			return ErrMatchNotFound
+		}
+		return json.Unmarshal(tx.Bucket(matchesBucket).Get(seq), &m)
+	})
+	return m, err
+}
+
+func (s *BoltStore) Matches(playerID string, offset, limit int) ([]Match, int, error) {
+	var result []Match
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		matches := tx.Bucket(matchesBucket)
+		if playerID == "" {
+			total = matches.Stats().KeyN
+			c := matches.Cursor()
+			k, v := c.Last()
+			for i := 0; i < offset && k != nil; i++ {
+				k, v = c.Prev()
+			}
+			for ; k != nil && len(result) < limit; k, v = c.Prev() {
+				var m Match
+				if err := json.Unmarshal(v, &m); err != nil {
+					return err
+				}
+				result = append(result, m)
+			}
+			return nil
+		}
+
+		// 玩家的比赛序号按从旧到新排列，先全部找出再从最新的开始读取
+		var seqs [][]byte
+		prefix := playerKey(playerID, nil)
+		c := tx.Bucket(playersBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			seqs = append(seqs, k[len(prefix):])
+		}
+		total = len(seqs)
+		for i := len(seqs) - 1 - max(offset, 0); i >= 0 && len(result) < limit; i-- {
+			var m Match
+			if err := json.Unmarshal(matches.Get(seqs[i]), &m); err != nil {
+				return err
+			}
+			result = append(result, m)
+		}
+		return nil
+	})
+	return result, total, err
+}
+
+func (s *BoltStore) Stats(playerID string) (Stats, error) {
+	var stats Stats
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = readStats(tx, playerID)
+		return err
+	})
+	return stats, err
+}
+
+func readStats(tx *bolt.Tx, playerID string) (Stats, error) {
+	stats := Stats{PlayerID: playerID}
+	data := tx.Bucket(statsBucket).Get([]byte(playerID))
+	if data == nil {
+		return stats, nil
+	}
+	err := json.Unmarshal(data, &stats)
+	return stats, err
+}
+
+func (s *BoltStore) Ratings() (map[string]int32, error) {
+	ratings := make(map[string]int32)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ratingsBucket).ForEach(func(k, v []byte) error {
+			ratings[string(k)] = int32(binary.BigEndian.Uint32(v))
+			return nil
+		})
+	})
+	return ratings, err
+}
+
+func (s *BoltStore) SetRatings(ratings map[string]int32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for id, rating := range ratings {
+			if err := tx.Bucket(ratingsBucket).Put([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(rating))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,209 @@
+// Package history 保存结束的比赛、每个玩家的累计统计和团队积分
+package history
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+// ErrMatchNotFound 查询的比赛不存在
+var ErrMatchNotFound = errors.New("match not found")
+
+// ErrDuplicateMatch 已经保存过相同游戏ID的比赛
+var ErrDuplicateMatch = errors.New("match already recorded")
+
+// Match 一局结束的比赛
+type Match struct {
+	GameID    string    `json:"game_id"`
+	RoomID    string    `json:"room_id"`
+	Mode      string    `json:"mode"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+	Frames    int32     `json:"frames"`
+	// DurationMs 比赛的时长，按帧数计算
+	DurationMs int64 `json:"duration_ms"`
+	// Voided 比赛因作弊作废，作废的比赛不计入统计
+	Voided bool `json:"voided,omitempty"`
+	// Replay 回放文件的路径，没有保存回放时为空
+	Replay  string        `json:"replay,omitempty"`
+	Players []MatchPlayer `json:"players"`
+}
+
+// MatchPlayer 玩家在一局比赛中的名次和服务器模拟得到的数据
+type MatchPlayer struct {
+	ID    string `json:"id"`
+	Team  int32  `json:"team,omitempty"`
+	Place int32  `json:"place"`
+	// Frames 玩家在比赛中的帧数，结束或离开后不再增加
+	Frames    int32 `json:"frames"`
+	Pieces    int   `json:"pieces"`
+	Lines     int   `json:"lines"`
+	LinesSent int   `json:"lines_sent"`
+	Flagged   bool  `json:"flagged,omitempty"`
+}
+
+// Stats 玩家所有未作废比赛的累计统计
+type Stats struct {
+	PlayerID  string `json:"player_id"`
+	Games     int    `json:"games"`
+	Wins      int    `json:"wins"`
+	Pieces    int64  `json:"pieces"`
+	Lines     int64  `json:"lines"`
+	LinesSent int64  `json:"lines_sent"`
+	// PlayMs 玩家在比赛中的总时长
+	PlayMs     int64     `json:"play_ms"`
+	LastPlayed time.Time `json:"last_played"`
+}
+
+// APM 每分钟发出的攻击行数
+func (s Stats) APM() float64 {
+	if s.PlayMs == 0 {
+		return 0
+	}
+	return float64(s.LinesSent) / (float64(s.PlayMs) / float64(time.Minute/time.Millisecond))
+}
+
+// PPS 每秒放置的方块数
+func (s Stats) PPS() float64 {
+	if s.PlayMs == 0 {
+		return 0
+	}
+	return float64(s.Pieces) / (float64(s.PlayMs) / float64(time.Second/time.Millisecond))
+}
+
+// add 把玩家的一局比赛计入统计，时长按玩家在比赛中的帧数折算，单人比赛不计胜场
+func (s *Stats) add(m Match, p MatchPlayer) {
+	s.Games++
+	if p.Place == 1 && len(m.Players) > 1 {
+		s.Wins++
+	}
+	s.Pieces += int64(p.Pieces)
+	s.Lines += int64(p.Lines)
+	s.LinesSent += int64(p.LinesSent)
+	if m.Frames > 0 {
+		s.PlayMs += m.DurationMs * int64(p.Frames) / int64(m.Frames)
+	}
+	if m.EndedAt.After(s.LastPlayed) {
+		s.LastPlayed = m.EndedAt
+	}
+}
+
+// Store 保存比赛和玩家统计，实现必须可以在多个协程中同时使用
+type Store interface {
+	// Record 保存结束的比赛，没有作废时更新每个玩家的统计
+	// 游戏ID已经存在时返回ErrDuplicateMatch，不覆盖之前的比赛
+	Record(m Match) error
+	// Match 按游戏ID查询比赛
+	Match(gameID string) (Match, error)
+	// Matches 从新到旧第offset场开始的limit场比赛，playerID为空时查询所有比赛，返回总场数
+	Matches(playerID string, offset, limit int) ([]Match, int, error)
+	// Stats 玩家的累计统计，没有比赛的玩家返回只有ID的统计
+	Stats(playerID string) (Stats, error)
+	// Ratings 所有保存过积分的玩家的团队积分
+	Ratings() (map[string]int32, error)
+	// SetRatings 在一个事务中保存多个玩家的团队积分
+	SetRatings(ratings map[string]int32) error
+	Close() error
+}
+
+// maxMemoryMatches MemoryStore保存的比赛数，超出后删除最早的比赛，统计不受影响
+const maxMemoryMatches = 10000
+
+// MemoryStore 保存在内存中的比赛记录，只保留最近的比赛
+type MemoryStore struct {
+	mu      sync.Mutex
+	matches []Match
+	stats   map[string]Stats
+	ratings map[string]int32
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{stats: make(map[string]Stats), ratings: make(map[string]int32)}
+}
+
+func (s *MemoryStore) Record(m Match) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, old := range s.matches {
+		if old.GameID == m.GameID {
+			return ErrDuplicateMatch
+		}
+	}
+	if len(s.matches) == maxMemoryMatches {
+		s.matches = append(s.matches[:0], s.matches[1:]...)
+	}
+	s.matches = append(s.matches, m)
+	if m.Voided {
+		return nil
+	}
+	for _, p := range m.Players {
+		stats := s.stats[p.ID]
+		stats.PlayerID = p.ID
+		stats.add(m, p)
+		s.stats[p.ID] = stats
+	}
+	return nil
+}
+
+func (s *MemoryStore) Match(gameID string) (Match, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.matches {
+		if m.GameID == gameID {
+			return m, nil
+		}
+	}
+	return Match{}, ErrMatchNotFound
+}
+
+func (s *MemoryStore) Matches(playerID string, offset, limit int) ([]Match, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []Match
+	total := 0
+	for i := len(s.matches) - 1; i >= 0; i-- {
+		if playerID != "" && !s.matches[i].played(playerID) {
+			continue
+		}
+		if total >= offset && len(result) < limit {
+			result = append(result, s.matches[i])
+		}
+		total++
+	}
+	return result, total, nil
+}
+
+func (s *MemoryStore) Stats(playerID string) (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats[playerID]
+	stats.PlayerID = playerID
+	return stats, nil
+}
+
+func (s *MemoryStore) Ratings() (map[string]int32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.ratings), nil
+}
+
+func (s *MemoryStore) SetRatings(ratings map[string]int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maps.Copy(s.ratings, ratings)
+	return nil
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// played 玩家是否参加了比赛
+func (m Match) played(playerID string) bool {
+	for _, p := range m.Players {
+		if p.ID == playerID {
+			return true
+		}
+	}
+	return false
+}
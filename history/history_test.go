@@ -0,0 +1,118 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func match(id string, voided bool, players ...MatchPlayer) Match {
+	return Match{
+		GameID:     id,
+		Mode:       "versus",
+		EndedAt:    time.Unix(int64(len(id)), 0),
+		Frames:     1800,
+		DurationMs: 60000,
+		Voided:     voided,
+		Players:    players,
+	}
+}
+
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	records := []Match{
+		match("g1", false,
+			MatchPlayer{ID: "a", Place: 1, Frames: 1800, Pieces: 120, LinesSent: 30},
+			MatchPlayer{ID: "b", Place: 2, Frames: 900, Pieces: 50, LinesSent: 10}),
+		match("g2", true,
+			MatchPlayer{ID: "a", Place: 2, Frames: 1800, Pieces: 500},
+			MatchPlayer{ID: "c", Place: 1, Frames: 1800, Pieces: 500, Flagged: true}),
+		match("g3", false, MatchPlayer{ID: "a", Place: 2, Frames: 1800, Pieces: 60}),
+	}
+	for _, m := range records {
+		if err := s.Record(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 作废的比赛不计入统计，时长按玩家的帧数折算
+	stats, _ := s.Stats("a")
+	if stats.Games != 2 || stats.Wins != 1 || stats.Pieces != 180 || stats.PlayMs != 120000 {
+		t.Fatalf("a stats %+v", stats)
+	}
+	if stats.APM() != 15 || stats.PPS() != 1.5 {
+		t.Fatalf("a APM %v PPS %v, want 15 and 1.5", stats.APM(), stats.PPS())
+	}
+	if stats, _ := s.Stats("b"); stats.PlayMs != 30000 || stats.APM() != 20 {
+		t.Fatalf("b stats %+v", stats)
+	}
+	if stats, _ := s.Stats("z"); stats.PlayerID != "z" || stats.Games != 0 {
+		t.Fatalf("unknown player stats %+v", stats)
+	}
+
+	matches, total, _ := s.Matches("a", 0, 2)
+	if total != 3 || len(matches) != 2 || matches[0].GameID != "g3" || matches[1].GameID != "g2" {
+		t.Fatalf("a matches %+v of %d, want g3 and g2 of 3", matches, total)
+	}
+	if matches, _, _ := s.Matches("a", 2, 2); len(matches) != 1 || matches[0].GameID != "g1" {
+		t.Fatalf("a second page %+v", matches)
+	}
+	if matches, total, _ := s.Matches("", 1, 10); total != 3 || len(matches) != 2 || matches[0].GameID != "g2" {
+		t.Fatalf("all matches %+v of %d", matches, total)
+	}
+	if m, err := s.Match("g2"); err != nil || !m.Voided || len(m.Players) != 2 {
+		t.Fatalf("g2 = %+v, %v", m, err)
+	}
+	if _, err := s.Match("g9"); err != ErrMatchNotFound {
+		t.Fatalf("got %v, want ErrMatchNotFound", err)
+	}
+
+	// 相同游戏ID的比赛不覆盖之前的记录，也不重复计入统计
+	if err := s.Record(records[0]); err != ErrDuplicateMatch {
+		t.Fatalf("recording g1 again = %v, want ErrDuplicateMatch", err)
+	}
+	if _, total, _ := s.Matches("a", 0, 10); total != 3 {
+		t.Fatalf("a has %d matches after duplicate, want 3", total)
+	}
+	if stats, _ := s.Stats("a"); stats.Games != 2 {
+		t.Fatalf("a stats %+v after duplicate", stats)
+	}
+
+	// 积分按玩家覆盖，没有出现的玩家保持不变
+	if err := s.SetRatings(map[string]int32{"a": 1516, "c": 1484}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRatings(map[string]int32{"a": 1530}); err != nil {
+		t.Fatal(err)
+	}
+	if ratings, err := s.Ratings(); err != nil || len(ratings) != 2 || ratings["a"] != 1530 || ratings["c"] != 1484 {
+		t.Fatalf("ratings = %v, %v", ratings, err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	s.Close()
+
+	// 重新打开后数据仍然存在
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if stats, _ := s.Stats("a"); stats.Games != 2 {
+		t.Fatalf("reopened stats %+v", stats)
+	}
+	if ratings, _ := s.Ratings(); ratings["a"] != 1530 {
+		t.Fatalf("reopened ratings %v", ratings)
+	}
+}
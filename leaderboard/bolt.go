@@ -0,0 +1,135 @@
+package leaderboard
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// seasonsBucket 以赛季ID为键保存赛季
+	seasonsBucket = []byte("seasons")
+	// entriesBucket 以模式、赛季和玩家ID为键保存每个玩家的最好成绩
+	entriesBucket = []byte("entries")
+)
+
+// BoltStore 保存在bbolt文件中的排行榜
+// 打开时把所有成绩读入内存，查询都在内存中完成，每次修改在同一个事务中写入文件
+type BoltStore struct {
+	*MemoryStore
+	db *bolt.DB
+}
+
+// NewBoltStore 打开path中的排行榜，文件不存在时创建
+func NewBoltStore(path string, opts Options) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	s := &BoltStore{MemoryStore: NewMemoryStore(opts), db: db}
+	s.journal = s
+	if err := s.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 创建bucket并把赛季和成绩读入内存
+func (s *BoltStore) load() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		seasons, err := tx.CreateBucketIfNotExists(seasonsBucket)
+		if err != nil {
+			return err
+		}
+		entries, err := tx.CreateBucketIfNotExists(entriesBucket)
+		if err != nil {
+			return err
+		}
+		// 键为大端序的赛季ID，遍历顺序即ID顺序
+		err = seasons.ForEach(func(_, v []byte) error {
+			var season Season
+			if err := json.Unmarshal(v, &season); err != nil {
+				return err
+			}
+			s.seasons = append(s.seasons, season)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = entries.ForEach(func(_, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			key := boardKey{entry.Mode, entry.Season}
+			s.boards[key] = append(s.boards[key], entry)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for key, board := range s.boards {
+			order := s.opts.Orders[key.mode]
+			slices.SortFunc(board, func(a, b Entry) int {
+				switch {
+				case s.better(order, a, b):
+					return -1
+				case s.better(order, b, a):
+					return 1
+				}
+				return 0
+			})
+		}
+		return nil
+	})
+}
+
+func seasonKey(id int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+func entryKey(entry Entry) []byte {
+	key := append([]byte(entry.Mode), 0)
+	key = binary.BigEndian.AppendUint64(key, uint64(entry.Season))
+	return append(key, entry.PlayerID...)
+}
+
+func (s *BoltStore) saveEntry(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(entriesBucket).Put(entryKey(entry), data)
+	})
+}
+
+func (s *BoltStore) saveSeasons(seasons ...Season) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(seasonsBucket)
+		for _, season := range seasons {
+			data, err := json.Marshal(season)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(seasonKey(season.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
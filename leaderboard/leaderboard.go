@@ -0,0 +1,320 @@
+// Package leaderboard 按赛季保存单人模式中每个玩家的最好成绩
+package leaderboard
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownMode 没有排行榜的模式
+	ErrUnknownMode = errors.New("unknown leaderboard mode")
+	// ErrSeasonNotFound 查询的赛季不存在
+	ErrSeasonNotFound = errors.New("season not found")
+	// ErrSeasonEnd 新赛季的结束时间不在当前时间之后
+	ErrSeasonEnd = errors.New("season end must be in the future")
+)
+
+// Order 成绩的排序方向
+type Order int
+
+const (
+	// Ascending 数值小的成绩更好，例如用时
+	Ascending Order = iota
+	// Descending 数值大的成绩更好，例如得分
+	Descending
+)
+
+// Season 排行榜的赛季，End为零时没有预定的结束时间
+// 赛季结束后归档，归档的赛季只能查询，不再接受成绩
+type Season struct {
+	ID       int       `json:"id"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Archived bool      `json:"archived"`
+}
+
+// Entry 玩家在某个模式和赛季中的一次成绩
+type Entry struct {
+	Mode        string    `json:"mode"`
+	Season      int       `json:"season"`
+	PlayerID    string    `json:"player_id"`
+	Value       int64     `json:"value"`
+	GameID      string    `json:"game_id,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Ranked 带有名次的成绩，名次从1开始
+type Ranked struct {
+	Entry
+	Rank int `json:"rank"`
+}
+
+// Query 分页查询，Season为0时查询当前赛季
+type Query struct {
+	Mode   string
+	Season int
+	Offset int
+	Limit  int
+}
+
+// Page 一页成绩，Total为该赛季排行榜的总人数
+type Page struct {
+	Season  Season   `json:"season"`
+	Total   int      `json:"total"`
+	Entries []Ranked `json:"entries"`
+}
+
+// Store 按模式和赛季保存每个玩家的最好成绩，实现必须可以在多个协程中同时使用
+type Store interface {
+	// Submit 把成绩提交到当前赛季，只保留玩家最好的一次，成绩相同时先提交的排在前面
+	// 返回玩家当前的成绩和名次，以及这次成绩是否刷新了最好成绩
+	Submit(entry Entry) (Ranked, bool, error)
+	// Top 从Offset开始的Limit名
+	Top(q Query) (Page, error)
+	// Around 玩家及其前后各Limit名，玩家没有成绩时Entries为空，不使用Offset
+	Around(q Query, playerID string) (Page, error)
+	// Seasons 所有赛季，按ID排序，最后一个为当前赛季
+	Seasons() ([]Season, error)
+	// StartSeason 立即结束并归档当前赛季，开始新的赛季，end为零时按赛季长度计算
+	StartSeason(end time.Time) (Season, error)
+	Close() error
+}
+
+// Options 排行榜的赛季设置
+type Options struct {
+	// Orders 每个模式的排序方向
+	Orders map[string]Order
+	// SeasonLength 赛季长度，赛季结束后自动开始下一个赛季，0表示赛季只能手动结束
+	SeasonLength time.Duration
+	// Now 当前时间，为空时使用time.Now
+	Now func() time.Time
+}
+
+// journal 在修改内存中的排行榜之前保存修改，返回错误时不做修改
+type journal interface {
+	saveEntry(entry Entry) error
+	saveSeasons(seasons ...Season) error
+}
+
+type boardKey struct {
+	mode   string
+	season int
+}
+
+// MemoryStore 保存在内存中的排行榜，BoltStore在它的基础上把修改写入文件
+type MemoryStore struct {
+	mu      sync.Mutex
+	opts    Options
+	journal journal
+	seasons []Season
+	// boards 每个模式和赛季按名次排列的成绩
+	boards map[boardKey][]Entry
+}
+
+// NewMemoryStore 创建空的排行榜，第一个赛季在第一次使用时开始
+func NewMemoryStore(opts Options) *MemoryStore {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &MemoryStore{
+		opts:   opts,
+		boards: make(map[boardKey][]Entry),
+	}
+}
+
+// current 返回当前赛季，当前赛季已经结束时归档并开始下一个赛季
+// 中间跳过的赛季长度不会产生空的赛季
+func (s *MemoryStore) current() (Season, error) {
+	now := s.opts.Now()
+	if len(s.seasons) == 0 {
+		return s.startSeason(Season{ID: 1, Start: now, End: s.seasonEnd(now)})
+	}
+	cur := s.seasons[len(s.seasons)-1]
+	if cur.End.IsZero() || now.Before(cur.End) {
+		return cur, nil
+	}
+	next := Season{ID: cur.ID + 1, Start: cur.End}
+	if length := s.opts.SeasonLength; length > 0 {
+		next.Start = cur.End.Add(now.Sub(cur.End) / length * length)
+	}
+	next.End = s.seasonEnd(next.Start)
+	return s.startSeason(next)
+}
+
+func (s *MemoryStore) seasonEnd(start time.Time) time.Time {
+	if s.opts.SeasonLength <= 0 {
+		return time.Time{}
+	}
+	return start.Add(s.opts.SeasonLength)
+}
+
+// startSeason 归档当前赛季并开始next
+func (s *MemoryStore) startSeason(next Season) (Season, error) {
+	changed := []Season{next}
+	if n := len(s.seasons); n > 0 {
+		last := s.seasons[n-1]
+		last.Archived = true
+		if last.End.IsZero() || last.End.After(next.Start) {
+			last.End = next.Start
+		}
+		changed = append([]Season{last}, changed...)
+	}
+	if s.journal != nil {
+		if err := s.journal.saveSeasons(changed...); err != nil {
+			return Season{}, err
+		}
+	}
+	if n := len(s.seasons); n > 0 {
+		s.seasons[n-1] = changed[0]
+	}
+	s.seasons = append(s.seasons, next)
+	return next, nil
+}
+
+// season 按ID查找赛季，id为0时返回当前赛季
+func (s *MemoryStore) season(id int) (Season, error) {
+	if id == 0 {
+		return s.current()
+	}
+	i := sort.Search(len(s.seasons), func(i int) bool { return s.seasons[i].ID >= id })
+	if i == len(s.seasons) || s.seasons[i].ID != id {
+		return Season{}, ErrSeasonNotFound
+	}
+	return s.seasons[i], nil
+}
+
+// better a是否排在b之前
+func (s *MemoryStore) better(order Order, a, b Entry) bool {
+	if a.Value != b.Value {
+		if order == Descending {
+			return a.Value > b.Value
+		}
+		return a.Value < b.Value
+	}
+	return a.SubmittedAt.Before(b.SubmittedAt)
+}
+
+func (s *MemoryStore) Submit(entry Entry) (Ranked, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.opts.Orders[entry.Mode]
+	if !ok {
+		return Ranked{}, false, ErrUnknownMode
+	}
+	season, err := s.current()
+	if err != nil {
+		return Ranked{}, false, err
+	}
+	entry.Season = season.ID
+	if entry.SubmittedAt.IsZero() {
+		entry.SubmittedAt = s.opts.Now()
+	}
+
+	key := boardKey{entry.Mode, season.ID}
+	board := s.boards[key]
+	i := slices.IndexFunc(board, func(e Entry) bool { return e.PlayerID == entry.PlayerID })
+	if i >= 0 && !s.better(order, entry, board[i]) {
+		return Ranked{Entry: board[i], Rank: i + 1}, false, nil
+	}
+	if s.journal != nil {
+		if err := s.journal.saveEntry(entry); err != nil {
+			return Ranked{}, false, err
+		}
+	}
+	if i >= 0 {
+		board = slices.Delete(board, i, i+1)
+	}
+	return s.insert(key, board, order, entry), true, nil
+}
+
+// insert 把entry按名次放入board
+func (s *MemoryStore) insert(key boardKey, board []Entry, order Order, entry Entry) Ranked {
+	i := sort.Search(len(board), func(i int) bool { return s.better(order, entry, board[i]) })
+	board = append(board, Entry{})
+	copy(board[i+1:], board[i:])
+	board[i] = entry
+	s.boards[key] = board
+	return Ranked{Entry: entry, Rank: i + 1}
+}
+
+// page 查找赛季的排行榜
+func (s *MemoryStore) page(q Query) (Page, []Entry, error) {
+	if _, ok := s.opts.Orders[q.Mode]; !ok {
+		return Page{}, nil, ErrUnknownMode
+	}
+	season, err := s.season(q.Season)
+	if err != nil {
+		return Page{}, nil, err
+	}
+	board := s.boards[boardKey{q.Mode, season.ID}]
+	return Page{Season: season, Total: len(board)}, board, nil
+}
+
+func (s *MemoryStore) Top(q Query) (Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page, board, err := s.page(q)
+	if err != nil {
+		return Page{}, err
+	}
+	from := min(max(q.Offset, 0), len(board))
+	page.Entries = ranked(board, from, min(from+max(q.Limit, 0), len(board)))
+	return page, nil
+}
+
+func (s *MemoryStore) Around(q Query, playerID string) (Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	page, board, err := s.page(q)
+	if err != nil {
+		return Page{}, err
+	}
+	n := max(q.Limit, 0)
+	for i, e := range board {
+		if e.PlayerID == playerID {
+			page.Entries = ranked(board, max(0, i-n), min(len(board), i+n+1))
+			break
+		}
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) Seasons() ([]Season, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.current(); err != nil {
+		return nil, err
+	}
+	return append([]Season(nil), s.seasons...), nil
+}
+
+func (s *MemoryStore) StartSeason(end time.Time) (Season, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, err := s.current()
+	if err != nil {
+		return Season{}, err
+	}
+	now := s.opts.Now()
+	if end.IsZero() {
+		end = s.seasonEnd(now)
+	} else if !end.After(now) {
+		return Season{}, ErrSeasonEnd
+	}
+	return s.startSeason(Season{ID: cur.ID + 1, Start: now, End: end})
+}
+
+func (s *MemoryStore) Close() error { return nil }
+
+// ranked 返回board[from:to]及其名次
+func ranked(board []Entry, from, to int) []Ranked {
+	result := make([]Ranked, 0, to-from)
+	for i := from; i < to; i++ {
+		result = append(result, Ranked{Entry: board[i], Rank: i + 1})
+	}
+	return result
+}
@@ -0,0 +1,168 @@
+package leaderboard
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var orders = map[string]Order{"sprint": Ascending, "ultra": Descending}
+
+// testClock 测试中手动推进的时间
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func entry(mode, player string, value int64, at int) Entry {
+	return Entry{Mode: mode, PlayerID: player, Value: value, SubmittedAt: time.Unix(int64(at), 0)}
+}
+
+func players(ranked []Ranked) []string {
+	ids := make([]string, len(ranked))
+	for i, r := range ranked {
+		ids[i] = r.PlayerID
+	}
+	return ids
+}
+
+func TestSubmitKeepsPersonalBest(t *testing.T) {
+	s := NewMemoryStore(Options{Orders: orders})
+	for _, e := range []Entry{
+		entry("sprint", "a", 60000, 1),
+		entry("sprint", "b", 50000, 2),
+		entry("sprint", "c", 50000, 3),
+	} {
+		if _, _, err := s.Submit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 更慢的成绩不会替换最好成绩
+	if r, best, _ := s.Submit(entry("sprint", "b", 70000, 4)); r.Rank != 1 || r.Value != 50000 || best {
+		t.Fatalf("got %+v best %v, want the old rank 1", r, best)
+	}
+	if r, best, _ := s.Submit(entry("sprint", "a", 40000, 5)); r.Rank != 1 || r.Season != 1 || !best {
+		t.Fatalf("got %+v best %v, want a new best at rank 1 in season 1", r, best)
+	}
+
+	page, _ := s.Top(Query{Mode: "sprint", Limit: 10})
+	if got := players(page.Entries); len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("top = %v, want a, then b before c on the earlier submission", got)
+	}
+	if page.Total != 3 || page.Entries[2].Rank != 3 || page.Entries[0].Value != 40000 {
+		t.Fatalf("got %+v", page)
+	}
+	page, _ = s.Top(Query{Mode: "sprint", Offset: 2, Limit: 10})
+	if got := players(page.Entries); len(got) != 1 || got[0] != "c" || page.Entries[0].Rank != 3 {
+		t.Fatalf("second page = %+v", page)
+	}
+
+	if r, _, _ := s.Submit(entry("ultra", "a", 900, 6)); r.Rank != 1 {
+		t.Fatalf("ultra rank %d", r.Rank)
+	}
+	if r, _, _ := s.Submit(entry("ultra", "b", 1200, 7)); r.Rank != 1 {
+		t.Fatalf("higher ultra score ranked %d, want 1", r.Rank)
+	}
+	if _, _, err := s.Submit(entry("marathon", "a", 1, 8)); err != ErrUnknownMode {
+		t.Fatalf("got %v, want ErrUnknownMode", err)
+	}
+}
+
+func TestAround(t *testing.T) {
+	s := NewMemoryStore(Options{Orders: orders})
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		s.Submit(entry("ultra", id, int64(100-i), i))
+	}
+	page, _ := s.Around(Query{Mode: "ultra", Limit: 1}, "d")
+	if got := players(page.Entries); len(got) != 3 || got[0] != "c" || got[2] != "e" || page.Entries[1].Rank != 4 {
+		t.Fatalf("around d = %+v", page)
+	}
+	if page, _ := s.Around(Query{Mode: "ultra", Limit: 2}, "a"); len(page.Entries) != 3 {
+		t.Fatalf("around the first place = %+v, want 3 entries", page)
+	}
+	if page, _ := s.Around(Query{Mode: "ultra", Limit: 2}, "z"); len(page.Entries) != 0 || page.Total != 5 {
+		t.Fatalf("around a player without entries = %+v", page)
+	}
+}
+
+func TestSeasons(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	week := 7 * 24 * time.Hour
+	s := NewMemoryStore(Options{Orders: orders, SeasonLength: week, Now: clock.Now})
+	s.Submit(entry("sprint", "a", 50000, 1))
+
+	// 赛季结束后成绩进入新的赛季，跳过的时间不产生空的赛季
+	clock.now = clock.now.Add(3*week + time.Hour)
+	if r, best, _ := s.Submit(entry("sprint", "a", 60000, 2)); r.Season != 2 || r.Rank != 1 || !best {
+		t.Fatalf("got %+v best %v, want a first entry in season 2", r, best)
+	}
+	seasons, _ := s.Seasons()
+	if len(seasons) != 2 || !seasons[0].Archived || seasons[1].Archived ||
+		!seasons[1].Start.Equal(time.Unix(1000, 0).Add(3*week)) || !seasons[1].End.Equal(seasons[1].Start.Add(week)) {
+		t.Fatalf("seasons %+v", seasons)
+	}
+
+	// 归档的赛季仍然可以查询
+	page, err := s.Top(Query{Mode: "sprint", Season: 1, Limit: 10})
+	if err != nil || page.Total != 1 || page.Entries[0].Value != 50000 || !page.Season.Archived {
+		t.Fatalf("season 1 = %+v, %v", page, err)
+	}
+	if _, err := s.Top(Query{Mode: "sprint", Season: 9}); err != ErrSeasonNotFound {
+		t.Fatalf("got %v, want ErrSeasonNotFound", err)
+	}
+
+	// 手动开始的赛季提前结束当前赛季
+	if _, err := s.StartSeason(clock.now.Add(-time.Hour)); err != ErrSeasonEnd {
+		t.Fatalf("got %v, want ErrSeasonEnd", err)
+	}
+	season, err := s.StartSeason(time.Time{})
+	if err != nil || season.ID != 3 || !season.End.Equal(clock.now.Add(week)) {
+		t.Fatalf("started %+v, %v", season, err)
+	}
+	seasons, _ = s.Seasons()
+	if !seasons[1].End.Equal(clock.now) || !seasons[1].Archived {
+		t.Fatalf("season 2 = %+v, want it to end now", seasons[1])
+	}
+	if page, _ := s.Top(Query{Mode: "sprint", Limit: 10}); page.Season.ID != 3 || page.Total != 0 {
+		t.Fatalf("current season = %+v, want an empty season 3", page)
+	}
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "leaderboard.db")
+	clock := &testClock{now: time.Unix(1000, 0)}
+	opts := Options{Orders: orders, Now: clock.Now}
+	s, err := NewBoltStore(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Entry{
+		entry("sprint", "a", 45000, 1),
+		entry("sprint", "b", 40000, 2),
+		entry("sprint", "a", 39000, 3),
+	} {
+		if _, _, err := s.Submit(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.StartSeason(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	s.Submit(entry("sprint", "c", 50000, 4))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewBoltStore(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	page, _ := s.Top(Query{Mode: "sprint", Season: 1, Limit: 10})
+	if got := players(page.Entries); len(got) != 2 || got[0] != "a" || page.Entries[0].Value != 39000 || !page.Season.Archived {
+		t.Fatalf("reloaded season 1 = %+v", page)
+	}
+	page, _ = s.Top(Query{Mode: "sprint", Limit: 10})
+	if page.Season.ID != 2 || page.Total != 1 || page.Entries[0].PlayerID != "c" {
+		t.Fatalf("reloaded current season = %+v", page)
+	}
+}
@@ -0,0 +1,204 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 子系统名称，每个子系统可以单独设置日志级别
+const (
+	Server  = "server"
+	Network = "network"
+	Room    = "room"
+	Game    = "game"
+	Admin   = "admin"
+)
+
+// 日志中使用的字段名
+const (
+	KeySubsystem = "subsystem"
+	KeyConn      = "conn"
+	KeyRemote    = "remote"
+	KeyPlayer    = "player"
+	KeyRoom      = "room"
+	KeyGame      = "game"
+	KeyError     = "err"
+)
+
+type Config struct {
+	// Format 为text或json
+	Format string
+	// Level 默认日志级别：debug、info、warn、error
+	Level string
+	// Levels 按子系统覆盖的日志级别
+	Levels map[string]string
+	// MessageSample 高频消息（帧同步、输入、心跳、延迟测量、状态校验、实时统计）每MessageSample条记录一条，0表示不记录
+	MessageSample uint64
+}
+
+var (
+	mu        sync.Mutex
+	base      slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	defLevel               = slog.LevelInfo
+	overrides              = map[string]slog.Level{}
+	levels                 = map[string]*slog.LevelVar{}
+	// generation 每次Setup后加一，Logger据此重新构建输出
+	generation atomic.Uint64
+)
+
+// Setup 设置日志输出格式和级别，应在创建任何Logger之前调用
+func Setup(cfg Config, w io.Writer) error {
+	handler, err := newHandler(cfg.Format, w)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	base = handler
+	generation.Add(1)
+	mu.Unlock()
+
+	if err := SetLevels(cfg.Level, cfg.Levels); err != nil {
+		return err
+	}
+	messageSampler.setEvery(cfg.MessageSample)
+	slog.SetDefault(For(Server))
+	return nil
+}
+
+// Validate 检查配置是否合法，不修改当前设置
+func Validate(cfg Config) error {
+	if _, err := newHandler(cfg.Format, io.Discard); err != nil {
+		return err
+	}
+	_, _, err := parseLevels(cfg.Level, cfg.Levels)
+	return err
+}
+
+func newHandler(format string, w io.Writer) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// SetLevels 修改默认级别和各子系统的级别，已经创建的Logger立即生效
+func SetLevels(level string, subsystems map[string]string) error {
+	def, parsed, err := parseLevels(level, subsystems)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	defLevel = def
+	overrides = parsed
+	for sub, v := range levels {
+		v.Set(levelOf(sub))
+	}
+	return nil
+}
+
+func parseLevels(level string, subsystems map[string]string) (slog.Level, map[string]slog.Level, error) {
+	def := slog.LevelInfo
+	if level != "" {
+		if err := def.UnmarshalText([]byte(level)); err != nil {
+			return def, nil, fmt.Errorf("invalid log level %q: %w", level, err)
+		}
+	}
+	parsed := make(map[string]slog.Level, len(subsystems))
+	for sub, l := range subsystems {
+		var lv slog.Level
+		if err := lv.UnmarshalText([]byte(l)); err != nil {
+			return def, nil, fmt.Errorf("invalid log level %q for %s: %w", l, sub, err)
+		}
+		parsed[sub] = lv
+	}
+	return def, parsed, nil
+}
+
+// levelOf 调用方需持有mu
+func levelOf(sub string) slog.Level {
+	if l, ok := overrides[sub]; ok {
+		return l
+	}
+	return defLevel
+}
+
+// For 返回子系统的Logger
+// 返回的Logger在之后调用Setup时会切换到新的输出，可以保存在包级变量中
+func For(sub string) *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	v, ok := levels[sub]
+	if !ok {
+		v = &slog.LevelVar{}
+		v.Set(levelOf(sub))
+		levels[sub] = v
+	}
+	h := &levelHandler{level: v}
+	return slog.New(h.with(func(next slog.Handler) slog.Handler {
+		return next.WithAttrs([]slog.Attr{slog.String(KeySubsystem, sub)})
+	}))
+}
+
+// levelHandler 按子系统的级别过滤日志
+// WithAttrs和WithGroup记录为ops，在输出变化后重新应用到新的base上
+type levelHandler struct {
+	level *slog.LevelVar
+	ops   []func(slog.Handler) slog.Handler
+	cache atomic.Pointer[cachedHandler]
+}
+
+type cachedHandler struct {
+	generation uint64
+	handler    slog.Handler
+}
+
+func (h *levelHandler) with(op func(slog.Handler) slog.Handler) *levelHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &levelHandler{level: h.level, ops: append(ops, op)}
+}
+
+func (h *levelHandler) next() slog.Handler {
+	gen := generation.Load()
+	if c := h.cache.Load(); c != nil && c.generation == gen {
+		return c.handler
+	}
+	mu.Lock()
+	next, gen := base, generation.Load()
+	mu.Unlock()
+	for _, op := range h.ops {
+		next = op(next)
+	}
+	h.cache.Store(&cachedHandler{generation: gen, handler: next})
+	return next
+}
+
+func (h *levelHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next().Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
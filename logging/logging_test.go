@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSubsystemLevels(t *testing.T) {
+	// Setup之前创建的Logger也使用新的输出
+	early := For(Admin).With(KeyPlayer, "p1")
+	var buf bytes.Buffer
+	if err := Setup(Config{Format: "json", Level: "warn", Levels: map[string]string{Game: "debug"}}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(Config{}, &bytes.Buffer{}) })
+
+	For(Network).Info("hidden")
+	For(Game).With(KeyRoom, "1", KeyGame, "1-1").Debug("shown")
+	early.Warn("early")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want 2: %q", len(lines), buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["msg"] != "shown" || entry[KeySubsystem] != Game || entry[KeyRoom] != "1" || entry[KeyGame] != "1-1" {
+		t.Fatalf("got entry %v", entry)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry[KeyPlayer] != "p1" || entry[KeySubsystem] != Admin {
+		t.Fatalf("got early entry %v, %v", entry, err)
+	}
+
+	// 修改级别对已经创建的Logger立即生效
+	logger := For(Network)
+	if err := SetLevels("info", nil); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("now shown")
+	if !strings.Contains(buf.String(), "now shown") {
+		t.Fatal("level change did not apply to existing logger")
+	}
+}
+
+func TestSetupRejectsInvalidConfig(t *testing.T) {
+	if err := Setup(Config{Format: "xml"}, &bytes.Buffer{}); err == nil {
+		t.Fatal("Setup accepted unknown format")
+	}
+	if err := SetLevels("loud", nil); err == nil {
+		t.Fatal("SetLevels accepted unknown level")
+	}
+}
+
+func TestSampleMessage(t *testing.T) {
+	s := &sampler{}
+	if !s.sample("s2c_create_room") {
+		t.Fatal("low frequency message was not logged")
+	}
+	for _, msgType := range []string{"s2c_sync_frames", "s2c_ping", "c2s_pong", "c2s_state_hash", "s2c_game_stats"} {
+		if s.sample(msgType) {
+			t.Fatalf("high frequency message %s logged with sampling disabled", msgType)
+		}
+	}
+
+	s.setEvery(3)
+	var logged int
+	for i := 0; i < 9; i++ {
+		if s.sample("c2s_input") {
+			logged++
+		}
+	}
+	if logged != 3 {
+		t.Fatalf("logged %d of 9 messages, want 3", logged)
+	}
+}
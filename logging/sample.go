@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// highFrequency 每秒会出现数十次的消息类型，只按比例记录
+var highFrequency = map[string]bool{
+	"s2c_sync_frames": true,
+	"c2s_input":       true,
+	"c2s_heartbeat":   true,
+	"s2c_ping":        true,
+	"c2s_pong":        true,
+	"c2s_state_hash":  true,
+	"s2c_game_stats":  true,
+}
+
+type sampler struct {
+	every  atomic.Uint64
+	counts sync.Map // 消息类型 -> *atomic.Uint64
+}
+
+var messageSampler = &sampler{}
+
+func (s *sampler) setEvery(every uint64) {
+	s.every.Store(every)
+}
+
+func (s *sampler) sample(msgType string) bool {
+	if !highFrequency[msgType] {
+		return true
+	}
+	every := s.every.Load()
+	if every == 0 {
+		return false
+	}
+	counter, _ := s.counts.LoadOrStore(msgType, &atomic.Uint64{})
+	n := counter.(*atomic.Uint64).Add(1)
+	return (n-1)%every == 0
+}
+
+// SampleMessage 返回是否应记录一条类型为msgType的收发消息
+// 低频消息总是记录，高频消息按Config.MessageSample采样
+func SampleMessage(msgType string) bool {
+	return messageSampler.sample(msgType)
+}
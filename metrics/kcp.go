@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xtaci/kcp-go"
+)
+
+// kcpCollector 在采集时读取kcp.DefaultSnmp
+type kcpCollector struct {
+	counters []kcpCounter
+	estab    *prometheus.Desc
+}
+
+type kcpCounter struct {
+	desc  *prometheus.Desc
+	value func(*kcp.Snmp) uint64
+}
+
+func newKCPCollector() *kcpCollector {
+	counter := func(name, help string, value func(*kcp.Snmp) uint64) kcpCounter {
+		return kcpCounter{
+			desc:  prometheus.NewDesc(prometheus.BuildFQName(namespace, "kcp", name), help, nil, nil),
+			value: value,
+		}
+	}
+	return &kcpCollector{
+		counters: []kcpCounter{
+			counter("in_packets_total", "UDP packets received.", func(s *kcp.Snmp) uint64 { return s.InPkts }),
+			counter("out_packets_total", "UDP packets sent.", func(s *kcp.Snmp) uint64 { return s.OutPkts }),
+			counter("in_bytes_total", "UDP bytes received.", func(s *kcp.Snmp) uint64 { return s.InBytes }),
+			counter("out_bytes_total", "UDP bytes sent.", func(s *kcp.Snmp) uint64 { return s.OutBytes }),
+			counter("in_segments_total", "KCP segments received.", func(s *kcp.Snmp) uint64 { return s.InSegs }),
+			counter("out_segments_total", "KCP segments sent.", func(s *kcp.Snmp) uint64 { return s.OutSegs }),
+			counter("retransmitted_segments_total", "KCP segments retransmitted.", func(s *kcp.Snmp) uint64 { return s.RetransSegs }),
+			counter("fast_retransmitted_segments_total", "KCP segments fast retransmitted.", func(s *kcp.Snmp) uint64 { return s.FastRetransSegs }),
+			counter("early_retransmitted_segments_total", "KCP segments early retransmitted.", func(s *kcp.Snmp) uint64 { return s.EarlyRetransSegs }),
+			counter("lost_segments_total", "KCP segments inferred as lost.", func(s *kcp.Snmp) uint64 { return s.LostSegs }),
+			counter("repeated_segments_total", "Duplicated KCP segments received.", func(s *kcp.Snmp) uint64 { return s.RepeatSegs }),
+			counter("in_errors_total", "UDP read errors.", func(s *kcp.Snmp) uint64 { return s.InErrs }),
+			counter("input_errors_total", "Packets rejected by KCP input.", func(s *kcp.Snmp) uint64 { return s.KCPInErrors }),
+		},
+		estab: prometheus.NewDesc(prometheus.BuildFQName(namespace, "kcp", "established_sessions"),
+			"KCP sessions currently established.", nil, nil),
+	}
+}
+
+func (c *kcpCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, counter := range c.counters {
+		ch <- counter.desc
+	}
+	ch <- c.estab
+}
+
+func (c *kcpCollector) Collect(ch chan<- prometheus.Metric) {
+	snmp := kcp.DefaultSnmp.Copy()
+	for _, counter := range c.counters {
+		ch <- prometheus.MustNewConstMetric(counter.desc, prometheus.CounterValue, float64(counter.value(snmp)))
+	}
+	ch <- prometheus.MustNewConstMetric(c.estab, prometheus.GaugeValue, float64(snmp.CurrEstab))
+}
@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"TetrisSvr/logging"
+	pb "TetrisSvr/proto"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tetris"
+
+// Registry 服务器所有指标的注册表
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	ConnectionsAccepted = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connections_accepted_total",
+		Help:      "Number of accepted KCP connections.",
+	})
+	ConnectionsActive = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connections_active",
+		Help:      "Number of open connections.",
+	})
+
+	MessagesReceived = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Number of messages received from clients by type.",
+	}, []string{"type"})
+	MessagesSent = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Number of messages written to clients by type.",
+	}, []string{"type"})
+	// SendDrops 发送队列已满而丢弃的消息，path为丢弃发生的位置
+	SendDrops = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_drops_total",
+		Help:      "Number of messages dropped because the connection send queue was full.",
+	}, []string{"path"})
+
+	RoomsActive = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rooms_active",
+		Help:      "Number of rooms.",
+	})
+	GamesActive = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "games_active",
+		Help:      "Number of running games.",
+	})
+
+	TickDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tick_duration_seconds",
+		Help:      "Time spent computing and sending one lockstep frame.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 12),
+	})
+	// TickJitter 两次tick的实际间隔与帧间隔之差的绝对值
+	TickJitter = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tick_jitter_seconds",
+		Help:      "Absolute difference between the observed and the configured tick interval.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 10),
+	})
+	// InputAdjustments 处理输入的结果：accepted、corrected、rejected_future、rejected_invalid
+	InputAdjustments = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "input_adjustments_total",
+		Help:      "Player inputs by how their frame number was handled.",
+	}, []string{"result"})
+	// GarbageLines 路由的攻击行数，result为sent或canceled
+	GarbageLines = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "garbage_lines_total",
+		Help:      "Garbage lines routed between players by result.",
+	}, []string{"result"})
+	// CheatFlags 按原因统计被标记的玩家
+	CheatFlags = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cheat_flags_total",
+		Help:      "Players flagged by server-side input validation by reason.",
+	}, []string{"reason"})
+	// Desyncs 玩家状态哈希不一致的次数
+	Desyncs = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "desyncs_total",
+		Help:      "Frames where players reported different state hashes.",
+	})
+	// ConnRTT 心跳测得的往返时间
+	ConnRTT = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "conn_rtt_seconds",
+		Help:      "Round-trip time measured by server-initiated pings.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 10),
+	})
+	// FrameBacklog 每局游戏中同步最落后的玩家落后的帧数
+	FrameBacklog = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "game_frame_backlog",
+		Help:      "Frames not yet delivered to the furthest behind player of a game.",
+	}, []string{"game"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newKCPCollector(),
+	)
+}
+
+// Handler 返回/metrics的处理函数
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve 在addr上提供/metrics，返回的服务器由调用方关闭
+func Serve(addr string) (*http.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", Handler())
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			logging.For(logging.Server).Error("指标接口异常退出", logging.KeyError, err)
+		}
+	}()
+	logging.For(logging.Server).Info("指标接口启动成功", "addr", lis.Addr().String())
+	return srv, nil
+}
+
+// MessageType 返回消息在MessageWrapper中的字段名，例如c2s_input
+func MessageType(msg *pb.MessageWrapper) string {
+	m := msg.ProtoReflect()
+	oneof := m.Descriptor().Oneofs().ByName("msg")
+	if oneof == nil {
+		return "unknown"
+	}
+	field := m.WhichOneof(oneof)
+	if field == nil {
+		return "empty"
+	}
+	return string(field.Name())
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	pb "TetrisSvr/proto"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessageType(t *testing.T) {
+	msg := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SInput{C2SInput: &pb.C2S_Input{}},
+	}
+	if got := MessageType(msg); got != "c2s_input" {
+		t.Fatalf("MessageType = %q, want c2s_input", got)
+	}
+	if got := MessageType(&pb.MessageWrapper{}); got != "empty" {
+		t.Fatalf("MessageType(empty) = %q, want empty", got)
+	}
+}
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	SendDrops.WithLabelValues("sync_frames").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, name := range []string{
+		`tetris_send_drops_total{path="sync_frames"}`,
+		"tetris_kcp_retransmitted_segments_total",
+		"tetris_kcp_lost_segments_total",
+		"tetris_connections_active",
+	} {
+		if !strings.Contains(body, name) {
+			t.Errorf("/metrics missing %s", name)
+		}
+	}
+}
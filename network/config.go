@@ -1,6 +1,10 @@
 package network
 
-import "time"
+import (
+	"time"
+
+	"github.com/xtaci/kcp-go"
+)
 
 type Config struct {
 	ReceiveChanSize int32
@@ -8,4 +12,52 @@ type Config struct {
 
 	SendChanSize int32
 	SendTimeout  time.Duration
+
+	// PingInterval 服务器发送心跳的间隔，为0时不发送心跳
+	PingInterval time.Duration
+	// PingTimeout 心跳超过该时间未回复计为丢失，连接超过该时间没有任何消息时关闭
+	// 为0时使用ReceiveTimeout
+	PingTimeout time.Duration
+
+	// KCP 每个会话的KCP参数
+	KCP KCPConfig
+
+	// Clock 为空时使用系统时间
+	Clock Clock
+}
+
+// KCPConfig 对应kcp.UDPSession的SetNoDelay、SetWindowSize和SetMtu参数
+// 值为0的字段保留kcp-go的默认值
+type KCPConfig struct {
+	NoDelay      int
+	Interval     int
+	Resend       int
+	NoCongestion int
+	SendWindow   int
+	RecvWindow   int
+	MTU          int
+}
+
+// apply 将参数设置到会话上
+func (c KCPConfig) apply(s *kcp.UDPSession) {
+	if c.NoDelay != 0 || c.Interval != 0 || c.Resend != 0 || c.NoCongestion != 0 {
+		interval := c.Interval
+		if interval == 0 {
+			interval = 100 // kcp-go的默认间隔
+		}
+		s.SetNoDelay(c.NoDelay, interval, c.Resend, c.NoCongestion)
+	}
+	// WndSize忽略为0的窗口大小
+	s.SetWindowSize(c.SendWindow, c.RecvWindow)
+	if c.MTU != 0 {
+		s.SetMtu(c.MTU)
+	}
+}
+
+// GetClock 返回配置的时钟，未配置时返回RealClock
+func (c *Config) GetClock() Clock {
+	if c.Clock == nil {
+		return RealClock{}
+	}
+	return c.Clock
 }
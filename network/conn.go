@@ -1,16 +1,23 @@
 package network
 
 import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
 	pb "TetrisSvr/proto"
 	"context"
+	"fmt"
+	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	log "github.com/jeanphorn/log4go"
 	"google.golang.org/protobuf/proto"
 )
 
+// nextConnID 连接编号，只用于日志中区分连接
+var nextConnID atomic.Uint64
+
 type IServer interface {
 	Context() context.Context
 	Config() *Config
@@ -21,6 +28,11 @@ type ConnMessage struct {
 	msg  *pb.MessageWrapper
 }
 
+// NewConnMessage 将连接与其收到的消息打包，交给IConnHandler处理
+func NewConnMessage(conn IConn, msg *pb.MessageWrapper) *ConnMessage {
+	return &ConnMessage{conn: conn, msg: msg}
+}
+
 func (m *ConnMessage) Msg() *pb.MessageWrapper {
 	return m.msg
 }
@@ -38,32 +50,87 @@ type IConn interface {
 	SetHandler(hander IConnHandler)
 	SendChan() chan<- *pb.MessageWrapper
 	Start()
+	// ID 连接编号
+	ID() string
+	// LogAttrs 日志中标识该连接的字段：连接编号、远端地址和玩家ID
+	LogAttrs() []any
+	// Stats 心跳测得的网络状况
+	Stats() NetStats
+	// Done 在连接关闭后关闭
+	Done() <-chan struct{}
 }
 
 type Conn struct {
-	ctx     context.Context
-	cancel  context.CancelFunc
-	config  *Config
-	conn    net.Conn
-	handler IConnHandler
+	id       string
+	remote   string
+	playerID atomic.Pointer[string]
+	logger   atomic.Pointer[slog.Logger]
+	ctx      context.Context
+	cancel   context.CancelFunc
+	config   *Config
+	clock    Clock
+	conn     net.Conn
+	handler  atomic.Pointer[IConnHandler]
+	pinger   *pinger
 
 	wg *sync.WaitGroup
 	// receiveChan chan *pb.MessageWrapper
 	sendChan chan *pb.MessageWrapper
+	done     chan struct{}
+}
+
+func (c *Conn) ID() string {
+	return c.id
+}
+
+func (c *Conn) LogAttrs() []any {
+	attrs := []any{logging.KeyConn, c.id, logging.KeyRemote, c.remote}
+	if playerID := c.playerID.Load(); playerID != nil {
+		attrs = append(attrs, logging.KeyPlayer, *playerID)
+	}
+	return attrs
+}
+
+func (c *Conn) Stats() NetStats {
+	return c.pinger.current()
+}
+
+func (c *Conn) log() *slog.Logger {
+	return c.logger.Load()
+}
+
+// bindPlayer 收到第一条带玩家ID的消息后，之后的日志都带上玩家ID
+// 只在ReceiveLoop中调用
+func (c *Conn) bindPlayer(message *pb.MessageWrapper) {
+	if c.playerID.Load() != nil {
+		return
+	}
+	playerID := PlayerID(message)
+	if playerID == "" {
+		return
+	}
+	c.playerID.Store(&playerID)
+	c.logger.Store(logging.For(logging.Network).With(c.LogAttrs()...))
 }
 
 func (c *Conn) SendChan() chan<- *pb.MessageWrapper {
 	return c.sendChan
 }
 
+// SetHandler 之后收到的消息交给handler处理，可以在任意协程中调用
 func (c *Conn) SetHandler(handler IConnHandler) {
-	c.handler = handler
+	c.handler.Store(&handler)
+}
+
+// Done 在连接关闭后关闭
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
 }
 
 func (c *Conn) Close() {
 	err := c.conn.Close()
 	if err != nil {
-		log.Error("关闭连接失败: %v", err)
+		c.log().Error("关闭连接失败", logging.KeyError, err)
 	}
 }
 
@@ -77,74 +144,167 @@ func (c *Conn) ReceiveLoop() {
 
 	buf := make([]byte, 1024)
 	for {
-		c.conn.SetReadDeadline(time.Now().Add(c.config.ReceiveTimeout))
+		c.conn.SetReadDeadline(c.clock.Now().Add(c.config.ReceiveTimeout))
 		n, err := c.conn.Read(buf)
 		if err != nil {
-			log.Error("连接超时中断: %v", err)
+			c.log().Info("连接中断", logging.KeyError, err)
 			c.cancel()
 			break
 		}
 
 		message := &pb.MessageWrapper{}
 		if err := proto.Unmarshal(buf[:n], message); err != nil {
-			log.Error("反序列化数据失败: %v", err)
+			c.log().Error("反序列化数据失败", logging.KeyError, err)
 			c.cancel()
 			break
 		}
-		// log.Info("接收到消息: %s", message)
-		c.handler.HandleChan() <- &ConnMessage{conn: c, msg: message}
+		c.bindPlayer(message)
+		msgType := metrics.MessageType(message)
+		if logging.SampleMessage(msgType) {
+			c.log().Debug("接收到消息", "type", msgType, "msg", message)
+		}
+		metrics.MessagesReceived.WithLabelValues(msgType).Inc()
+
+		now := c.clock.Now()
+		c.pinger.seen(now)
+		if pong := message.GetC2SPong(); pong != nil {
+			// 心跳回复由连接自己处理，不交给handler
+			if rtt, ok := c.pinger.pong(now, pong.GetSeq()); ok {
+				metrics.ConnRTT.Observe(rtt.Seconds())
+			}
+			continue
+		}
+		(*c.handler.Load()).HandleChan() <- NewConnMessage(c, message)
 	}
 }
 
 // SendLoop 监听发送数据
 // 从c.sendChan中获取消息，并将其发送到网络连接
 // 如果发送超时或发生错误，则取消上下文
-// 并退出循环
+// 上下文取消后，先尽量发出已排队的消息再关闭连接
+// 配置了PingInterval时定时发送心跳，超过PingTimeout没有收到任何消息则关闭连接
 func (c *Conn) SendLoop() {
 	c.wg.Add(1)
 	defer c.wg.Done()
+	defer close(c.done)
+	defer c.Close()
+
+	var tick <-chan time.Time
+	if c.config.PingInterval > 0 {
+		ticker := c.clock.NewTicker(c.config.PingInterval)
+		defer ticker.Stop()
+		tick = ticker.C()
+	}
 
 	for {
 		select {
 		case <-c.ctx.Done():
+			c.flush()
 			return
 		case message := <-c.sendChan:
-			log.Info("发送消息: %s", message)
-			data, err := proto.Marshal(message)
-			if err != nil {
-				log.Error("序列化数据失败: %v", err)
+			if err := c.write(message); err != nil {
+				c.cancel()
+			}
+		case now := <-tick:
+			if c.pinger.silent(now) {
+				c.log().Info("心跳超时", "last_seen", c.pinger.current().LastSeen)
 				c.cancel()
-				break
+				continue
 			}
-			c.conn.SetWriteDeadline(time.Now().Add(c.config.SendTimeout))
-			_, err = c.conn.Write(data)
-			if err != nil {
-				log.Error("写入数据失败: %v", err)
+			if err := c.write(c.pinger.ping(now)); err != nil {
 				c.cancel()
 			}
 		}
 	}
 }
 
+// flush 发送sendChan中剩余的消息，遇到错误即放弃
+func (c *Conn) flush() {
+	for {
+		select {
+		case message := <-c.sendChan:
+			if err := c.write(message); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Conn) write(message *pb.MessageWrapper) error {
+	msgType := metrics.MessageType(message)
+	if logging.SampleMessage(msgType) {
+		c.log().Debug("发送消息", "type", msgType, "msg", message)
+	}
+	data, err := proto.Marshal(message)
+	if err != nil {
+		c.log().Error("序列化数据失败", logging.KeyError, err)
+		return err
+	}
+	c.conn.SetWriteDeadline(c.clock.Now().Add(c.config.SendTimeout))
+	_, err = c.conn.Write(data)
+	if err != nil {
+		c.log().Error("写入数据失败", logging.KeyError, err)
+		return err
+	}
+	metrics.MessagesSent.WithLabelValues(msgType).Inc()
+	return nil
+}
+
 func (c *Conn) Start() {
 	go c.ReceiveLoop()
 	go c.SendLoop()
 }
 
 func NewConn(srv IServer, netConn net.Conn, handler IConnHandler) IConn {
+	return newConn(srv, netConn, handler)
+}
+
+func newConn(srv IServer, netConn net.Conn, handler IConnHandler) *Conn {
 	config := srv.Config()
 	ctx, cancel := context.WithCancel(srv.Context())
+	pingTimeout := config.PingTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = config.ReceiveTimeout
+	}
 
 	conn := &Conn{
+		id:       fmt.Sprintf("c%d", nextConnID.Add(1)),
+		remote:   netConn.RemoteAddr().String(),
 		ctx:      ctx,
 		cancel:   cancel,
 		config:   config,
+		clock:    config.GetClock(),
 		conn:     netConn,
-		handler:  handler,
+		pinger:   newPinger(config.GetClock().Now(), pingTimeout),
 		wg:       &sync.WaitGroup{},
 		sendChan: make(chan *pb.MessageWrapper, config.SendChanSize),
+		done:     make(chan struct{}),
 	}
-
-	context.AfterFunc(ctx, conn.Close)
+	conn.handler.Store(&handler)
+	conn.logger.Store(logging.For(logging.Network).With(conn.LogAttrs()...))
 	return conn
 }
+
+// playerMessage 客户端发来的消息都带有玩家ID
+type playerMessage interface {
+	GetPlayerId() string
+}
+
+// PlayerID 返回消息中的玩家ID，没有时返回空字符串
+func PlayerID(message *pb.MessageWrapper) string {
+	m := message.ProtoReflect()
+	oneof := m.Descriptor().Oneofs().ByName("msg")
+	if oneof == nil {
+		return ""
+	}
+	field := m.WhichOneof(oneof)
+	if field == nil || field.Message() == nil {
+		return ""
+	}
+	if inner, ok := m.Get(field).Message().Interface().(playerMessage); ok {
+		return inner.GetPlayerId()
+	}
+	return ""
+}
@@ -0,0 +1,291 @@
+package network_test
+
+import (
+	"TetrisSvr/network"
+	"TetrisSvr/network/nettest"
+	pb "TetrisSvr/proto"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type chanHandler struct {
+	ch chan *network.ConnMessage
+}
+
+func (h *chanHandler) Start() {}
+
+func (h *chanHandler) HandleChan() chan<- *network.ConnMessage {
+	return h.ch
+}
+
+func newTestConn(t *testing.T, cfg *network.Config) (*nettest.PipeConn, *chanHandler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	client, server := nettest.Pipe(cfg.GetClock(), 16)
+	handler := &chanHandler{ch: make(chan *network.ConnMessage, 16)}
+	network.NewConn(&nettest.Server{Ctx: ctx, Cfg: cfg}, server, handler).Start()
+	return client, handler
+}
+
+func testConfig() *network.Config {
+	return &network.Config{
+		ReceiveChanSize: 16,
+		ReceiveTimeout:  time.Second,
+		SendChanSize:    16,
+		SendTimeout:     time.Second,
+	}
+}
+
+func TestConnReceive(t *testing.T) {
+	client, handler := newTestConn(t, testConfig())
+
+	sent := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SCreateRoom{
+			C2SCreateRoom: &pb.C2S_CreateRoom{PlayerId: "p1"},
+		},
+	}
+	data, err := proto.Marshal(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-handler.ch:
+		if !proto.Equal(msg.Msg(), sent) {
+			t.Fatalf("handler got %v, want %v", msg.Msg(), sent)
+		}
+		if msg.Conn() == nil {
+			t.Fatal("handler got message without connection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler did not receive message")
+	}
+}
+
+func TestConnSetHandlerWhileReceiving(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := testConfig()
+	client, server := nettest.Pipe(cfg.GetClock(), 16)
+	first := &chanHandler{ch: make(chan *network.ConnMessage, 16)}
+	second := &chanHandler{ch: make(chan *network.ConnMessage, 16)}
+	conn := network.NewConn(&nettest.Server{Ctx: ctx, Cfg: cfg}, server, first)
+	conn.Start()
+
+	data, err := proto.Marshal(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SCreateRoom{
+			C2SCreateRoom: &pb.C2S_CreateRoom{PlayerId: "p1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 与ReceiveLoop并发切换handler，由-race检查
+	const count = 8
+	go func() {
+		for range count {
+			client.Write(data)
+		}
+	}()
+	conn.SetHandler(second)
+	for range count {
+		select {
+		case <-first.ch:
+		case <-second.ch:
+		case <-time.After(time.Second):
+			t.Fatal("handler did not receive message")
+		}
+	}
+}
+
+func TestConnSend(t *testing.T) {
+	client, handler := newTestConn(t, testConfig())
+
+	// 先让服务器收到一条消息以拿到IConn
+	data, _ := proto.Marshal(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SHeartbeat{C2SHeartbeat: &pb.C2S_Heartbeat{PlayerId: "p1"}},
+	})
+	client.Write(data)
+	var conn network.IConn
+	select {
+	case msg := <-handler.ch:
+		conn = msg.Conn()
+	case <-time.After(time.Second):
+		t.Fatal("handler did not receive message")
+	}
+
+	reply := &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CExitRoom{S2CExitRoom: &pb.S2C_ExitRoom{Error: true, ErrorMsg: "boom"}},
+	}
+	conn.SendChan() <- reply
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &pb.MessageWrapper{}
+	if err := proto.Unmarshal(buf[:n], got); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, reply) {
+		t.Fatalf("client got %v, want %v", got, reply)
+	}
+}
+
+func TestConnReceiveTimeoutClosesConn(t *testing.T) {
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	cfg := testConfig()
+	cfg.Clock = clock
+	cfg.ReceiveTimeout = 30 * time.Second
+	client, _ := newTestConn(t, cfg)
+
+	// 等待ReceiveLoop设置好读取截止时间后再推进时钟
+	clock.BlockUntil(1)
+	clock.Advance(30 * time.Second)
+
+	_, err := client.Read(make([]byte, 16))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Read after server timeout = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestConnFlushesQueuedMessagesOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := testConfig()
+	client, server := nettest.Pipe(cfg.GetClock(), 16)
+	handler := &chanHandler{ch: make(chan *network.ConnMessage, 16)}
+	conn := network.NewConn(&nettest.Server{Ctx: ctx, Cfg: cfg}, server, handler)
+
+	// 在发送协程启动前排队，保证关闭时消息仍在队列中
+	for i := 0; i < 3; i++ {
+		conn.SendChan() <- &pb.MessageWrapper{
+			Msg: &pb.MessageWrapper_S2CServerShutdown{S2CServerShutdown: &pb.S2C_ServerShutdown{Reason: "bye"}},
+		}
+	}
+	cancel()
+	conn.Start()
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := client.Read(buf); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	<-conn.(*network.Conn).Done()
+	if _, err := client.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Read after close = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func readMessage(t *testing.T, client *nettest.PipeConn) *pb.MessageWrapper {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &pb.MessageWrapper{}
+	if err := proto.Unmarshal(buf[:n], msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func newPingConn(t *testing.T, clock *nettest.ManualClock) (*nettest.PipeConn, *network.Conn) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := testConfig()
+	cfg.Clock = clock
+	cfg.ReceiveTimeout = 30 * time.Second
+	cfg.PingInterval = time.Second
+	cfg.PingTimeout = 5 * time.Second
+
+	client, server := nettest.Pipe(clock, 16)
+	handler := &chanHandler{ch: make(chan *network.ConnMessage, 16)}
+	conn := network.NewConn(&nettest.Server{Ctx: ctx, Cfg: cfg}, server, handler).(*network.Conn)
+	conn.Start()
+	// 读取截止时间和心跳计时器
+	clock.BlockUntil(2)
+	return client, conn
+}
+
+func TestConnPingMeasuresRTT(t *testing.T) {
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	client, conn := newPingConn(t, clock)
+
+	clock.Advance(time.Second)
+	ping := readMessage(t, client).GetS2CPing()
+	if ping == nil || ping.GetSeq() != 1 {
+		t.Fatalf("got %v, want first ping", ping)
+	}
+
+	clock.Advance(80 * time.Millisecond)
+	data, _ := proto.Marshal(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SPong{C2SPong: &pb.C2S_Pong{Seq: ping.GetSeq(), Timestamp: ping.GetTimestamp()}},
+	})
+	client.Write(data)
+
+	deadline := time.Now().Add(time.Second)
+	for conn.Stats().RTT == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pong was not processed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats := conn.Stats(); stats.RTT != 80*time.Millisecond || stats.Loss != 0 {
+		t.Fatalf("stats = %+v, want rtt 80ms without loss", stats)
+	}
+
+	// 下一次心跳带上测得的网络状况
+	clock.Advance(920 * time.Millisecond)
+	if stats := readMessage(t, client).GetS2CPing().GetStats(); stats.GetRttMs() != 80 {
+		t.Fatalf("ping stats = %v, want rtt 80ms", stats)
+	}
+}
+
+func TestConnPingTimeoutClosesConn(t *testing.T) {
+	clock := nettest.NewManualClock(time.Unix(0, 0))
+	client, conn := newPingConn(t, clock)
+
+	for i := 0; ; i++ {
+		if i == 20 {
+			t.Fatal("connection without pongs was not closed")
+		}
+		clock.Advance(time.Second)
+		select {
+		case <-conn.Done():
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+	if clock.Now().Sub(time.Unix(0, 0)) >= 30*time.Second {
+		t.Fatalf("closed after %v, want before the receive timeout", clock.Now().Sub(time.Unix(0, 0)))
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		if _, err := client.Read(buf); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				t.Fatalf("Read = %v, want %v", err, net.ErrClosed)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package nettest
+
+import (
+	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
+	"fmt"
+	"time"
+)
+
+// DefaultTimeout 脚本客户端等待服务器回复的默认时长
+const DefaultTimeout = 2 * time.Second
+
+// Client 脚本化的假客户端
+// 封装了协议中客户端会发送的报文，并记录收到的所有回复
+type Client struct {
+	PlayerID string
+	Conn     *FakeConn
+	Timeout  time.Duration
+
+	received []*pb.MessageWrapper
+}
+
+// NewClient 创建一个连接到handler的客户端
+func NewClient(playerID string, handler network.IConnHandler) *Client {
+	return &Client{
+		PlayerID: playerID,
+		Conn:     NewFakeConn(handler, 1024),
+		Timeout:  DefaultTimeout,
+	}
+}
+
+func (c *Client) CreateRoom() {
+	c.CreateRoomWithMode("")
+}
+
+// CreateRoomWithMode 创建指定模式的房间
+func (c *Client) CreateRoomWithMode(mode string) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SCreateRoom{
+			C2SCreateRoom: &pb.C2S_CreateRoom{PlayerId: c.PlayerID, Mode: mode},
+		},
+	})
+}
+
+// SetTarget 在大逃杀中选择攻击目标策略
+func (c *Client) SetTarget(strategy pb.TargetStrategy) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SSetTarget{
+			C2SSetTarget: &pb.C2S_SetTarget{PlayerId: c.PlayerID, Strategy: strategy},
+		},
+	})
+}
+
+func (c *Client) EnterRoom(roomID string) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SEnterRoom{
+			C2SEnterRoom: &pb.C2S_EnterRoom{RoomId: roomID, PlayerId: c.PlayerID},
+		},
+	})
+}
+
+func (c *Client) ExitRoom(roomID string) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SExitRoom{
+			C2SExitRoom: &pb.C2S_ExitRoom{RoomId: roomID, PlayerId: c.PlayerID},
+		},
+	})
+}
+
+// SetTeam 作为房主把playerID分到team队
+func (c *Client) SetTeam(roomID, playerID string, team int32) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SSetTeam{
+			C2SSetTeam: &pb.C2S_SetTeam{RoomId: roomID, PlayerId: c.PlayerID, TargetId: playerID, Team: team},
+		},
+	})
+}
+
+// SetPausePolicy 作为房主设置房间的暂停规则
+func (c *Client) SetPausePolicy(roomID string, policy *pb.PausePolicy) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SSetPausePolicy{
+			C2SSetPausePolicy: &pb.C2S_SetPausePolicy{RoomId: roomID, PlayerId: c.PlayerID, Policy: policy},
+		},
+	})
+}
+
+// SetDisconnectPolicy 作为房主设置房间的断线规则
+func (c *Client) SetDisconnectPolicy(roomID string, policy *pb.DisconnectPolicy) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SSetDisconnectPolicy{
+			C2SSetDisconnectPolicy: &pb.C2S_SetDisconnectPolicy{RoomId: roomID, PlayerId: c.PlayerID, Policy: policy},
+		},
+	})
+}
+
+// Disconnect 关闭当前连接，模拟客户端断线
+func (c *Client) Disconnect() {
+	c.Conn.Close()
+}
+
+// Rejoin 用连接到handler的新连接请求回到roomID中进行的游戏
+func (c *Client) Rejoin(handler network.IConnHandler, roomID string) {
+	c.Conn = NewFakeConn(handler, 1024)
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SRejoin{
+			C2SRejoin: &pb.C2S_Rejoin{RoomId: roomID, PlayerId: c.PlayerID},
+		},
+	})
+}
+
+// Pause 请求暂停、同意或拒绝暂停投票、请求恢复
+func (c *Client) Pause(action pb.PauseAction) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SPause{
+			C2SPause: &pb.C2S_Pause{PlayerId: c.PlayerID, Action: action},
+		},
+	})
+}
+
+// Leaderboard 查询mode排行榜的前top名和自己前后各around名
+func (c *Client) Leaderboard(mode string, top, around int32) {
+	c.LeaderboardPage(mode, 0, 0, top, around)
+}
+
+// LeaderboardPage 查询指定赛季从offset开始的top名和自己前后各around名
+func (c *Client) LeaderboardPage(mode string, season, offset, top, around int32) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SLeaderboard{
+			C2SLeaderboard: &pb.C2S_Leaderboard{
+				PlayerId: c.PlayerID, Mode: mode, Top: top, Around: around, Season: season, Offset: offset,
+			},
+		},
+	})
+}
+
+func (c *Client) StartGame(roomID string) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SStartGame{
+			C2SStartGame: &pb.C2S_StartGame{RoomId: roomID, PlayerId: c.PlayerID},
+		},
+	})
+}
+
+func (c *Client) Heartbeat() {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SHeartbeat{
+			C2SHeartbeat: &pb.C2S_Heartbeat{PlayerId: c.PlayerID},
+		},
+	})
+}
+
+func (c *Client) LoadComplete(payload []byte) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SGameLoadComplete{
+			C2SGameLoadComplete: &pb.C2S_GameLoadComplete{PlayerId: c.PlayerID, Payload: payload},
+		},
+	})
+}
+
+func (c *Client) Input(operations []byte) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SInput{
+			C2SInput: &pb.C2S_Input{PlayerId: c.PlayerID, Operations: operations},
+		},
+	})
+}
+
+// InputAt 发送标记了帧号的输入
+func (c *Client) InputAt(frame int32, operations []byte) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SInput{
+			C2SInput: &pb.C2S_Input{PlayerId: c.PlayerID, Operations: operations, FrameNumber: &frame},
+		},
+	})
+}
+
+// StateHash 上报模拟完frame帧后的状态哈希
+func (c *Client) StateHash(frame int32, hash uint64) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SStateHash{
+			C2SStateHash: &pb.C2S_StateHash{PlayerId: c.PlayerID, FrameNumber: frame, Hash: hash},
+		},
+	})
+}
+
+func (c *Client) GameEnd(endGame bool, payload []byte) {
+	c.Conn.Send(&pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_C2SGameEnd{
+			C2SGameEnd: &pb.C2S_GameEnd{PlayerId: c.PlayerID, EndGame: endGame, Payload: payload},
+		},
+	})
+}
+
+// Next 返回服务器发来的下一条消息
+func (c *Client) Next() (*pb.MessageWrapper, error) {
+	msg, err := c.Conn.Recv(c.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("player %s: %w", c.PlayerID, err)
+	}
+	c.received = append(c.received, msg)
+	return msg, nil
+}
+
+// WaitFor 丢弃不满足match的消息，直到收到满足条件的消息或超时
+func (c *Client) WaitFor(match func(*pb.MessageWrapper) bool) (*pb.MessageWrapper, error) {
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("player %s: expected message not received in %v", c.PlayerID, c.Timeout)
+		}
+		msg, err := c.Conn.Recv(remaining)
+		if err != nil {
+			return nil, fmt.Errorf("player %s: %w", c.PlayerID, err)
+		}
+		c.received = append(c.received, msg)
+		if match(msg) {
+			return msg, nil
+		}
+	}
+}
+
+// Received 返回该客户端至今读出的所有消息
+func (c *Client) Received() []*pb.MessageWrapper {
+	return c.received
+}
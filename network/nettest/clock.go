@@ -0,0 +1,174 @@
+package nettest
+
+import (
+	"TetrisSvr/network"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManualClock 手动推进的network.Clock实现
+// 时间只在调用Advance时前进，到期的计时器按到期顺序依次触发，
+// 可以用来快速、确定地模拟大量的游戏帧
+type ManualClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*manualWaiter
+}
+
+// manualWaiter 挂在ManualClock上的Timer或Ticker
+type manualWaiter struct {
+	clock  *ManualClock
+	when   time.Time
+	period time.Duration // 大于0表示Ticker
+	c      chan time.Time
+	fn     func()
+}
+
+// NewManualClock 创建一个从start开始的手动时钟
+func NewManualClock(start time.Time) *ManualClock {
+	c := &ManualClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTicker(d time.Duration) network.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &manualWaiter{clock: c, period: d, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return manualTicker{w}
+}
+
+func (c *ManualClock) NewTimer(d time.Duration) network.Timer {
+	w := &manualWaiter{clock: c, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return w
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) network.Timer {
+	w := &manualWaiter{clock: c, fn: f}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(w, d)
+	return w
+}
+
+// Advance 将时间前进d，期间到期的计时器按顺序触发
+// AfterFunc的回调在调用Advance的协程中同步执行
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		w := c.next(target)
+		if w == nil {
+			break
+		}
+		c.now = w.when
+		c.remove(w)
+		if w.period > 0 {
+			c.schedule(w, w.period)
+		}
+
+		if w.fn != nil {
+			c.mu.Unlock()
+			w.fn()
+			c.mu.Lock()
+			continue
+		}
+		// 与time.Ticker一样，接收方来不及处理时丢弃这次触发
+		select {
+		case w.c <- c.now:
+		default:
+		}
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// BlockUntil 阻塞直到时钟上至少有n个未触发的计时器
+// 用于确认被测协程已经开始等待，再推进时间
+func (c *ManualClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// Waiters 返回时钟上未触发的计时器数量
+func (c *ManualClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// next 返回target之前最早到期的计时器，调用方需持有锁
+func (c *ManualClock) next(target time.Time) *manualWaiter {
+	if len(c.waiters) == 0 || c.waiters[0].when.After(target) {
+		return nil
+	}
+	return c.waiters[0]
+}
+
+// schedule 调用方需持有锁
+func (c *ManualClock) schedule(w *manualWaiter, d time.Duration) {
+	w.when = c.now.Add(d)
+	c.waiters = append(c.waiters, w)
+	// 稳定排序保证同时到期的计时器按创建顺序触发
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].when.Before(c.waiters[j].when)
+	})
+	c.cond.Broadcast()
+}
+
+// remove 调用方需持有锁，返回w是否仍在等待
+func (c *ManualClock) remove(w *manualWaiter) bool {
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// manualTicker 的Stop没有返回值，以满足network.Ticker
+type manualTicker struct {
+	*manualWaiter
+}
+
+func (t manualTicker) Stop() {
+	t.manualWaiter.Stop()
+}
+
+func (w *manualWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *manualWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *manualWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.clock.remove(w)
+	w.clock.schedule(w, d)
+	return active
+}
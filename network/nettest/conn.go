@@ -0,0 +1,125 @@
+package nettest
+
+import (
+	"TetrisSvr/logging"
+	"TetrisSvr/network"
+	pb "TetrisSvr/proto"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var nextFakeConnID atomic.Uint64
+
+// Server 满足network.IServer，用于在测试中直接创建network.Conn
+type Server struct {
+	Ctx context.Context
+	Cfg *network.Config
+}
+
+func (s *Server) Context() context.Context {
+	return s.Ctx
+}
+
+func (s *Server) Config() *network.Config {
+	return s.Cfg
+}
+
+// FakeConn 是network.IConn的内存实现
+// 服务器写入SendChan的消息由测试通过Recv读出，
+// 测试通过Send把消息投递给当前的handler，与Conn.ReceiveLoop的行为一致
+type FakeConn struct {
+	id       string
+	mu       sync.Mutex
+	handler  network.IConnHandler
+	stats    network.NetStats
+	sendChan chan *pb.MessageWrapper
+	done     chan struct{}
+	closed   sync.Once
+}
+
+// NewFakeConn 创建一个初始由handler处理的假连接
+// size为服务器发往该连接的消息缓存数量
+func NewFakeConn(handler network.IConnHandler, size int) *FakeConn {
+	id := fmt.Sprintf("fake%d", nextFakeConnID.Add(1))
+	return &FakeConn{
+		id:       id,
+		handler:  handler,
+		sendChan: make(chan *pb.MessageWrapper, size),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *FakeConn) SetHandler(handler network.IConnHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handler = handler
+}
+
+// Handler 返回当前处理该连接消息的handler
+func (c *FakeConn) Handler() network.IConnHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handler
+}
+
+func (c *FakeConn) SendChan() chan<- *pb.MessageWrapper {
+	return c.sendChan
+}
+
+func (c *FakeConn) Start() {}
+
+func (c *FakeConn) ID() string {
+	return c.id
+}
+
+func (c *FakeConn) LogAttrs() []any {
+	return []any{logging.KeyConn, c.id}
+}
+
+func (c *FakeConn) Stats() network.NetStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// SetStats 设置Stats返回的网络状况
+func (c *FakeConn) SetStats(stats network.NetStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = stats
+}
+
+func (c *FakeConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close 模拟连接中断，之后Done被关闭
+func (c *FakeConn) Close() {
+	c.closed.Do(func() { close(c.done) })
+}
+
+// Send 模拟客户端发送一条消息
+func (c *FakeConn) Send(msg *pb.MessageWrapper) {
+	c.Handler().HandleChan() <- network.NewConnMessage(c, msg)
+}
+
+// Recv 等待服务器发往该连接的下一条消息
+func (c *FakeConn) Recv(timeout time.Duration) (*pb.MessageWrapper, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-c.sendChan:
+		return msg, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no message received in %v", timeout)
+	}
+}
+
+// Pending 返回当前已缓存但尚未读取的消息数量
+func (c *FakeConn) Pending() int {
+	return len(c.sendChan)
+}
@@ -0,0 +1,189 @@
+package nettest
+
+import (
+	"TetrisSvr/network"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeAddr 内存管道的地址，仅用于满足net.Conn接口
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// deadline 可重置的截止时间，到期后关闭wait返回的通道
+type deadline struct {
+	mu     sync.Mutex
+	clock  network.Clock
+	timer  network.Timer
+	cancel chan struct{}
+}
+
+func newDeadline(clock network.Clock) *deadline {
+	return &deadline{clock: clock, cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// 计时器已经触发，等待其关闭cancel
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := t.Sub(d.clock.Now()); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = d.clock.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// PipeConn 内存中的数据报连接
+// 与net.Pipe不同，每次Write都会作为一个完整的包被一次Read读出，
+// 与kcp会话的消息边界语义一致
+type PipeConn struct {
+	name  string
+	peer  string
+	in    <-chan []byte
+	out   chan<- []byte
+	done  chan struct{}
+	close *sync.Once
+
+	peerDone <-chan struct{}
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+// Pipe 创建一对互相连接的内存数据报连接
+// size为每个方向可缓存的包数量，读写截止时间由clock计时
+func Pipe(clock network.Clock, size int) (*PipeConn, *PipeConn) {
+	a2b := make(chan []byte, size)
+	b2a := make(chan []byte, size)
+	aDone := make(chan struct{})
+	bDone := make(chan struct{})
+
+	a := &PipeConn{
+		name:          "client",
+		peer:          "server",
+		in:            b2a,
+		out:           a2b,
+		done:          aDone,
+		close:         &sync.Once{},
+		peerDone:      bDone,
+		readDeadline:  newDeadline(clock),
+		writeDeadline: newDeadline(clock),
+	}
+	b := &PipeConn{
+		name:          "server",
+		peer:          "client",
+		in:            a2b,
+		out:           b2a,
+		done:          bDone,
+		close:         &sync.Once{},
+		peerDone:      aDone,
+		readDeadline:  newDeadline(clock),
+		writeDeadline: newDeadline(clock),
+	}
+	return a, b
+}
+
+func (c *PipeConn) Read(b []byte) (int, error) {
+	if isClosed(c.done) {
+		return 0, net.ErrClosed
+	}
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case packet := <-c.in:
+		return copy(b, packet), nil
+	case <-c.peerDone:
+		// 对端关闭后仍然读出已缓存的包
+		select {
+		case packet := <-c.in:
+			return copy(b, packet), nil
+		default:
+			return 0, net.ErrClosed
+		}
+	}
+}
+
+func (c *PipeConn) Write(b []byte) (int, error) {
+	packet := make([]byte, len(b))
+	copy(packet, b)
+
+	if isClosed(c.done) || isClosed(c.peerDone) {
+		return 0, net.ErrClosed
+	}
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.peerDone:
+		return 0, net.ErrClosed
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case c.out <- packet:
+		return len(b), nil
+	}
+}
+
+func (c *PipeConn) Close() error {
+	c.close.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *PipeConn) LocalAddr() net.Addr  { return pipeAddr(c.name) }
+func (c *PipeConn) RemoteAddr() net.Addr { return pipeAddr(c.peer) }
+
+func (c *PipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *PipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *PipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
@@ -0,0 +1,83 @@
+package nettest
+
+import (
+	"TetrisSvr/network"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPipeKeepsPacketBoundaries(t *testing.T) {
+	a, b := Pipe(network.RealClock{}, 4)
+	a.Write([]byte("hello"))
+	a.Write([]byte("world"))
+
+	buf := make([]byte, 64)
+	for _, want := range []string{"hello", "world"} {
+		n, err := b.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Fatalf("Read = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPipeReadDeadline(t *testing.T) {
+	a, b := Pipe(network.RealClock{}, 1)
+	a.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := a.Read(make([]byte, 8)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// 重置截止时间后可以继续读取
+	a.SetReadDeadline(time.Time{})
+	go func() { time.Sleep(10 * time.Millisecond); b.Write([]byte("x")) }()
+	if _, err := a.Read(make([]byte, 8)); err != nil {
+		t.Fatalf("Read after reset = %v", err)
+	}
+}
+
+func TestPipeDeadlineFollowsClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	a, _ := Pipe(clock, 1)
+	a.SetReadDeadline(clock.Now().Add(time.Second))
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := a.Read(make([]byte, 8))
+		errCh <- err
+	}()
+
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case err := <-errCh:
+		t.Fatalf("Read returned %v before the deadline", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	if err := <-errCh; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := Pipe(network.RealClock{}, 1)
+	a.Write([]byte("last"))
+	a.Close()
+
+	buf := make([]byte, 8)
+	if n, err := b.Read(buf); err != nil || string(buf[:n]) != "last" {
+		t.Fatalf("Read buffered packet = %q, %v", buf[:n], err)
+	}
+	if _, err := b.Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Read after peer close = %v, want %v", err, net.ErrClosed)
+	}
+	if _, err := b.Write(buf); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Write after peer close = %v, want %v", err, net.ErrClosed)
+	}
+}
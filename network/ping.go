@@ -0,0 +1,124 @@
+package network
+
+import (
+	pb "TetrisSvr/proto"
+	"sync"
+	"time"
+)
+
+// NetStats 连接的网络状况，由服务器发起的心跳测得
+type NetStats struct {
+	// RTT 平滑后的往返时间
+	RTT time.Duration
+	// Jitter 往返时间的平均偏差
+	Jitter time.Duration
+	// Loss 平滑后的心跳丢失率，0到1之间
+	Loss float64
+	// LastSeen 最后一次收到该连接消息的时间
+	LastSeen time.Time
+}
+
+// Proto 转换为发给客户端的NetStats
+func (s NetStats) Proto() *pb.NetStats {
+	return &pb.NetStats{
+		RttMs:    uint32(s.RTT.Milliseconds()),
+		JitterMs: uint32(s.Jitter.Milliseconds()),
+		Loss:     float32(s.Loss),
+	}
+}
+
+// pinger 记录发出的心跳并根据回复计算网络状况
+// ping在SendLoop中调用，pong和seen在ReceiveLoop中调用
+type pinger struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	seq     uint32
+	pending map[uint32]time.Time
+	stats   NetStats
+	hasRTT  bool
+}
+
+func newPinger(now time.Time, timeout time.Duration) *pinger {
+	return &pinger{
+		timeout: timeout,
+		pending: make(map[uint32]time.Time),
+		stats:   NetStats{LastSeen: now},
+	}
+}
+
+// ping 返回下一条心跳消息，超过timeout未回复的心跳计为丢失
+func (p *pinger) ping(now time.Time) *pb.MessageWrapper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for seq, sent := range p.pending {
+		if now.Sub(sent) >= p.timeout {
+			delete(p.pending, seq)
+			p.sampleLoss(1)
+		}
+	}
+	p.seq++
+	p.pending[p.seq] = now
+	return &pb.MessageWrapper{
+		Msg: &pb.MessageWrapper_S2CPing{
+			S2CPing: &pb.S2C_Ping{
+				Seq:       p.seq,
+				Timestamp: now.UnixMilli(),
+				Stats:     p.stats.Proto(),
+			},
+		},
+	}
+}
+
+// pong 处理客户端的回复，未知或已过期的seq直接忽略
+// 平滑方式与TCP的SRTT和RTTVAR相同
+func (p *pinger) pong(now time.Time, seq uint32) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sent, ok := p.pending[seq]
+	if !ok {
+		return 0, false
+	}
+	delete(p.pending, seq)
+	rtt := now.Sub(sent)
+	if !p.hasRTT {
+		p.stats.RTT = rtt
+		p.stats.Jitter = rtt / 2
+		p.hasRTT = true
+	} else {
+		diff := p.stats.RTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		p.stats.Jitter = (3*p.stats.Jitter + diff) / 4
+		p.stats.RTT = (7*p.stats.RTT + rtt) / 8
+	}
+	p.sampleLoss(0)
+	return rtt, true
+}
+
+// sampleLoss 调用方需持有mu
+func (p *pinger) sampleLoss(lost float64) {
+	p.stats.Loss = 0.9*p.stats.Loss + 0.1*lost
+}
+
+// seen 记录收到消息的时间
+func (p *pinger) seen(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.LastSeen = now
+}
+
+// silent 返回超过timeout没有收到任何消息
+func (p *pinger) silent(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return now.Sub(p.stats.LastSeen) >= p.timeout
+}
+
+func (p *pinger) current() NetStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
@@ -0,0 +1,47 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPingerSmoothing(t *testing.T) {
+	start := time.Unix(0, 0)
+	p := newPinger(start, 5*time.Second)
+
+	now := start
+	for _, rtt := range []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 300 * time.Millisecond} {
+		seq := p.ping(now).GetS2CPing().GetSeq()
+		now = now.Add(rtt)
+		if got, ok := p.pong(now, seq); !ok || got != rtt {
+			t.Fatalf("pong(%d) = %v, %v", seq, got, ok)
+		}
+	}
+	stats := p.current()
+	// 100, 100, 300: srtt = (7*100 + 300) / 8 = 125ms
+	if stats.RTT != 125*time.Millisecond {
+		t.Errorf("rtt = %v, want 125ms", stats.RTT)
+	}
+	if stats.Jitter <= 0 || stats.Loss != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// 重复或未知的回复不影响统计
+	if _, ok := p.pong(now, 1); ok {
+		t.Error("duplicate pong accepted")
+	}
+
+	p.ping(now)
+	now = now.Add(5 * time.Second)
+	p.ping(now)
+	if loss := p.current().Loss; loss <= 0 {
+		t.Errorf("loss = %v after an expired ping", loss)
+	}
+	if !p.silent(now) {
+		t.Error("pinger not silent after timeout without messages")
+	}
+	p.seen(now)
+	if p.silent(now) {
+		t.Error("pinger silent right after a message")
+	}
+}
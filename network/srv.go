@@ -1,17 +1,36 @@
 package network
 
 import (
+	"TetrisSvr/logging"
+	"TetrisSvr/metrics"
 	"context"
+	"errors"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	log "github.com/jeanphorn/log4go"
 	"github.com/xtaci/kcp-go"
 )
 
+// 接受连接失败后重试的最短和最长间隔
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
 type Server struct {
 	ctx     context.Context
 	config  *Config
 	handler IConnHandler
+	log     *slog.Logger
+
+	lis       net.Listener
+	accepting atomic.Bool
+	closed    chan struct{}
+	closeOnce sync.Once
+	conns     sync.WaitGroup
 }
 
 func (m *Server) Context() context.Context {
@@ -29,28 +48,98 @@ func (m *Server) Server(kcpAddr string) error {
 	if err != nil {
 		return err
 	}
+	m.Serve(lis)
+	return nil
+}
+
+// Serve 在lis上接受连接，lis由Server持有并在Close时关闭
+func (m *Server) Serve(lis net.Listener) {
+	m.lis = lis
+	m.accepting.Store(true)
 
 	go func() {
+		var delay time.Duration
 		for {
 			conn, err := lis.Accept()
-			if err == net.ErrClosed {
-				break
-			} else if err != nil {
-				log.Error("接受连接失败: %v", err)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				// 其他错误通常是暂时的，逐渐延长重试间隔，避免空转
+				delay = min(max(2*delay, minAcceptDelay), maxAcceptDelay)
+				m.log.Error("接受连接失败", logging.KeyError, err, "retry", delay)
+				select {
+				case <-m.closed:
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+			delay = 0
+			if !m.accepting.Load() {
+				// kcp的会话共用监听端口，关闭期间不能关闭监听器，只能逐个拒绝新连接
+				m.log.Info("服务器正在关闭，拒绝连接", logging.KeyRemote, conn.RemoteAddr().String())
+				conn.Close()
 				continue
 			}
-			NewConn(m, conn, m.handler).Start()
+			if s, ok := conn.(*kcp.UDPSession); ok {
+				m.config.KCP.apply(s)
+			}
+			c := newConn(m, conn, m.handler)
+			c.log().Info("接受连接")
+			metrics.ConnectionsAccepted.Inc()
+			metrics.ConnectionsActive.Inc()
+			m.conns.Add(1)
+			go func() {
+				<-c.Done()
+				metrics.ConnectionsActive.Dec()
+				m.conns.Done()
+			}()
+			c.Start()
 		}
 	}()
+}
+
+// StopAccepting 拒绝之后的新连接，已有连接不受影响
+func (m *Server) StopAccepting() {
+	m.accepting.Store(false)
+}
+
+// Wait 等待所有连接关闭，ctx结束时返回ctx.Err()
+func (m *Server) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.conns.Wait()
+		close(done)
+	}()
 
-	return nil
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 关闭监听器，之后所有连接都无法再收发数据
+func (m *Server) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		if m.lis != nil {
+			err = m.lis.Close()
+		}
+	})
+	return err
 }
 
-func NewServer(ctx context.Context, config *Config, handler IConnHandler) Server {
-	server := Server{
+func NewServer(ctx context.Context, config *Config, handler IConnHandler) *Server {
+	server := &Server{
 		ctx:     ctx,
 		config:  config,
 		handler: handler,
+		log:     logging.For(logging.Network),
+		closed:  make(chan struct{}),
 	}
 	// server.Server(kcpAddr)
 	return server
@@ -0,0 +1,58 @@
+package network_test
+
+import (
+	"TetrisSvr/network"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingListener 关闭前每次Accept都返回暂时性的错误
+type failingListener struct {
+	accepts   atomic.Int32
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	default:
+		return nil, errors.New("too many open files")
+	}
+}
+
+func (l *failingListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *failingListener) Addr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func TestServeBacksOffOnAcceptErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lis := &failingListener{closed: make(chan struct{})}
+	server := network.NewServer(ctx, testConfig(), &chanHandler{})
+	server.Serve(lis)
+
+	time.Sleep(100 * time.Millisecond)
+	// 5ms开始翻倍，100ms内最多重试5次左右
+	if n := lis.accepts.Load(); n > 10 {
+		t.Fatalf("Accept called %d times in 100ms, want backoff", n)
+	}
+	server.Close()
+	n := lis.accepts.Load()
+	time.Sleep(100 * time.Millisecond)
+	if m := lis.accepts.Load(); m > n+1 {
+		t.Fatalf("Accept called %d more times after Close", m-n)
+	}
+}